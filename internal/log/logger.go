@@ -40,9 +40,13 @@ func SetLogLevel(level string) {
 }
 
 // CreateLogger will create a logrus logger with the desired log level.
+// The logger shares the formatter and the hooks of the standard logger.
 func CreateLogger(logLevel string) *logrus.Logger {
 	logger := logrus.New()
 	logger.Formatter = logrus.StandardLogger().Formatter
+	for level, hooks := range logrus.StandardLogger().Hooks {
+		logger.Hooks[level] = append([]logrus.Hook(nil), hooks...)
+	}
 	level, err := logrus.ParseLevel(logLevel)
 	if err != nil {
 		logrus.Warnf("Unable to setup log level %s - defaulting to INFO.", logLevel)
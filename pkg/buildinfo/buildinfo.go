@@ -0,0 +1,95 @@
+// Package buildinfo provides facilities for reporting how a binary was built.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// The revision and build time can be injected with ldflags e.g.
+// -X github.com/puppetlabs/go-libs/pkg/buildinfo.sha1ver=${COMMIT}
+// -X github.com/puppetlabs/go-libs/pkg/buildinfo.buildTime=${NOW}.
+var (
+	mu        sync.RWMutex
+	sha1ver   string
+	buildTime string
+)
+
+// Info describes how the running binary was built.
+type Info struct {
+	Path         string   `json:"path,omitempty"`         // The path of the main package.
+	Version      string   `json:"version,omitempty"`      // The version of the main module e.g. v1.2.3 or (devel).
+	Revision     string   `json:"revision,omitempty"`     // The commit the binary was built from.
+	BuildTime    string   `json:"build_time,omitempty"`   // When the binary was built, or the time of the commit.
+	Modified     bool     `json:"modified"`               // True if the working tree had local changes.
+	GoVersion    string   `json:"go_version"`             // The version of Go the binary was built with.
+	Dependencies []Module `json:"dependencies,omitempty"` // The modules the binary was built with.
+}
+
+// Module is a module the binary was built with.
+type Module struct {
+	Path    string `json:"path"`              // The module path.
+	Version string `json:"version"`           // The module version.
+	Replace string `json:"replace,omitempty"` // The path of the module replacing it if any.
+}
+
+// Set records the values injected into package main with ldflags, e.g. -X main.sha1ver=${COMMIT}, so that main can
+// pass them on with buildinfo.Set(sha1ver, buildTime). Empty values are ignored.
+func Set(revision string, time string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if revision != "" {
+		sha1ver = revision
+	}
+
+	if time != "" {
+		buildTime = time
+	}
+}
+
+// Get returns the build info of the running binary. The revision and build time injected with ldflags take
+// precedence over those recorded by the go command.
+func Get() Info {
+	info := Info{GoVersion: runtime.Version()}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		info.Path = buildInfo.Path
+		info.Version = buildInfo.Main.Version
+		info.GoVersion = buildInfo.GoVersion
+
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.time":
+				info.BuildTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+
+		for _, dep := range buildInfo.Deps {
+			module := Module{Path: dep.Path, Version: dep.Version}
+			if dep.Replace != nil {
+				module.Replace = dep.Replace.Path
+				module.Version = dep.Replace.Version
+			}
+			info.Dependencies = append(info.Dependencies, module)
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if sha1ver != "" {
+		info.Revision = sha1ver
+	}
+
+	if buildTime != "" {
+		info.BuildTime = buildTime
+	}
+
+	return info
+}
@@ -0,0 +1,42 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGetMergesLdflagsValues(t *testing.T) {
+	info := Get()
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("Expected Go version %s but got %s.", runtime.Version(), info.GoVersion)
+	}
+
+	Set("abc123", "2024-01-02T03:04:05Z")
+	t.Cleanup(func() {
+		sha1ver = ""
+		buildTime = ""
+	})
+
+	info = Get()
+	if info.Revision != "abc123" || info.BuildTime != "2024-01-02T03:04:05Z" {
+		t.Errorf("Expected the ldflags values to be used but got %s %s.", info.Revision, info.BuildTime)
+	}
+
+	Set("", "")
+	if info := Get(); info.Revision != "abc123" {
+		t.Errorf("Empty values should be ignored but got %s.", info.Revision)
+	}
+}
+
+func TestGetIncludesDependencies(t *testing.T) {
+	info := Get()
+	if info.Path == "" {
+		t.Error("Expected the path of the main package.")
+	}
+
+	for _, dep := range info.Dependencies {
+		if dep.Path == "" || dep.Version == "" {
+			t.Errorf("Unexpected dependency %+v.", dep)
+		}
+	}
+}
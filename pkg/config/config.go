@@ -104,6 +104,7 @@ func customUnmarshal(cfg interface{}, v *viper.Viper) error {
 		WeaklyTypedInput: true,
 		DecodeHook: mapstructure.ComposeDecodeHookFunc(
 			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
 		),
 	}
 
@@ -266,3 +266,26 @@ func TestMandatorySet(t *testing.T) {
 	err = LoadViperConfig(&actual)
 	assert.Error(t, err)
 }
+
+// StructWithList holds a list field.
+type StructWithList struct {
+	TestList []string `default:"a" env:"TEST_LIST"`
+}
+
+func TestListValues(t *testing.T) {
+	os.Clearenv()
+
+	var actual StructWithList
+	err := LoadViperConfig(&actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, actual.TestList)
+
+	err = os.Setenv("TEST_LIST", "x,y,z")
+	if err != nil {
+		t.Errorf("Unexpected error occurred %s.", err)
+	}
+
+	err = LoadViperConfig(&actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x", "y", "z"}, actual.TestList)
+}
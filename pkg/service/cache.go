@@ -0,0 +1,577 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCacheTTL        = time.Minute
+	defaultCacheMaxEntries = 1000
+	cacheTagsKey           = "cacheTags"
+	cacheResultHit         = "hit"
+	cacheResultMiss        = "miss"
+	headerIfNoneMatch      = "If-None-Match"
+	headerIfModifiedSince  = "If-Modified-Since"
+	headerLastModified     = "Last-Modified"
+	headerSetCookie        = "Set-Cookie"
+	headerAuthorization    = "Authorization"
+)
+
+// CacheConfig specifies the HTTP caching behaviour of a handler. Only GET and HEAD requests with a 200 response are
+// cached. ETags are generated from the response body unless the handler sets one.
+//
+// A stored response is served to the requests with the same key, principal and values of the headers named in its
+// Vary. Responses with a Cache-Control of no-store or a Vary of * are never stored. Responses which are private, or
+// to requests with an Authorization header unless public, are only stored when there is a Principal. Only the headers
+// set by the handler are stored, and never Set-Cookie, so that those of outer middleware are not replayed.
+type CacheConfig struct {
+	WeakETag     bool                        // If true, weak ETags are generated instead of strong ones.
+	CacheControl string                      // Optional - Cache-Control set unless the handler sets one e.g. max-age=60.
+	Store        *ResponseCache              // Optional - if set, full responses are stored and served from it.
+	TTL          time.Duration               // Optional - how long stored responses are served for. Default 1 minute.
+	Key          func(c *gin.Context) string // Optional - the key responses are stored under. Default path and query.
+	Principal    func(c *gin.Context) string // Optional - who the response is for e.g. the user ID.
+	Tags         []string                    // Optional - tags stored responses are given, for use with InvalidateTags.
+}
+
+// ResponseCache is an in memory LRU store of responses which can be shared between handlers.
+type ResponseCache struct {
+	name       string
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	vary       map[string]*varyScope
+}
+
+// varyScope holds the names of the request headers the responses for a principal's key vary by, for as long as any
+// of its responses are stored.
+type varyScope struct {
+	names   []string
+	entries int
+}
+
+// cachedResponse is a response held in a ResponseCache.
+type cachedResponse struct {
+	key          string
+	base         string
+	scope        string
+	status       int
+	header       http.Header
+	body         []byte
+	lastModified time.Time
+	expires      time.Time
+	tags         []string
+}
+
+// NewResponseCache creates a response cache holding up to maxEntries responses. The name is used as the cache label
+// on the hit and miss metrics.
+func NewResponseCache(name string, maxEntries int) *ResponseCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+
+	return &ResponseCache{
+		name:       name,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		vary:       make(map[string]*varyScope),
+	}
+}
+
+// varyNames returns the names of the request headers the last response stored for the principal's key varied by.
+func (rc *ResponseCache) varyNames(scope string) []string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if vary, found := rc.vary[scope]; found {
+		return vary.names
+	}
+
+	return nil
+}
+
+// get returns the unexpired response stored under the key.
+func (rc *ResponseCache) get(key string) (*cachedResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	element, found := rc.entries[key]
+	if !found {
+		return nil, false
+	}
+
+	entry, _ := element.Value.(*cachedResponse)
+	if time.Now().After(entry.expires) {
+		rc.remove(element)
+
+		return nil, false
+	}
+	rc.lru.MoveToFront(element)
+
+	return entry, true
+}
+
+// set stores a response along with the names of the request headers it varies by, evicting the least recently used
+// response if the cache is full.
+func (rc *ResponseCache) set(entry *cachedResponse, varyNames []string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if element, found := rc.entries[entry.key]; found {
+		rc.remove(element)
+	}
+
+	vary, found := rc.vary[entry.scope]
+	if !found {
+		vary = &varyScope{}
+		rc.vary[entry.scope] = vary
+	}
+	vary.names = varyNames
+	vary.entries++
+
+	rc.entries[entry.key] = rc.lru.PushFront(entry)
+	for rc.lru.Len() > rc.maxEntries {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// remove must be called with the lock held. The vary names of the principal's key are forgotten with its last response.
+func (rc *ResponseCache) remove(element *list.Element) {
+	entry, _ := element.Value.(*cachedResponse)
+	delete(rc.entries, entry.key)
+	rc.lru.Remove(element)
+
+	if vary, found := rc.vary[entry.scope]; found {
+		if vary.entries--; vary.entries == 0 {
+			delete(rc.vary, entry.scope)
+		}
+	}
+}
+
+// Invalidate removes the responses stored under the keys, for every principal and variant. By default a key is the
+// request path plus any query.
+func (rc *ResponseCache) Invalidate(keys ...string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for element := rc.lru.Front(); element != nil; {
+		next := element.Next()
+		entry, _ := element.Value.(*cachedResponse)
+		if containsString(keys, entry.base) {
+			rc.remove(element)
+		}
+		element = next
+	}
+}
+
+// InvalidateTags removes every response with any of the tags.
+func (rc *ResponseCache) InvalidateTags(tags ...string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for element := rc.lru.Front(); element != nil; {
+		next := element.Next()
+		entry, _ := element.Value.(*cachedResponse)
+		for _, tag := range tags {
+			if containsString(entry.tags, tag) {
+				rc.remove(element)
+
+				break
+			}
+		}
+		element = next
+	}
+}
+
+// Purge removes every response.
+func (rc *ResponseCache) Purge() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.entries = make(map[string]*list.Element)
+	rc.lru.Init()
+	rc.vary = make(map[string]*varyScope)
+}
+
+// Len returns the number of responses stored.
+func (rc *ResponseCache) Len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.lru.Len()
+}
+
+// AddCacheTags tags the response to the current request so that it can later be invalidated with InvalidateTags.
+func AddCacheTags(c *gin.Context, tags ...string) {
+	existing, _ := c.Get(cacheTagsKey)
+	existingTags, _ := existing.([]string)
+	c.Set(cacheTagsKey, append(existingTags, tags...))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultCacheKey is the request path plus any query.
+func defaultCacheKey(c *gin.Context) string {
+	return c.Request.URL.RequestURI()
+}
+
+// variantKey returns the key a response is stored under from the principal's key and the values of the request
+// headers it varies by.
+func variantKey(scope string, names []string, req *http.Request) string {
+	var key strings.Builder
+	key.WriteString(scope)
+	for _, name := range names {
+		key.WriteString("\x00")
+		key.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+
+	return key.String()
+}
+
+// varyNames returns the canonical names of the request headers listed in the Vary header.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values(headerVary) {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+// cacheDirectives returns the lower cased directive names of the Cache-Control header.
+func cacheDirectives(header http.Header) []string {
+	var directives []string
+	for _, directive := range strings.Split(header.Get(headerCacheControl), ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		directives = append(directives, strings.ToLower(name))
+	}
+
+	return directives
+}
+
+// storable reports whether a response may be stored, given whether stored responses are kept per principal.
+func storable(req *http.Request, header http.Header, perPrincipal bool) bool {
+	directives := cacheDirectives(header)
+	switch {
+	case slices.Contains(directives, "no-store"), slices.Contains(varyNames(header), "*"):
+		return false
+	case perPrincipal:
+		return true
+	case slices.Contains(directives, "private"):
+		return false
+	case req.Header.Get(headerAuthorization) != "":
+		return slices.Contains(directives, "public")
+	}
+
+	return true
+}
+
+// handlerHeader returns the headers which were set or changed after the outer middleware had run, leaving out those
+// which only apply to the one response.
+func handlerHeader(outer, header http.Header) http.Header {
+	set := make(http.Header, len(header))
+	for name, values := range header {
+		if name != headerSetCookie && !slices.Equal(outer[name], values) {
+			set[name] = slices.Clone(values)
+		}
+	}
+
+	return set
+}
+
+// cacheHandler returns the middleware adding validators and caching responses for a handler.
+func cacheHandler(config *CacheConfig) gin.HandlerFunc {
+	keyFn := config.Key
+	if keyFn == nil {
+		keyFn = defaultCacheKey
+	}
+
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Next()
+
+			return
+		}
+
+		base := keyFn(c)
+		scope := base
+		if config.Principal != nil {
+			scope = config.Principal(c) + "\x00" + base
+		}
+
+		if config.Store != nil {
+			key := variantKey(scope, config.Store.varyNames(scope), c.Request)
+			if entry, found := config.Store.get(key); found {
+				cacheRequests.WithLabelValues(config.Store.name, cacheResultHit).Inc()
+				writeCachedResponse(c, entry)
+				c.Abort()
+
+				return
+			}
+			cacheRequests.WithLabelValues(config.Store.name, cacheResultMiss).Inc()
+		}
+
+		outer := c.Writer.Header().Clone()
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.streaming {
+			return
+		}
+
+		if writer.status != http.StatusOK {
+			writer.flushResponse(writer.status, writer.buf.Bytes())
+
+			return
+		}
+
+		header := writer.Header()
+		if header.Get(headerETag) == "" {
+			header.Set(headerETag, generateETag(writer.buf.Bytes(), config.WeakETag))
+		}
+
+		if config.CacheControl != "" && header.Get(headerCacheControl) == "" {
+			header.Set(headerCacheControl, config.CacheControl)
+		}
+
+		if config.Store != nil && storable(c.Request, header, config.Principal != nil) {
+			now := time.Now()
+			if header.Get(headerLastModified) == "" {
+				header.Set(headerLastModified, now.UTC().Format(http.TimeFormat))
+			}
+
+			names := varyNames(header)
+			tags, _ := c.Get(cacheTagsKey)
+			dynamicTags, _ := tags.([]string)
+			config.Store.set(&cachedResponse{
+				key:          variantKey(scope, names, c.Request),
+				base:         base,
+				scope:        scope,
+				status:       writer.status,
+				header:       handlerHeader(outer, header),
+				body:         bytes.Clone(writer.buf.Bytes()),
+				lastModified: now,
+				expires:      now.Add(ttl),
+				tags:         append(append([]string{}, config.Tags...), dynamicTags...),
+			}, names)
+		}
+
+		if notModified(c.Request, header) {
+			writeNotModified(writer.ResponseWriter)
+
+			return
+		}
+
+		writer.flushResponse(writer.status, writer.buf.Bytes())
+	}
+}
+
+// writeCachedResponse writes a stored response, or a 304 if the client already has it.
+func writeCachedResponse(c *gin.Context, entry *cachedResponse) {
+	header := c.Writer.Header()
+	for name, values := range entry.header {
+		header[name] = append([]string(nil), values...)
+	}
+
+	if notModified(c.Request, header) {
+		writeNotModified(c.Writer)
+
+		return
+	}
+
+	c.Writer.WriteHeader(entry.status)
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+
+		return
+	}
+
+	if _, err := c.Writer.Write(entry.body); err != nil {
+		logrus.Debugf("Unable to write cached response: %s", err)
+	}
+}
+
+// generateETag returns an ETag derived from the body.
+func generateETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf("%q", hex.EncodeToString(sum[:])[:etagHashLength])
+	if weak {
+		return "W/" + etag
+	}
+
+	return etag
+}
+
+// notModified evaluates If-None-Match, which takes precedence, and then If-Modified-Since against the response headers.
+func notModified(req *http.Request, header http.Header) bool {
+	if ifNoneMatch := req.Header.Get(headerIfNoneMatch); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(header.Get(headerETag), "W/")
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(req.Header.Get(headerIfModifiedSince))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(header.Get(headerLastModified))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
+}
+
+// writeNotModified writes a 304, removing the headers describing a body.
+func writeNotModified(w gin.ResponseWriter) {
+	header := w.Header()
+	header.Del(headerContentType)
+	header.Del(headerContentLength)
+	header.Del(headerContentEncoding)
+	w.WriteHeader(http.StatusNotModified)
+	w.WriteHeaderNow()
+}
+
+// bufferedWriter holds back the whole response so that validators can be generated from the body. If the handler
+// flushes, the response is treated as a stream and written straight through.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+// WriteHeader records the status until the response is written.
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.streaming {
+		w.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+
+	if code > 0 {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow is deferred until the response is written.
+func (w *bufferedWriter) WriteHeaderNow() {
+	if w.streaming {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Write implements io.Writer.
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		n, err := w.ResponseWriter.Write(data)
+		if err != nil {
+			return n, fmt.Errorf("%w", err)
+		}
+
+		return n, nil
+	}
+
+	n, err := w.buf.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// WriteString implements io.StringWriter.
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Status returns the status the handler set.
+func (w *bufferedWriter) Status() int {
+	if w.streaming {
+		return w.ResponseWriter.Status()
+	}
+
+	return w.status
+}
+
+// Size returns the size of the body written so far.
+func (w *bufferedWriter) Size() int {
+	if w.streaming {
+		return w.ResponseWriter.Size()
+	}
+
+	return w.buf.Len()
+}
+
+// Written reports whether anything has been written.
+func (w *bufferedWriter) Written() bool {
+	if w.streaming {
+		return w.ResponseWriter.Written()
+	}
+
+	return w.buf.Len() > 0
+}
+
+// Flush switches to streaming, writing out anything buffered.
+func (w *bufferedWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.flushResponse(w.status, w.buf.Bytes())
+		w.buf.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// flushResponse writes the status and body to the underlying writer.
+func (w *bufferedWriter) flushResponse(status int, body []byte) {
+	w.ResponseWriter.WriteHeader(status)
+	w.ResponseWriter.WriteHeaderNow()
+	if len(body) == 0 {
+		return
+	}
+
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		logrus.Debugf("Unable to write response: %s", err)
+	}
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countingHandler returns a body containing the number of times it has been called.
+func countingHandler(calls *int32) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		count := atomic.AddInt32(calls, 1)
+		AddCacheTags(c, "item:"+c.Param("id"))
+		c.String(http.StatusOK, fmt.Sprintf("call %d", count))
+	}
+}
+
+func TestCacheETagAndConditionalRequests(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{
+			Method:  http.MethodGet,
+			Handler: helloWorldHandler(),
+			Path:    testEndpoint,
+			Cache:   &CacheConfig{WeakETag: true, CacheControl: "public, max-age=60"},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag := rr.Header().Get(headerETag)
+	if !strings.HasPrefix(etag, "W/\"") {
+		t.Errorf("Expected a weak ETag but got <%s>.", etag)
+	}
+
+	if rr.Header().Get(headerCacheControl) != "public, max-age=60" || rr.Body.String() != "Hello World." {
+		t.Errorf("Unexpected response %s <%s>.", rr.Header().Get(headerCacheControl), rr.Body.String())
+	}
+
+	notModifiedRr, err := sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: headerIfNoneMatch, Value: etag})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if notModifiedRr.Code != http.StatusNotModified || notModifiedRr.Body.Len() != 0 {
+		t.Errorf("Expected status %d with no body but got %d <%s>.", http.StatusNotModified, notModifiedRr.Code,
+			notModifiedRr.Body.String())
+	}
+
+	modifiedRr, err := sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: headerIfNoneMatch, Value: `"other"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if modifiedRr.Code != http.StatusOK {
+		t.Errorf("Expected status %d but got %d.", http.StatusOK, modifiedRr.Code)
+	}
+}
+
+func TestCacheStoreHitsAndInvalidation(t *testing.T) {
+	var calls int32
+	store := NewResponseCache("TestCacheStoreHitsAndInvalidation", 10)
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{
+			Method:  http.MethodGet,
+			Handler: countingHandler(&calls),
+			Path:    "/items/:id",
+			Cache:   &CacheConfig{Store: store, Tags: []string{"items"}},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) string {
+		rr, err := sendRequest(svc, http.MethodGet, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rr.Body.String()
+	}
+
+	if get("/items/1") != "call 1" || get("/items/1") != "call 1" {
+		t.Error("The second request should be served from the cache.")
+	}
+
+	hits := testutil.ToFloat64(cacheRequests.WithLabelValues(store.name, cacheResultHit))
+	misses := testutil.ToFloat64(cacheRequests.WithLabelValues(store.name, cacheResultMiss))
+	if hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss but got %v and %v.", hits, misses)
+	}
+
+	store.Invalidate("/items/1")
+	if get("/items/1") != "call 2" {
+		t.Error("An invalidated key should not be served from the cache.")
+	}
+
+	if get("/items/2") != "call 3" {
+		t.Error("A different key should not be served from the cache.")
+	}
+
+	store.InvalidateTags("item:2")
+	if store.Len() != 1 {
+		t.Errorf("Expected 1 stored response but got %d.", store.Len())
+	}
+
+	store.InvalidateTags("items")
+	if store.Len() != 0 {
+		t.Errorf("Expected no stored responses but got %d.", store.Len())
+	}
+}
+
+func TestCacheStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewResponseCache("TestCacheStoreEvictsLeastRecentlyUsed", 2)
+	vary := []string{headerAcceptEncoding}
+	for _, key := range []string{"a", "b"} {
+		store.set(&cachedResponse{key: key, scope: key, expires: time.Now().Add(time.Hour)}, vary)
+	}
+
+	store.get("a")
+	store.set(&cachedResponse{key: "c", scope: "c", expires: time.Now().Add(time.Hour)}, vary)
+
+	if _, found := store.get("b"); found {
+		t.Error("The least recently used response should be evicted.")
+	}
+
+	if _, found := store.get("a"); !found {
+		t.Error("The most recently used response should be kept.")
+	}
+
+	if store.varyNames("b") != nil || len(store.vary) != 2 {
+		t.Errorf("Expected the vary names to be evicted with their responses but got %v.", store.vary)
+	}
+}
+
+func TestCacheVaryNamesAreBoundedByTheEntries(t *testing.T) {
+	store := NewResponseCache("TestCacheVaryNamesAreBoundedByTheEntries", 2)
+	cfg := Config{
+		ListenAddress: ":8888",
+		Compression:   &CompressionConfig{},
+		Handlers: []Handler{{
+			Method:  http.MethodGet,
+			Handler: largeJSONHandler(),
+			Path:    testEndpoint,
+			Cache:   &CacheConfig{Store: store},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 10 {
+		if _, err := sendRequest(svc, http.MethodGet, fmt.Sprintf("%s?page=%d", testEndpoint, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if store.Len() != 2 || len(store.vary) != 2 {
+		t.Errorf("Expected 2 responses and vary names but got %d and %d.", store.Len(), len(store.vary))
+	}
+}
+
+func TestCacheIgnoresErrorsAndUnsafeMethods(t *testing.T) {
+	var calls int32
+	store := NewResponseCache("TestCacheIgnoresErrorsAndUnsafeMethods", 10)
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: errorHandler(http.StatusNotFound), Path: testEndpoint, Cache: &CacheConfig{Store: store}},
+			{Method: http.MethodPost, Handler: countingHandler(&calls), Path: testEndpoint, Cache: &CacheConfig{Store: store}},
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rr, err := sendRequest(svc, method, testEndpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Header().Get(headerETag) != "" {
+			t.Errorf("No ETag expected for %s.", method)
+		}
+	}
+
+	if store.Len() != 0 {
+		t.Errorf("Expected no stored responses but got %d.", store.Len())
+	}
+}
+
+func TestCacheStoresOnlyHandlerHeaders(t *testing.T) {
+	var calls int32
+	store := NewResponseCache("TestCacheStoresOnlyHandlerHeaders", 10)
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{
+			Method: http.MethodGet,
+			Handler: func(c *gin.Context) {
+				c.Header("X-Handler", "set")
+				c.SetCookie("session", "secret", 60, "/", "", false, true)
+				countingHandler(&calls)(c)
+			},
+			Path:  testEndpoint,
+			Cache: &CacheConfig{Store: store},
+		}},
+		SecurityHeaders: []SecurityHeadersConfig{{Preset: SecurityPresetUI}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.Body.String() != "call 1" || second.Header().Get("X-Handler") != "set" {
+		t.Errorf("Expected the stored response with the handler's headers but got <%s> %v.", second.Body.String(),
+			second.Header())
+	}
+
+	csp := second.Header().Values(headerContentSecurityPolicy)
+	if len(csp) != 1 || csp[0] == first.Header().Get(headerContentSecurityPolicy) {
+		t.Errorf("Expected a CSP with a new nonce but got %v.", csp)
+	}
+
+	if cookie := second.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Expected cookies never to be replayed but got <%s>.", cookie)
+	}
+}
+
+func TestCacheHonoursVaryPrincipalAndCacheControl(t *testing.T) {
+	var calls int32
+	store := NewResponseCache("TestCacheHonoursVaryPrincipalAndCacheControl", 10)
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{
+				Method: http.MethodGet,
+				Handler: func(c *gin.Context) {
+					c.Header(headerVary, "Accept-Language")
+					countingHandler(&calls)(c)
+				},
+				Path:  "/vary",
+				Cache: &CacheConfig{Store: store},
+			},
+			{
+				Method: http.MethodGet,
+				Handler: func(c *gin.Context) {
+					c.Header(headerCacheControl, "private, max-age=60")
+					countingHandler(&calls)(c)
+				},
+				Path: "/private",
+				Cache: &CacheConfig{Store: store, Principal: func(c *gin.Context) string {
+					return c.GetHeader("X-User")
+				}},
+			},
+			{Method: http.MethodGet, Handler: countingHandler(&calls), Path: "/shared",
+				Cache: &CacheConfig{Store: store}},
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string, hdrs ...headers) string {
+		rr, err := sendRequest(svc, http.MethodGet, path, hdrs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rr.Body.String()
+	}
+
+	english, french := headers{Name: "Accept-Language", Value: "en"}, headers{Name: "Accept-Language", Value: "fr"}
+	if get("/vary", english) != "call 1" || get("/vary", french) != "call 2" || get("/vary", english) != "call 1" {
+		t.Error("Expected a stored response per value of the headers in Vary.")
+	}
+
+	alice, bob := headers{Name: "X-User", Value: "alice"}, headers{Name: "X-User", Value: "bob"}
+	if get("/private", alice) != "call 3" || get("/private", bob) != "call 4" || get("/private", alice) != "call 3" {
+		t.Error("Expected private responses to be stored per principal.")
+	}
+
+	authorized := headers{Name: "Authorization", Value: "Bearer token"}
+	if get("/shared", authorized) != "call 5" || get("/shared", authorized) != "call 6" {
+		t.Error("Expected responses to authorized requests not to be stored without a principal.")
+	}
+
+	store.Invalidate("/vary", "/private")
+	if get("/vary", french) != "call 7" || get("/private", bob) != "call 8" {
+		t.Error("Expected every variant and principal of an invalidated key to be removed.")
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// closureSuffix matches the suffix the compiler gives closures and method values e.g. .func1 or -fm.
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$|-fm$`)
+
+// handleRoute registers the route and logs its effective chain, which is the group's handlers followed by the
+// handlers given.
+func handleRoute(group *gin.RouterGroup, method string, path string, handlers ...gin.HandlerFunc) {
+	if method == AnyMethod {
+		group.Any(path, handlers...)
+	} else {
+		group.Handle(method, path, handlers...)
+	}
+
+	chain := make([]string, 0, len(group.Handlers)+len(handlers))
+	for _, handler := range append(append([]gin.HandlerFunc{}, group.Handlers...), handlers...) {
+		chain = append(chain, handlerName(handler))
+	}
+
+	logrus.Infof("Route %s %s: %s", method, joinPaths(group.BasePath(), path), strings.Join(chain, " -> "))
+}
+
+// handlerName returns the short name of a handler function e.g. service.rateLimitHandler.
+func handlerName(handler gin.HandlerFunc) string {
+	function := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if function == nil {
+		return "unknown"
+	}
+
+	name := function.Name()
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		name = name[index+1:]
+	}
+
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+// joinPaths joins a group's base path and a relative path the way gin does.
+func joinPaths(basePath string, path string) string {
+	if path == "" {
+		return basePath
+	}
+
+	return strings.TrimSuffix(basePath, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// preflightRoute is an OPTIONS route answering CORS preflight requests for a handler with its own CORS config.
+type preflightRoute struct {
+	group *gin.RouterGroup
+	path  string
+	chain []gin.HandlerFunc
+}
+
+// setupPreflightRoutes registers the preflight routes, skipping paths which already have an OPTIONS route. The first
+// handler with a CORS config for a path decides its preflight response.
+func setupPreflightRoutes(routes []preflightRoute, engine *gin.Engine) {
+	registered := make(map[string]bool)
+	for _, route := range engine.Routes() {
+		if route.Method == http.MethodOptions {
+			registered[route.Path] = true
+		}
+	}
+
+	for _, route := range routes {
+		fullPath := joinPaths(route.group.BasePath(), route.path)
+		if registered[fullPath] {
+			continue
+		}
+		registered[fullPath] = true
+
+		handleRoute(route.group, http.MethodOptions, route.path, route.chain...)
+	}
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// orderMiddleware appends its name to the X-Order response header.
+func orderMiddleware(name string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("X-Order", name)
+	}
+}
+
+func TestHandlerMiddlewareOrder(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{
+				Method:     http.MethodGet,
+				Handler:    helloWorldHandler(),
+				Path:       testEndpoint,
+				Group:      "api",
+				Middleware: []func(c *gin.Context){orderMiddleware("first"), orderMiddleware("second")},
+			},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/other", Group: "api"},
+		},
+		MiddlewareHandlers: []MiddlewareHandler{{Groups: []string{"api"}, Handler: orderMiddleware("group")}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order := strings.Join(rr.Header().Values("X-Order"), ","); order != "group,first,second" {
+		t.Errorf("Unexpected middleware order %s.", order)
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order := strings.Join(rr.Header().Values("X-Order"), ","); order != "group" {
+		t.Errorf("Handler middleware should not run on other handlers but got %s.", order)
+	}
+}
+
+func TestHandlerCors(t *testing.T) {
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOrigins = []string{"https://app.example.com"}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint, Cors: &corsConfig},
+			{Method: http.MethodPost, Handler: helloWorldHandler(), Path: testEndpoint, Cors: &corsConfig},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/other"},
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := headers{Name: "Origin", Value: "https://app.example.com"}
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected the CORS headers but got %v.", rr.Header())
+	}
+
+	rr, err = sendRequest(svc, http.MethodOptions, testEndpoint, origin,
+		headers{Name: "Access-Control-Request-Method", Value: http.MethodPost})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("Unexpected preflight response %d %v.", rr.Code, rr.Header())
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: "Origin", Value: "https://evil.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a disallowed origin but got %d.", http.StatusForbidden, rr.Code)
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, "/other", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Handlers without a CORS config should not have CORS headers.")
+	}
+}
+
+func TestHandlerCorsOverridesServiceCors(t *testing.T) {
+	handlerCors := cors.DefaultConfig()
+	handlerCors.AllowOrigins = []string{"https://app.example.com"}
+
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				ListenAddress: ":8888",
+				Router:        router,
+				Handlers: []Handler{
+					{Method: http.MethodGet, HTTPHandler: userHandler(), Path: testEndpoint, Cors: &handlerCors},
+					{Method: http.MethodGet, HTTPHandler: userHandler(), Path: "/other"},
+				},
+				Cors: &CorsConfig{
+					Enabled:     true,
+					OverrideCfg: &cors.Config{AllowOrigins: []string{"https://service.example.com"}},
+				},
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			origin := headers{Name: "Origin", Value: "https://app.example.com"}
+			rr, err := sendRequest(svc, http.MethodGet, testEndpoint, origin)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+				t.Errorf("Expected the handler's CORS config to allow the origin but got %d %v.", rr.Code, rr.Header())
+			}
+
+			rr, err = sendRequest(svc, http.MethodOptions, testEndpoint, origin,
+				headers{Name: "Access-Control-Request-Method", Value: http.MethodGet})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			allowed := rr.Header().Get("Access-Control-Allow-Origin")
+			if rr.Code != http.StatusNoContent || allowed != "https://app.example.com" {
+				t.Errorf("Expected the handler's preflight response but got %d %v.", rr.Code, rr.Header())
+			}
+
+			rr, err = sendRequest(svc, http.MethodGet, "/other", origin)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("Expected the service's CORS config on other handlers but got %d.", rr.Code)
+			}
+		})
+	}
+}
+
+func TestRouteChainsLogged(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(hook.Reset)
+
+	cfg := Config{
+		ListenAddress:  ":8888",
+		ReadinessCheck: true,
+		Handlers: []Handler{{
+			Method:          http.MethodGet,
+			Handler:         helloWorldHandler(),
+			Path:            testEndpoint,
+			RateLimitConfig: &HandlerRateLimitConfig{Limit: 10, Within: 1},
+			Middleware:      []func(c *gin.Context){orderMiddleware("first")},
+		}},
+	}
+
+	if _, err := setupService(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var routes []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.InfoLevel && strings.HasPrefix(entry.Message, "Route ") {
+			routes = append(routes, entry.Message)
+		}
+	}
+
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes to be logged but got %v.", routes)
+	}
+
+	expected := "Route GET /helloworld: throttle.Policy -> gin-logrus.Logger -> service.orderMiddleware -> " +
+		"service.helloWorldHandler"
+	if !strings.Contains(strings.Join(routes, "\n"), expected) {
+		t.Errorf("Expected <%s> in %v.", expected, routes)
+	}
+}
@@ -0,0 +1,464 @@
+package service
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EncodingGzip is the gzip content encoding.
+	EncodingGzip = "gzip"
+	// EncodingZstd is the zstd content encoding.
+	EncodingZstd = "zstd"
+	// EncodingBrotli is the brotli content encoding.
+	EncodingBrotli = "br"
+
+	defaultCompressionMinSize  = 1024
+	defaultDecompressedMaxSize = 10 << 20
+	headerAcceptEncoding       = "Accept-Encoding"
+	headerContentEncoding      = "Content-Encoding"
+	headerContentLength        = "Content-Length"
+	headerContentRange         = "Content-Range"
+	headerContentType          = "Content-Type"
+	headerVary                 = "Vary"
+)
+
+// defaultCompressionEncodings is the server preference order used when none is configured.
+var defaultCompressionEncodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+
+// defaultCompressionContentTypes are the content types compressed when none are configured. Event streams are never
+// compressed, even when configured, as they cannot be buffered.
+var defaultCompressionContentTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/csv",
+	"text/xml",
+	"text/javascript",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressionConfig specifies the response compression config.
+type CompressionConfig struct {
+	Groups              []string // Optional - which group(s) compression should run on. Empty means the default route.
+	Encodings           []string // Optional - encodings in order of server preference. Default zstd, br, gzip.
+	GzipLevel           int      // Optional - gzip compression level. Default is gzip.DefaultCompression.
+	MinSize             int      // Optional - responses smaller than this (bytes) are not compressed. Default 1024.
+	ContentTypes        []string // Optional - content types to compress. A trailing "/*" matches a whole type.
+	ExcludePaths        []string // Optional - request paths never compressed. A trailing "*" matches a prefix.
+	DecompressRequests  bool     // If true, gzip encoded request bodies are transparently decompressed.
+	MaxDecompressedSize int64    // Optional - the largest decompressed request body, reading more fails. Default 10MB.
+}
+
+// compressor is implemented by all the supported encoders.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compression holds the state for a configured compression middleware.
+type compression struct {
+	encodings    []string
+	minSize      int
+	contentTypes []string
+	excludePaths []string
+	decompress   bool
+	maxBodySize  int64
+	pools        map[string]*sync.Pool
+}
+
+func newCompression(config *CompressionConfig) *compression {
+	comp := &compression{
+		encodings:    config.Encodings,
+		minSize:      config.MinSize,
+		contentTypes: config.ContentTypes,
+		excludePaths: config.ExcludePaths,
+		decompress:   config.DecompressRequests,
+		maxBodySize:  config.MaxDecompressedSize,
+		pools:        make(map[string]*sync.Pool),
+	}
+
+	if comp.maxBodySize <= 0 {
+		comp.maxBodySize = defaultDecompressedMaxSize
+	}
+
+	if len(comp.encodings) == 0 {
+		comp.encodings = defaultCompressionEncodings
+	}
+
+	if comp.minSize <= 0 {
+		comp.minSize = defaultCompressionMinSize
+	}
+
+	if len(comp.contentTypes) == 0 {
+		comp.contentTypes = defaultCompressionContentTypes
+	}
+
+	gzipLevel := config.GzipLevel
+	if gzipLevel == 0 {
+		gzipLevel = gzip.DefaultCompression
+	}
+
+	supported := make([]string, 0, len(comp.encodings))
+	for _, encoding := range comp.encodings {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		var newFn func() any
+		switch encoding {
+		case EncodingGzip:
+			newFn = func() any {
+				w, err := gzip.NewWriterLevel(io.Discard, gzipLevel)
+				if err != nil {
+					w = gzip.NewWriter(io.Discard)
+				}
+
+				return w
+			}
+		case EncodingZstd:
+			newFn = func() any {
+				// An error is only possible with invalid options. A single goroutine per encoder suits short responses
+				// and keeps pooled encoders from each holding one per CPU.
+				w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+
+				return w
+			}
+		case EncodingBrotli:
+			newFn = func() any {
+				return brotli.NewWriter(io.Discard)
+			}
+		default:
+			logrus.Warnf("Compression encoding %s unsupported.", encoding)
+
+			continue
+		}
+		comp.pools[encoding] = &sync.Pool{New: newFn}
+		supported = append(supported, encoding)
+	}
+	comp.encodings = supported
+
+	return comp
+}
+
+// compressionHandler returns the middleware which negotiates and applies compression.
+func compressionHandler(config *CompressionConfig) gin.HandlerFunc {
+	comp := newCompression(config)
+
+	return func(c *gin.Context) {
+		if comp.isExcluded(c.Request.URL.Path) {
+			c.Next()
+
+			return
+		}
+
+		if comp.decompress && !comp.decompressRequest(c) {
+			return
+		}
+
+		c.Writer.Header().Add(headerVary, headerAcceptEncoding)
+
+		encoding := negotiateEncoding(c.GetHeader(headerAcceptEncoding), comp.encodings)
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: c.Writer, comp: comp, encoding: encoding}
+		c.Writer = writer
+		defer func() {
+			writer.close()
+			c.Writer = writer.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// decompressRequest replaces a gzip encoded request body with a decompressing reader, limited to the max size so that
+// a small compressed body cannot expand without bound. It returns false if the request has been aborted.
+func (comp *compression) decompressRequest(c *gin.Context) bool {
+	if !strings.EqualFold(c.GetHeader(headerContentEncoding), EncodingGzip) || c.Request.Body == nil {
+		return true
+	}
+
+	reader, err := gzip.NewReader(c.Request.Body)
+	if err != nil {
+		logrus.Debugf("Unable to decompress request body: %s", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+
+		return false
+	}
+
+	body := &gzipRequestBody{Reader: reader, body: c.Request.Body}
+	c.Request.Body = http.MaxBytesReader(c.Writer, body, comp.maxBodySize)
+	c.Request.Header.Del(headerContentEncoding)
+	c.Request.Header.Del(headerContentLength)
+	c.Request.ContentLength = -1
+
+	return true
+}
+
+func (comp *compression) isExcluded(path string) bool {
+	return matchPaths(comp.excludePaths, path)
+}
+
+func (comp *compression) isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == contentTypeEventStream {
+		return false
+	}
+
+	for _, allowed := range comp.contentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gzipRequestBody closes both the gzip reader and the original body.
+type gzipRequestBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+// Close implements io.Closer.
+func (b *gzipRequestBody) Close() error {
+	readerErr := b.Reader.Close()
+	if err := b.body.Close(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if readerErr != nil {
+		return fmt.Errorf("%w", readerErr)
+	}
+
+	return nil
+}
+
+// negotiateEncoding picks the encoding with the highest quality value from the Accept-Encoding header. Ties are
+// broken by the order of the supported encodings. An empty string means no compression should be used.
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if name == "*" {
+			wildcard = quality
+		} else if name != "" {
+			qualities[name] = quality
+		}
+	}
+
+	type candidate struct {
+		encoding string
+		quality  float64
+		order    int
+	}
+
+	candidates := make([]candidate, 0, len(supported))
+	for i, encoding := range supported {
+		quality, found := qualities[encoding]
+		if !found {
+			quality = wildcard
+		}
+
+		if quality > 0 {
+			candidates = append(candidates, candidate{encoding: encoding, quality: quality, order: i})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	return candidates[0].encoding
+}
+
+// compressWriter buffers the start of a response until it can decide whether the response should be compressed.
+type compressWriter struct {
+	gin.ResponseWriter
+	comp       *compression
+	encoding   string
+	buf        []byte
+	decided    bool
+	compressor compressor
+}
+
+// Write implements io.Writer.
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.comp.minSize {
+			return len(data), nil
+		}
+
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	if w.compressor != nil {
+		n, err := w.compressor.Write(data)
+		if err != nil {
+			return n, fmt.Errorf("%w", err)
+		}
+
+		return n, nil
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// WriteString implements io.StringWriter.
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow forces a decision on the buffered data before the headers are sent.
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			logrus.Warnf("Unable to write compressed response: %s", err)
+		}
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Flush implements http.Flusher. A flush before the minimum size is reached sends the response uncompressed.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			logrus.Warnf("Unable to write compressed response: %s", err)
+		}
+	}
+
+	if w.compressor != nil {
+		if err := w.compressor.Flush(); err != nil {
+			logrus.Warnf("Unable to flush compressed response: %s", err)
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide determines whether the response is compressed and writes out any buffered data.
+func (w *compressWriter) decide() error {
+	w.decided = true
+
+	header := w.ResponseWriter.Header()
+	if header.Get(headerContentType) == "" && len(w.buf) > 0 {
+		header.Set(headerContentType, http.DetectContentType(w.buf))
+	}
+
+	status := w.ResponseWriter.Status()
+	// Partial content is left alone as the Content-Range refers to the bytes of the uncompressed body.
+	if len(w.buf) >= w.comp.minSize &&
+		status != http.StatusNoContent && status != http.StatusNotModified && status >= http.StatusOK &&
+		status != http.StatusPartialContent && header.Get(headerContentRange) == "" &&
+		header.Get(headerContentEncoding) == "" && w.comp.isCompressible(header.Get(headerContentType)) {
+		header.Set(headerContentEncoding, w.encoding)
+		header.Del(headerContentLength)
+
+		pool := w.comp.pools[w.encoding]
+		cmp, _ := pool.Get().(compressor)
+		cmp.Reset(w.ResponseWriter)
+		w.compressor = cmp
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if w.compressor != nil {
+		if _, err := w.compressor.Write(buf); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		return nil
+	}
+
+	if _, err := w.ResponseWriter.Write(buf); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// close writes out anything still buffered and returns the compressor to its pool.
+func (w *compressWriter) close() {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			logrus.Warnf("Unable to write compressed response: %s", err)
+		}
+	}
+
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			logrus.Warnf("Unable to complete compressed response: %s", err)
+		}
+		w.compressor.Reset(io.Discard)
+		w.comp.pools[w.encoding].Put(w.compressor)
+		w.compressor = nil
+	}
+}
+
+func setupCompression(config *CompressionConfig, engine *gin.Engine) {
+	if config != nil {
+		if len(config.Groups) == 0 {
+			useOnDefaultRoute(engine, compressionHandler(config))
+		} else {
+			for _, groupLabel := range config.Groups {
+				group := getRouterGroup(engine, groupLabel)
+				group.Use(compressionHandler(config))
+			}
+		}
+	}
+}
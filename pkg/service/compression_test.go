@@ -0,0 +1,338 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+var largeBody = strings.Repeat("compress me please ", 200)
+
+// largeJSONHandler returns a body large enough to be compressed.
+func largeJSONHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(largeBody))
+	}
+}
+
+// echoHandler returns the request body.
+func echoHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	}
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var reader io.Reader
+	switch encoding {
+	case EncodingGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gzipReader
+	case EncodingZstd:
+		zstdReader, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	case EncodingBrotli:
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		reader = bytes.NewReader(body)
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(decoded)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{header: "", expected: ""},
+		{header: "gzip", expected: EncodingGzip},
+		{header: "gzip, br", expected: EncodingBrotli},
+		{header: "gzip;q=1.0, br;q=0.5", expected: EncodingGzip},
+		{header: "zstd, br, gzip", expected: EncodingZstd},
+		{header: "*", expected: EncodingZstd},
+		{header: "*, zstd;q=0", expected: EncodingBrotli},
+		{header: "identity", expected: ""},
+		{header: "deflate", expected: ""},
+	}
+
+	for _, test := range tests {
+		actual := negotiateEncoding(test.header, defaultCompressionEncodings)
+		if actual != test.expected {
+			t.Errorf("Accept-Encoding %q: got <%s> want <%s>", test.header, actual, test.expected)
+		}
+	}
+}
+
+func TestCompressionEncodings(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: largeJSONHandler(), Path: testEndpoint}},
+		Compression:   &CompressionConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []string{EncodingGzip, EncodingZstd, EncodingBrotli} {
+		rr, err := sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: headerAcceptEncoding, Value: encoding})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Header().Get(headerContentEncoding) != encoding {
+			t.Errorf("Expected content encoding %s but got %s.", encoding, rr.Header().Get(headerContentEncoding))
+		}
+
+		if decodeBody(t, encoding, rr.Body.Bytes()) != largeBody {
+			t.Errorf("Body not as expected for encoding %s.", encoding)
+		}
+	}
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		Compression:   &CompressionConfig{},
+	}
+
+	rr, err := checkResponseCode(http.MethodGet, testEndpoint, cfg, http.StatusOK,
+		headers{Name: headerAcceptEncoding, Value: EncodingGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerContentEncoding) != "" {
+		t.Error("Small responses should not be compressed.")
+	}
+
+	if rr.Body.String() != "Hello World." {
+		t.Errorf("handler returned unexpected body: got <%v>", rr.Body.String())
+	}
+}
+
+func TestCompressionContentTypeAndExclusions(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: largeJSONHandler(), Path: testEndpoint},
+			{Method: http.MethodGet, Handler: largeJSONHandler(), Path: "/excluded/stream"},
+			{Method: http.MethodGet, Handler: func(c *gin.Context) {
+				c.Data(http.StatusOK, contentTypeEventStream, []byte(largeBody))
+			}, Path: "/events"},
+		},
+		Compression: &CompressionConfig{ContentTypes: []string{"text/*"}, ExcludePaths: []string{"/excluded/*"}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{testEndpoint, "/excluded/stream", "/events"} {
+		rr, err := sendRequest(svc, http.MethodGet, path, headers{Name: headerAcceptEncoding, Value: EncodingGzip})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Header().Get(headerContentEncoding) != "" {
+			t.Errorf("Response for %s should not be compressed.", path)
+		}
+	}
+}
+
+func TestCompressionOnHandler(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: largeJSONHandler(), Path: testEndpoint, Compression: &CompressionConfig{}},
+			{Method: http.MethodGet, Handler: largeJSONHandler(), Path: "/uncompressed"},
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: headerAcceptEncoding, Value: EncodingGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerContentEncoding) != EncodingGzip {
+		t.Error("Handler with compression config should be compressed.")
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, "/uncompressed", headers{Name: headerAcceptEncoding, Value: EncodingGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerContentEncoding) != "" {
+		t.Error("Handler without compression config should not be compressed.")
+	}
+}
+
+func TestCompressionOnDefaultRouteReachesGroups(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: largeJSONHandler(), Path: testEndpoint, Group: "api"},
+		},
+		// The concurrency limit creates the group before compression is set up.
+		ConcurrencyLimits: []ConcurrencyLimitConfig{{Groups: []string{"api"}, MaxInFlight: 10}},
+		Compression:       &CompressionConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: headerAcceptEncoding, Value: EncodingGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerContentEncoding) != EncodingGzip {
+		t.Error("A grouped handler should be compressed by the default route's compression.")
+	}
+}
+
+func TestCompressionDecompressesRequests(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: echoHandler(), Path: testEndpoint}},
+		Compression:   &CompressionConfig{DecompressRequests: true},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write([]byte("Hello World.")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, testEndpoint, &compressed)
+	req.Header.Set(headerContentEncoding, EncodingGzip)
+	rr := httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	if rr.Body.String() != "Hello World." {
+		t.Errorf("handler returned unexpected body: got <%v> want <Hello World.>", rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, testEndpoint, strings.NewReader("not gzip"))
+	req.Header.Set(headerContentEncoding, EncodingGzip)
+	rr = httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d but got %d.", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCompressionLimitsDecompressedRequests(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{Method: http.MethodPost, Path: testEndpoint, Handler: func(c *gin.Context) {
+			var tooLarge *http.MaxBytesError
+			if _, err := io.ReadAll(c.Request.Body); errors.As(err, &tooLarge) {
+				c.Status(http.StatusRequestEntityTooLarge)
+			}
+		}}},
+		Compression: &CompressionConfig{DecompressRequests: true, MaxDecompressedSize: 1024},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A megabyte of zeros compresses to around a kilobyte.
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, testEndpoint, &compressed)
+	req.Header.Set(headerContentEncoding, EncodingGzip)
+	rr := httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the decompressed body to be limited but got %d.", rr.Code)
+	}
+}
+
+func TestCompressionLeavesPartialContent(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{Method: http.MethodGet, Path: testEndpoint, Handler: func(c *gin.Context) {
+			http.ServeContent(c.Writer, c.Request, "body.json", time.Time{}, strings.NewReader(largeBody))
+		}}},
+		Compression: &CompressionConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint, headers{Name: headerAcceptEncoding, Value: EncodingGzip},
+		headers{Name: "Range", Value: "bytes=0-1999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusPartialContent || rr.Header().Get(headerContentEncoding) != "" ||
+		rr.Body.String() != largeBody[:2000] {
+		t.Errorf("Expected the range to be served uncompressed but got %d %q.", rr.Code,
+			rr.Header().Get(headerContentEncoding))
+	}
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AdaptiveAIMD increases the limit by one while latency is under the target and cuts it by the backoff when over.
+	AdaptiveAIMD = "aimd"
+	// AdaptiveGradient scales the limit by the ratio of the lowest latency seen to the latency observed.
+	AdaptiveGradient = "gradient"
+
+	defaultQueueTimeout       = time.Second
+	defaultShedRetryAfter     = time.Second
+	defaultAIMDBackoff        = 0.9
+	gradientSmoothing         = 0.2
+	gradientMinRatio          = 0.5
+	gradientResetSamples      = 1000
+	serviceWideLimiterName    = "service"
+	shedReasonQueueFull       = "queue_full"
+	shedReasonQueueTimeout    = "queue_timeout"
+	shedReasonRequestCanceled = "canceled"
+)
+
+var (
+	errInvalidConcurrencyLimit = errors.New("concurrency limit must be greater than zero")
+	errInvalidAdaptiveMode     = errors.New("invalid adaptive concurrency mode")
+	errAdaptiveLatencyTarget   = errors.New("adaptive AIMD concurrency limit requires a latency target")
+)
+
+// Priority decides how a handler's requests are treated when a concurrency limit is reached.
+type Priority int
+
+const (
+	// PriorityNormal requests wait in the queue for a free slot.
+	PriorityNormal Priority = iota
+	// PriorityLow requests never wait, they are shed as soon as the limit is reached.
+	PriorityLow
+	// PriorityCritical requests are never limited or shed. The readiness, metrics, log level, version, maintenance,
+	// fault injection and profiler endpoints are always critical.
+	PriorityCritical
+)
+
+// ConcurrencyLimitConfig caps the number of requests being handled at once. Requests over the limit wait in a bounded
+// queue and are shed with a 503 and a Retry-After header when the queue is full or they time out waiting.
+type ConcurrencyLimitConfig struct {
+	Name         string                     // Optional - the metrics label. Default is the groups or service.
+	Groups       []string                   // Optional - the groups sharing the limit. Empty means the whole service.
+	MaxInFlight  int                        // The number of requests handled at once, the initial limit if adaptive.
+	QueueSize    int                        // Optional - the number of requests which can wait for a slot. Default 0.
+	QueueTimeout time.Duration              // Optional - how long a request waits in the queue. Default 1s.
+	RetryAfter   time.Duration              // Optional - the Retry-After sent with shed requests. Default 1s.
+	Adaptive     *AdaptiveConcurrencyConfig // Optional - adjust the limit from the observed latency.
+}
+
+// AdaptiveConcurrencyConfig adjusts a concurrency limit from the latency of the requests handled.
+type AdaptiveConcurrencyConfig struct {
+	Mode          string        // AdaptiveAIMD or AdaptiveGradient.
+	MinLimit      int           // Optional - the lowest the limit can go. Default 1.
+	MaxLimit      int           // Optional - the highest the limit can go. Default 10 times MaxInFlight.
+	LatencyTarget time.Duration // AIMD only - the latency above which the limit is cut.
+	Backoff       float64       // AIMD only - optional, the factor the limit is cut by. Default 0.9.
+}
+
+// concurrencyLimiter admits requests up to its limit, queueing the rest in arrival order.
+type concurrencyLimiter struct {
+	mu         sync.Mutex
+	name       string
+	config     *ConcurrencyLimitConfig
+	limit      float64
+	inFlight   int
+	waiters    *list.List // Of chan struct{}, closed when the waiter is admitted.
+	minLatency time.Duration
+	samples    int
+}
+
+func newConcurrencyLimiter(config *ConcurrencyLimitConfig) (*concurrencyLimiter, error) {
+	if config.MaxInFlight <= 0 {
+		return nil, fmt.Errorf("%w: %d", errInvalidConcurrencyLimit, config.MaxInFlight)
+	}
+
+	if adaptive := config.Adaptive; adaptive != nil {
+		switch adaptive.Mode {
+		case AdaptiveGradient:
+		case AdaptiveAIMD:
+			if adaptive.LatencyTarget <= 0 {
+				return nil, errAdaptiveLatencyTarget
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s", errInvalidAdaptiveMode, adaptive.Mode)
+		}
+	}
+
+	name := config.Name
+	if name == "" {
+		name = serviceWideLimiterName
+		if len(config.Groups) > 0 {
+			name = strings.Join(config.Groups, ",")
+		}
+	}
+
+	limiter := &concurrencyLimiter{name: name, config: config, limit: float64(config.MaxInFlight), waiters: list.New()}
+	concurrencyLimit.WithLabelValues(name).Set(limiter.limit)
+
+	return limiter, nil
+}
+
+// acquire returns true once the request may be handled or false, with the reason, if it was shed.
+func (l *concurrencyLimiter) acquire(c *gin.Context, priority Priority) (bool, string) {
+	l.mu.Lock()
+	if l.inFlight < l.currentLimit() && l.waiters.Len() == 0 {
+		l.admit()
+		l.mu.Unlock()
+
+		return true, ""
+	}
+
+	if priority == PriorityLow || l.waiters.Len() >= l.config.QueueSize {
+		l.mu.Unlock()
+
+		return false, shedReasonQueueFull
+	}
+
+	admitted := make(chan struct{})
+	waiter := l.waiters.PushBack(admitted)
+	concurrencyQueued.WithLabelValues(l.name).Set(float64(l.waiters.Len()))
+	l.mu.Unlock()
+
+	timeout := l.config.QueueTimeout
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	reason := shedReasonQueueTimeout
+	select {
+	case <-admitted:
+		return true, ""
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+		reason = shedReasonRequestCanceled
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-admitted:
+		// Admitted while giving up so the slot is used rather than handed on.
+		return true, ""
+	default:
+	}
+
+	l.waiters.Remove(waiter)
+	concurrencyQueued.WithLabelValues(l.name).Set(float64(l.waiters.Len()))
+
+	return false, reason
+}
+
+// release frees the request's slot, adjusts an adaptive limit from its latency and admits waiters into free slots.
+func (l *concurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.adapt(latency)
+
+	for l.waiters.Len() > 0 && l.inFlight < l.currentLimit() {
+		admitted, _ := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.admit()
+		close(admitted)
+	}
+
+	concurrencyInFlight.WithLabelValues(l.name).Set(float64(l.inFlight))
+	concurrencyQueued.WithLabelValues(l.name).Set(float64(l.waiters.Len()))
+}
+
+// admit takes a slot. It must be called with the lock held.
+func (l *concurrencyLimiter) admit() {
+	l.inFlight++
+	concurrencyInFlight.WithLabelValues(l.name).Set(float64(l.inFlight))
+}
+
+// currentLimit returns the whole number of requests which can be handled at once. It must be called with the lock held.
+func (l *concurrencyLimiter) currentLimit() int {
+	return int(l.limit)
+}
+
+// adapt adjusts the limit from a latency sample. It must be called with the lock held.
+func (l *concurrencyLimiter) adapt(latency time.Duration) {
+	adaptive := l.config.Adaptive
+	if adaptive == nil {
+		return
+	}
+
+	limit := l.limit
+	switch adaptive.Mode {
+	case AdaptiveAIMD:
+		backoff := adaptive.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = defaultAIMDBackoff
+		}
+
+		if latency > adaptive.LatencyTarget {
+			limit *= backoff
+		} else if l.inFlight+1 >= l.currentLimit() {
+			// Only grow while the limit is being used, otherwise it climbs without evidence it can be sustained.
+			limit++
+		}
+	case AdaptiveGradient:
+		l.samples++
+		if l.minLatency == 0 || latency < l.minLatency || l.samples >= gradientResetSamples {
+			l.minLatency = latency
+			l.samples = 0
+		}
+
+		ratio := 1.0
+		if latency > 0 {
+			ratio = math.Max(gradientMinRatio, math.Min(1, float64(l.minLatency)/float64(latency)))
+		}
+
+		// The square root allows for some queueing so the limit can grow when latency is stable.
+		target := limit*ratio + math.Sqrt(limit)
+		limit = limit*(1-gradientSmoothing) + target*gradientSmoothing
+	}
+
+	minLimit, maxLimit := adaptive.MinLimit, adaptive.MaxLimit
+	if minLimit <= 0 {
+		minLimit = 1
+	}
+
+	if maxLimit <= 0 {
+		maxLimit = 10 * l.config.MaxInFlight //nolint:mnd // an order of magnitude of headroom
+	}
+
+	l.limit = math.Max(float64(minLimit), math.Min(float64(maxLimit), limit))
+	concurrencyLimit.WithLabelValues(l.name).Set(float64(l.currentLimit()))
+}
+
+// handler returns the middleware limiting the requests. Routes are looked up in the priorities by their method and full
+// path, then by AnyMethod and their full path.
+func (l *concurrencyLimiter) handler(priorities map[string]Priority) gin.HandlerFunc {
+	retryAfter := l.config.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultShedRetryAfter
+	}
+	retryAfterSeconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+
+	return func(c *gin.Context) {
+		priority, found := priorities[routeKey(c.Request.Method, c.FullPath())]
+		if !found {
+			priority = priorities[routeKey(AnyMethod, c.FullPath())]
+		}
+
+		if priority == PriorityCritical {
+			return
+		}
+
+		admitted, reason := l.acquire(c, priority)
+		if !admitted {
+			concurrencyShed.WithLabelValues(l.name, reason).Inc()
+			logrus.Debugf("Shedding request to %s: %s.", c.Request.URL.Path, reason)
+			c.Header("Retry-After", retryAfterSeconds)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service overloaded"})
+
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start))
+		}()
+
+		c.Next()
+	}
+}
+
+// routeKey returns the key of a route in the priorities.
+func routeKey(method string, fullPath string) string {
+	return method + " " + fullPath
+}
+
+// routePriorities returns the priority of the routes which are not PriorityNormal by their method and full path. The
+// service's own endpoints are critical whatever the method.
+func routePriorities(cfg *Config) map[string]Priority {
+	critical := []string{ReadinessEndpoint, "/metrics", DefaultLogLevelPath, DefaultVersionPath}
+	for _, path := range []string{"", "cmdline", "profile", "symbol", "trace", "allocs", "block", "goroutine", "heap",
+		"mutex", "threadcreate"} {
+		critical = append(critical, "/debug/pprof/"+path)
+	}
+
+	if cfg.LogLevelControl != nil && cfg.LogLevelControl.Path != "" {
+		critical = append(critical, cfg.LogLevelControl.Path)
+	}
+
+	if cfg.Version != nil && cfg.Version.Path != "" {
+		critical = append(critical, cfg.Version.Path)
+	}
+
+	if cfg.Maintenance != nil {
+		path := cfg.Maintenance.Path
+		if path == "" {
+			path = DefaultMaintenancePath
+		}
+		critical = append(critical, path)
+	}
+
+	if cfg.FaultInjection != nil {
+		critical = append(critical, faultInjectionPath(cfg.FaultInjection))
+	}
+
+	priorities := make(map[string]Priority)
+	for _, path := range critical {
+		priorities[routeKey(AnyMethod, path)] = PriorityCritical
+	}
+
+	for _, handler := range cfg.Handlers {
+		if handler.Priority != PriorityNormal {
+			priorities[routeKey(handler.Method, joinPaths("/", handler.Path))] = handler.Priority
+		}
+	}
+
+	return priorities
+}
+
+// setupConcurrencyLimits adds the limiters. It is called before any other middleware is added so that a service wide
+// limit applies to every group.
+func setupConcurrencyLimits(cfg *Config, engine *gin.Engine) error {
+	if len(cfg.ConcurrencyLimits) == 0 {
+		return nil
+	}
+
+	priorities := routePriorities(cfg)
+	for i := range cfg.ConcurrencyLimits {
+		config := &cfg.ConcurrencyLimits[i]
+		limiter, err := newConcurrencyLimiter(config)
+		if err != nil {
+			return err
+		}
+
+		if len(config.Groups) == 0 {
+			useOnDefaultRoute(engine, limiter.handler(priorities))
+
+			continue
+		}
+
+		for _, group := range config.Groups {
+			getRouterGroup(engine, group).Use(limiter.handler(priorities))
+		}
+	}
+
+	return nil
+}
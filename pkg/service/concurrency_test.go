@@ -0,0 +1,271 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// blockingHandler signals when a request starts and waits for release before responding.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.String(http.StatusOK, "done")
+	}
+}
+
+// serveAsync sends a request in the background, returning a channel which receives the response.
+func serveAsync(svc *Service, method string, path string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		svc.Handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		done <- rr
+	}()
+
+	return done
+}
+
+func limitedService(t *testing.T, limit ConcurrencyLimitConfig, started chan struct{},
+	release chan struct{},
+) *Service {
+	t.Helper()
+
+	cfg := Config{
+		ListenAddress:  ":8888",
+		ReadinessCheck: true,
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: blockingHandler(started, release), Path: "/slow"},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/low", Priority: PriorityLow},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint},
+		},
+		ConcurrencyLimits: []ConcurrencyLimitConfig{limit},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc
+}
+
+func TestConcurrencyLimitSheds(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	svc := limitedService(t, ConcurrencyLimitConfig{Name: "sheds", MaxInFlight: 1, RetryAfter: 2 * time.Second},
+		started, release)
+
+	shed := concurrencyShed.WithLabelValues("sheds", shedReasonQueueFull)
+	before := testutil.ToFloat64(shed)
+
+	slow := serveAsync(svc, http.MethodGet, "/slow")
+	<-started
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected a 503 with Retry-After 2 but got %d %s.", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	if delta := testutil.ToFloat64(shed) - before; delta != 1 {
+		t.Errorf("Expected 1 shed request but got %f.", delta)
+	}
+
+	if inFlight := testutil.ToFloat64(concurrencyInFlight.WithLabelValues("sheds")); inFlight != 1 {
+		t.Errorf("Expected 1 request in flight but got %f.", inFlight)
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, ReadinessEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("The readiness endpoint should never be shed but got %d.", rr.Code)
+	}
+
+	close(release)
+	if rr := <-slow; rr.Code != http.StatusOK {
+		t.Errorf("Expected the slow request to succeed but got %d.", rr.Code)
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d once the slot is free but got %d.", http.StatusOK, rr.Code)
+	}
+}
+
+func TestConcurrencyLimitPrioritiesByMethodAndAdminEndpoints(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	cfg := Config{
+		ListenAddress: ":8888",
+		Environment:   EnvironmentTest,
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: blockingHandler(started, release), Path: "/slow"},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/items", Priority: PriorityCritical},
+			{Method: http.MethodPost, Handler: helloWorldHandler(), Path: "/items"},
+		},
+		ConcurrencyLimits: []ConcurrencyLimitConfig{{Name: "priorities", MaxInFlight: 1}},
+		Maintenance:       &MaintenanceConfig{Endpoint: true, Group: "admin"},
+		FaultInjection:    &FaultInjectionConfig{Endpoint: true, Group: "admin"},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := serveAsync(svc, http.MethodGet, "/slow")
+	<-started
+
+	for _, request := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{method: http.MethodGet, path: "/items", code: http.StatusOK},
+		{method: http.MethodPost, path: "/items", code: http.StatusServiceUnavailable},
+		{method: http.MethodGet, path: DefaultMaintenancePath, code: http.StatusOK},
+		{method: http.MethodGet, path: DefaultFaultInjectionPath, code: http.StatusOK},
+	} {
+		rr, err := sendRequest(svc, request.method, request.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != request.code {
+			t.Errorf("Expected %s %s to get %d but got %d.", request.method, request.path, request.code, rr.Code)
+		}
+	}
+
+	close(release)
+	<-slow
+}
+
+func TestConcurrencyLimitQueues(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	svc := limitedService(t, ConcurrencyLimitConfig{Name: "queues", MaxInFlight: 1, QueueSize: 1,
+		QueueTimeout: 5 * time.Second}, started, release)
+
+	first := serveAsync(svc, http.MethodGet, "/slow")
+	<-started
+
+	second := serveAsync(svc, http.MethodGet, testEndpoint)
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(concurrencyQueued.WithLabelValues("queues")) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, "/low")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Low priority requests should not queue but got %d.", rr.Code)
+	}
+
+	close(release)
+	for _, response := range []<-chan *httptest.ResponseRecorder{first, second} {
+		if rr := <-response; rr.Code != http.StatusOK {
+			t.Errorf("Expected queued requests to succeed but got %d.", rr.Code)
+		}
+	}
+}
+
+func TestConcurrencyLimitQueueTimeout(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	svc := limitedService(t, ConcurrencyLimitConfig{Name: "timeout", MaxInFlight: 1, QueueSize: 1,
+		QueueTimeout: 10 * time.Millisecond}, started, release)
+
+	slow := serveAsync(svc, http.MethodGet, "/slow")
+	<-started
+
+	rr, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the queued request to time out but got %d.", rr.Code)
+	}
+
+	if shed := testutil.ToFloat64(concurrencyShed.WithLabelValues("timeout", shedReasonQueueTimeout)); shed != 1 {
+		t.Errorf("Expected 1 timed out request but got %f.", shed)
+	}
+
+	close(release)
+	<-slow
+}
+
+func TestAdaptiveConcurrencyLimit(t *testing.T) {
+	aimd, err := newConcurrencyLimiter(&ConcurrencyLimitConfig{Name: "aimd", MaxInFlight: 10,
+		Adaptive: &AdaptiveConcurrencyConfig{Mode: AdaptiveAIMD, LatencyTarget: 100 * time.Millisecond, MaxLimit: 11}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aimd.inFlight = 10
+	aimd.release(10 * time.Millisecond)
+	if aimd.currentLimit() != 11 {
+		t.Errorf("Expected the limit to grow to 11 but got %d.", aimd.currentLimit())
+	}
+
+	aimd.inFlight = 11
+	aimd.release(10 * time.Millisecond)
+	if aimd.currentLimit() != 11 {
+		t.Errorf("Expected the limit to be capped at 11 but got %d.", aimd.currentLimit())
+	}
+
+	aimd.release(time.Second)
+	if aimd.currentLimit() != 9 {
+		t.Errorf("Expected the limit to be cut to 9 but got %d.", aimd.currentLimit())
+	}
+
+	if limit := testutil.ToFloat64(concurrencyLimit.WithLabelValues("aimd")); limit != 9 {
+		t.Errorf("Expected the limit metric to be 9 but got %f.", limit)
+	}
+
+	gradient, err := newConcurrencyLimiter(&ConcurrencyLimitConfig{MaxInFlight: 100,
+		Adaptive: &AdaptiveConcurrencyConfig{Mode: AdaptiveGradient}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gradient.inFlight = 2
+	gradient.release(10 * time.Millisecond)
+	gradient.release(50 * time.Millisecond)
+	if gradient.currentLimit() >= 100 {
+		t.Errorf("Expected the limit to fall as latency rose but got %d.", gradient.currentLimit())
+	}
+}
+
+func TestInvalidConcurrencyLimitErrors(t *testing.T) {
+	for _, limit := range []ConcurrencyLimitConfig{
+		{},
+		{MaxInFlight: 1, Adaptive: &AdaptiveConcurrencyConfig{Mode: "unknown"}},
+		{MaxInFlight: 1, Adaptive: &AdaptiveConcurrencyConfig{Mode: AdaptiveAIMD}},
+	} {
+		cfg := Config{
+			ListenAddress:     ":8888",
+			Handlers:          []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+			ConcurrencyLimits: []ConcurrencyLimitConfig{limit},
+		}
+
+		if _, err := NewService(&cfg); err == nil {
+			t.Errorf("Expected an error for %+v.", limit)
+		}
+	}
+}
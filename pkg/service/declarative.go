@@ -0,0 +1,548 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/puppetlabs/go-libs/pkg/config"
+)
+
+var (
+	errInvalidClientAuth = errors.New("invalid TLS client auth")
+	errNoClientCAs       = errors.New("no certificates found in TLS client CA file")
+	errInvalidRateLimit  = errors.New("invalid rate limit")
+)
+
+// clientAuthTypes maps the names accepted for TLSClientAuth to the tls.ClientAuthType.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// DeclarativeConfig holds the parts of a Config which can be declared in a config file or environment variables. It
+// is loaded with the config package so every field can be set by its environment variable, which overrides the file.
+// The nested structs are squashed so a file sets the fields directly e.g. tlsCertFile: /etc/svc/tls.crt. Lists are
+// given in an environment variable separated by commas.
+type DeclarativeConfig struct {
+	ListenerSettings
+	LogSettings
+	EndpointSettings
+	TLSSettings
+	PlainHTTPSettings
+	CorsSettings
+	RateLimitSettings
+	ConcurrencyLimitSettings
+	CompressionSettings
+	StaticAssetsSettings
+	SecurityHeadersSettings
+	IPFilterSettings
+	IdempotencySettings
+	MaintenanceSettings
+	UpgradeSettings
+}
+
+// ListenerSettings declares where the service listens and the environment it runs in.
+type ListenerSettings struct {
+	Environment          string        `env:"SERVICE_ENVIRONMENT"`
+	ListenAddress        string        `env:"SERVICE_LISTEN_ADDRESS"         default:":8080"`
+	UnixSocketMode       os.FileMode   `env:"SERVICE_UNIX_SOCKET_MODE"`
+	SocketActivation     bool          `env:"SERVICE_SOCKET_ACTIVATION"`
+	SocketActivationName string        `env:"SERVICE_SOCKET_ACTIVATION_NAME"`
+	ShutdownTimeout      time.Duration `env:"SERVICE_SHUTDOWN_TIMEOUT"`
+	StopTimeout          time.Duration `env:"SERVICE_STOP_TIMEOUT"`
+	ReadTimeout          time.Duration `env:"SERVICE_READ_TIMEOUT"`
+	WriteTimeout         time.Duration `env:"SERVICE_WRITE_TIMEOUT"`
+	IdleTimeout          time.Duration `env:"SERVICE_IDLE_TIMEOUT"`
+	TrustedProxies       []string      `env:"SERVICE_TRUSTED_PROXIES"`
+	RemoteIPHeaders      []string      `env:"SERVICE_REMOTE_IP_HEADERS"`
+}
+
+// LogSettings declares the logging.
+type LogSettings struct {
+	LogLevel               string   `env:"SERVICE_LOG_LEVEL"                 default:"INFO"`
+	DisableLog             bool     `env:"SERVICE_DISABLE_LOG"`
+	LogIgnorePaths         []string `env:"SERVICE_LOG_IGNORE_PATHS"`
+	LogLevelControlEnabled bool     `env:"SERVICE_LOG_LEVEL_CONTROL_ENABLED"`
+	LogLevelControlPath    string   `env:"SERVICE_LOG_LEVEL_CONTROL_PATH"`
+	LogLevelControlGroup   string   `env:"SERVICE_LOG_LEVEL_CONTROL_GROUP"`
+}
+
+// EndpointSettings declares the built in endpoints.
+type EndpointSettings struct {
+	ReadinessCheck  bool   `env:"SERVICE_READINESS_CHECK"`
+	Metrics         bool   `env:"SERVICE_METRICS"`
+	EnabledProfiler bool   `env:"SERVICE_PROFILER"`
+	VersionEnabled  bool   `env:"SERVICE_VERSION_ENABLED"`
+	VersionPath     string `env:"SERVICE_VERSION_PATH"`
+	VersionGroup    string `env:"SERVICE_VERSION_GROUP"`
+}
+
+// TLSSettings declares the certificate. TLS is enabled when both files are set. The client auth is one of none,
+// request, require, verify-if-given or require-and-verify and the profile one of modern, intermediate or fips.
+type TLSSettings struct {
+	TLSCertFile     string `env:"SERVICE_TLS_CERT_FILE"`
+	TLSKeyFile      string `env:"SERVICE_TLS_KEY_FILE"`
+	TLSChainFile    string `env:"SERVICE_TLS_CHAIN_FILE"`
+	TLSClientCAFile string `env:"SERVICE_TLS_CLIENT_CA_FILE"`
+	TLSClientAuth   string `env:"SERVICE_TLS_CLIENT_AUTH"`
+	TLSProfile      string `env:"SERVICE_TLS_PROFILE"`
+}
+
+// PlainHTTPSettings declares the plain HTTP listener. It is added when the listen address is set.
+type PlainHTTPSettings struct {
+	PlainHTTPListenAddress   string   `env:"SERVICE_PLAIN_HTTP_LISTEN_ADDRESS"`
+	PlainHTTPServePaths      []string `env:"SERVICE_PLAIN_HTTP_SERVE_PATHS"`
+	PlainHTTPDisableRedirect bool     `env:"SERVICE_PLAIN_HTTP_DISABLE_REDIRECT"`
+	PlainHTTPRedirectStatus  int      `env:"SERVICE_PLAIN_HTTP_REDIRECT_STATUS"`
+	PlainHTTPRedirectHost    string   `env:"SERVICE_PLAIN_HTTP_REDIRECT_HOST"`
+}
+
+// CorsSettings declares CORS. The default CORS config is used unless any of the allow lists are set.
+type CorsSettings struct {
+	CorsEnabled          bool          `env:"SERVICE_CORS_ENABLED"`
+	CorsGroups           []string      `env:"SERVICE_CORS_GROUPS"`
+	CorsAllowOrigins     []string      `env:"SERVICE_CORS_ALLOW_ORIGINS"`
+	CorsAllowMethods     []string      `env:"SERVICE_CORS_ALLOW_METHODS"`
+	CorsAllowHeaders     []string      `env:"SERVICE_CORS_ALLOW_HEADERS"`
+	CorsExposeHeaders    []string      `env:"SERVICE_CORS_EXPOSE_HEADERS"`
+	CorsAllowCredentials bool          `env:"SERVICE_CORS_ALLOW_CREDENTIALS"`
+	CorsMaxAge           time.Duration `env:"SERVICE_CORS_MAX_AGE"`
+}
+
+// RateLimitSettings declares rate limiting. A limit shared by the groups is enabled when the limit and within are
+// set. RateLimits gives a limit per group, each entry being group:limit/within e.g. api:10/1, or limit/within for the
+// default route.
+type RateLimitSettings struct {
+	RateLimit       uint64   `env:"SERVICE_RATE_LIMIT"`
+	RateLimitWithin int      `env:"SERVICE_RATE_LIMIT_WITHIN"`
+	RateLimitGroups []string `env:"SERVICE_RATE_LIMIT_GROUPS"`
+	RateLimits      []string `env:"SERVICE_RATE_LIMITS"`
+}
+
+// ConcurrencyLimitSettings declares a concurrency limit. It is enabled when the max in flight is set. The adaptive
+// mode is aimd or gradient.
+type ConcurrencyLimitSettings struct {
+	ConcurrencyMaxInFlight   int           `env:"SERVICE_CONCURRENCY_MAX_IN_FLIGHT"`
+	ConcurrencyGroups        []string      `env:"SERVICE_CONCURRENCY_GROUPS"`
+	ConcurrencyQueueSize     int           `env:"SERVICE_CONCURRENCY_QUEUE_SIZE"`
+	ConcurrencyQueueTimeout  time.Duration `env:"SERVICE_CONCURRENCY_QUEUE_TIMEOUT"`
+	ConcurrencyRetryAfter    time.Duration `env:"SERVICE_CONCURRENCY_RETRY_AFTER"`
+	ConcurrencyAdaptiveMode  string        `env:"SERVICE_CONCURRENCY_ADAPTIVE_MODE"`
+	ConcurrencyMinLimit      int           `env:"SERVICE_CONCURRENCY_MIN_LIMIT"`
+	ConcurrencyMaxLimit      int           `env:"SERVICE_CONCURRENCY_MAX_LIMIT"`
+	ConcurrencyLatencyTarget time.Duration `env:"SERVICE_CONCURRENCY_LATENCY_TARGET"`
+}
+
+// CompressionSettings declares response compression.
+type CompressionSettings struct {
+	CompressionEnabled             bool     `env:"SERVICE_COMPRESSION_ENABLED"`
+	CompressionGroups              []string `env:"SERVICE_COMPRESSION_GROUPS"`
+	CompressionEncodings           []string `env:"SERVICE_COMPRESSION_ENCODINGS"`
+	CompressionGzipLevel           int      `env:"SERVICE_COMPRESSION_GZIP_LEVEL"`
+	CompressionMinSize             int      `env:"SERVICE_COMPRESSION_MIN_SIZE"`
+	CompressionContentTypes        []string `env:"SERVICE_COMPRESSION_CONTENT_TYPES"`
+	CompressionExcludePaths        []string `env:"SERVICE_COMPRESSION_EXCLUDE_PATHS"`
+	CompressionDecompressRequests  bool     `env:"SERVICE_COMPRESSION_DECOMPRESS_REQUESTS"`
+	CompressionMaxDecompressedSize int64    `env:"SERVICE_COMPRESSION_MAX_DECOMPRESSED_SIZE"`
+}
+
+// StaticAssetsSettings declares a directory of static assets. They are served when the directory is set.
+type StaticAssetsSettings struct {
+	StaticAssetsDir                string   `env:"SERVICE_STATIC_ASSETS_DIR"`
+	StaticAssetsPrefix             string   `env:"SERVICE_STATIC_ASSETS_PREFIX"`
+	StaticAssetsGroup              string   `env:"SERVICE_STATIC_ASSETS_GROUP"`
+	StaticAssetsCacheControl       string   `env:"SERVICE_STATIC_ASSETS_CACHE_CONTROL"`
+	StaticAssetsIndexCacheControl  string   `env:"SERVICE_STATIC_ASSETS_INDEX_CACHE_CONTROL"`
+	StaticAssetsPrecompressed      bool     `env:"SERVICE_STATIC_ASSETS_PRECOMPRESSED"`
+	StaticAssetsDirectoryListing   bool     `env:"SERVICE_STATIC_ASSETS_DIRECTORY_LISTING"`
+	StaticAssetsSPAFallback        bool     `env:"SERVICE_STATIC_ASSETS_SPA_FALLBACK"`
+	StaticAssetsSPAExcludePrefixes []string `env:"SERVICE_STATIC_ASSETS_SPA_EXCLUDE_PREFIXES"`
+}
+
+// SecurityHeadersSettings declares the security headers. They are added when the preset is set.
+type SecurityHeadersSettings struct {
+	SecurityHeadersPreset                  string   `env:"SERVICE_SECURITY_HEADERS_PRESET"`
+	SecurityHeadersGroups                  []string `env:"SERVICE_SECURITY_HEADERS_GROUPS"`
+	SecurityHeadersStrictTransportSecurity string   `env:"SERVICE_SECURITY_HEADERS_STRICT_TRANSPORT_SECURITY"`
+	SecurityHeadersContentSecurityPolicy   string   `env:"SERVICE_SECURITY_HEADERS_CONTENT_SECURITY_POLICY"`
+	SecurityHeadersContentTypeOptions      string   `env:"SERVICE_SECURITY_HEADERS_CONTENT_TYPE_OPTIONS"`
+	SecurityHeadersFrameOptions            string   `env:"SERVICE_SECURITY_HEADERS_FRAME_OPTIONS"`
+	SecurityHeadersReferrerPolicy          string   `env:"SERVICE_SECURITY_HEADERS_REFERRER_POLICY"`
+	SecurityHeadersPermissionsPolicy       string   `env:"SERVICE_SECURITY_HEADERS_PERMISSIONS_POLICY"`
+	SecurityHeadersOmit                    []string `env:"SERVICE_SECURITY_HEADERS_OMIT"`
+}
+
+// IPFilterSettings declares the client IP allow and deny lists. They are applied when either list is set.
+type IPFilterSettings struct {
+	IPFilterGroups []string `env:"SERVICE_IP_FILTER_GROUPS"`
+	IPFilterAllow  []string `env:"SERVICE_IP_FILTER_ALLOW"`
+	IPFilterDeny   []string `env:"SERVICE_IP_FILTER_DENY"`
+}
+
+// IdempotencySettings declares the Idempotency-Key handling. Responses are kept in memory.
+type IdempotencySettings struct {
+	IdempotencyEnabled  bool          `env:"SERVICE_IDEMPOTENCY_ENABLED"`
+	IdempotencyGroups   []string      `env:"SERVICE_IDEMPOTENCY_GROUPS"`
+	IdempotencyTTL      time.Duration `env:"SERVICE_IDEMPOTENCY_TTL"`
+	IdempotencyMethods  []string      `env:"SERVICE_IDEMPOTENCY_METHODS"`
+	IdempotencyRequired bool          `env:"SERVICE_IDEMPOTENCY_REQUIRED"`
+}
+
+// MaintenanceSettings declares maintenance mode. It is added when the control is enabled, which serves the admin
+// endpoint in the group, or the file to watch is set.
+type MaintenanceSettings struct {
+	MaintenanceControlEnabled bool          `env:"SERVICE_MAINTENANCE_CONTROL_ENABLED"`
+	MaintenanceFile           string        `env:"SERVICE_MAINTENANCE_FILE"`
+	MaintenanceRetryAfter     time.Duration `env:"SERVICE_MAINTENANCE_RETRY_AFTER"`
+	MaintenanceDetail         string        `env:"SERVICE_MAINTENANCE_DETAIL"`
+	MaintenanceAllowRoutes    []string      `env:"SERVICE_MAINTENANCE_ALLOW_ROUTES"`
+	MaintenanceAllowClients   []string      `env:"SERVICE_MAINTENANCE_ALLOW_CLIENTS"`
+	MaintenanceUnready        bool          `env:"SERVICE_MAINTENANCE_UNREADY"`
+	MaintenancePath           string        `env:"SERVICE_MAINTENANCE_PATH"`
+	MaintenanceGroup          string        `env:"SERVICE_MAINTENANCE_GROUP"`
+}
+
+// UpgradeSettings declares zero downtime upgrades on the default signal.
+type UpgradeSettings struct {
+	UpgradeEnabled      bool          `env:"SERVICE_UPGRADE_ENABLED"`
+	UpgradeReadyTimeout time.Duration `env:"SERVICE_UPGRADE_READY_TIMEOUT"`
+}
+
+// LoadConfig loads a DeclarativeConfig from the file, or only from the environment if the filename is empty, and
+// returns the Config for it with the handlers. Anything which can only be set in code e.g. middleware or hooks can be
+// added to the returned Config before calling NewService.
+func LoadConfig(filename string, handlers []Handler) (*Config, error) {
+	var declared DeclarativeConfig
+
+	var err error
+	if filename == "" {
+		err = config.LoadViperConfig(&declared)
+	} else {
+		err = config.LoadViperConfigFromFile(filename, &declared)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to load service config: %w", err)
+	}
+
+	return declared.ServiceConfig(handlers)
+}
+
+// ServiceConfig returns the Config declared with the handlers.
+func (d *DeclarativeConfig) ServiceConfig(handlers []Handler) (*Config, error) {
+	cfg := &Config{
+		ListenAddress:   d.ListenAddress,
+		Environment:     d.Environment,
+		UnixSocketMode:  d.UnixSocketMode,
+		ShutdownTimeout: d.ShutdownTimeout,
+		StopTimeout:     d.StopTimeout,
+		ReadTimeout:     d.ReadTimeout,
+		WriteTimeout:    d.WriteTimeout,
+		IdleTimeout:     d.IdleTimeout,
+		TrustedProxies:  d.TrustedProxies,
+		RemoteIPHeaders: d.RemoteIPHeaders,
+		LogLevel:        d.LogLevel,
+		DisableLog:      d.DisableLog,
+		LogIgnorePaths:  d.LogIgnorePaths,
+		ReadinessCheck:  d.ReadinessCheck,
+		Metrics:         d.Metrics,
+		EnabledProfiler: d.EnabledProfiler,
+		Handlers:        handlers,
+		Cors:            d.corsConfig(),
+		RateLimit:       d.rateLimitConfig(),
+		Compression:     d.compressionConfig(),
+		PlainHTTP:       d.plainHTTPConfig(),
+		Idempotency:     d.idempotencyConfig(),
+		Maintenance:     d.maintenanceConfig(),
+	}
+
+	if d.SocketActivation {
+		cfg.SocketActivation = &SocketActivationConfig{Name: d.SocketActivationName}
+	}
+
+	if d.LogLevelControlEnabled {
+		cfg.LogLevelControl = &LogLevelConfig{
+			Endpoint: true,
+			Path:     d.LogLevelControlPath,
+			Group:    d.LogLevelControlGroup,
+		}
+	}
+
+	if d.VersionEnabled {
+		cfg.Version = &VersionConfig{Path: d.VersionPath, Group: d.VersionGroup}
+	}
+
+	if d.UpgradeEnabled {
+		cfg.Upgrade = &UpgradeConfig{ReadyTimeout: d.UpgradeReadyTimeout}
+	}
+
+	if d.StaticAssetsDir != "" {
+		cfg.StaticAssets = []StaticAssetsConfig{d.staticAssetsConfig()}
+	}
+
+	if d.SecurityHeadersPreset != "" {
+		cfg.SecurityHeaders = []SecurityHeadersConfig{d.securityHeadersConfig()}
+	}
+
+	if d.ConcurrencyMaxInFlight > 0 {
+		cfg.ConcurrencyLimits = []ConcurrencyLimitConfig{d.concurrencyLimitConfig()}
+	}
+
+	if len(d.IPFilterAllow) > 0 || len(d.IPFilterDeny) > 0 {
+		cfg.IPFilters = []IPFilterConfig{{Groups: d.IPFilterGroups, Allow: d.IPFilterAllow, Deny: d.IPFilterDeny}}
+	}
+
+	for _, entry := range d.RateLimits {
+		limit, err := ParseRateLimit(entry)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RateLimits = append(cfg.RateLimits, limit)
+	}
+
+	certConfig, err := d.certConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.CertConfig = certConfig
+
+	return cfg, nil
+}
+
+func (d *DeclarativeConfig) certConfig() (*ServerCertificateConfig, error) {
+	if d.TLSCertFile == "" || d.TLSKeyFile == "" {
+		return nil, nil //nolint:nilnil // no TLS is not an error
+	}
+
+	clientAuth, found := clientAuthTypes[strings.ToLower(d.TLSClientAuth)]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", errInvalidClientAuth, d.TLSClientAuth)
+	}
+
+	certConfig := &ServerCertificateConfig{
+		CertificateFile: d.TLSCertFile,
+		KeyFile:         d.TLSKeyFile,
+		ChainFile:       d.TLSChainFile,
+		ClientAuth:      clientAuth,
+		Profile:         strings.ToLower(d.TLSProfile),
+	}
+
+	if d.TLSClientCAFile != "" {
+		caPEM, err := os.ReadFile(filepath.Clean(d.TLSClientCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TLS client CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%w: %s", errNoClientCAs, d.TLSClientCAFile)
+		}
+		certConfig.ClientCAs = pool
+	}
+
+	return certConfig, nil
+}
+
+func (d *DeclarativeConfig) corsConfig() *CorsConfig {
+	if !d.CorsEnabled {
+		return nil
+	}
+
+	corsConfig := &CorsConfig{Groups: d.CorsGroups, Enabled: true}
+	if len(d.CorsAllowOrigins) == 0 && len(d.CorsAllowMethods) == 0 && len(d.CorsAllowHeaders) == 0 {
+		return corsConfig
+	}
+
+	override := cors.DefaultConfig()
+	if len(d.CorsAllowOrigins) > 0 {
+		override.AllowAllOrigins = false
+		override.AllowOrigins = d.CorsAllowOrigins
+	} else {
+		override.AllowAllOrigins = true
+	}
+
+	if len(d.CorsAllowMethods) > 0 {
+		override.AllowMethods = d.CorsAllowMethods
+	}
+
+	if len(d.CorsAllowHeaders) > 0 {
+		override.AllowHeaders = d.CorsAllowHeaders
+	}
+
+	override.ExposeHeaders = d.CorsExposeHeaders
+	override.AllowCredentials = d.CorsAllowCredentials
+	if d.CorsMaxAge > 0 {
+		override.MaxAge = d.CorsMaxAge
+	}
+	corsConfig.OverrideCfg = &override
+
+	return corsConfig
+}
+
+func (d *DeclarativeConfig) rateLimitConfig() *RateLimitConfig {
+	if d.RateLimit == 0 || d.RateLimitWithin == 0 {
+		return nil
+	}
+
+	return &RateLimitConfig{Groups: d.RateLimitGroups, Limit: d.RateLimit, Within: d.RateLimitWithin}
+}
+
+// ParseRateLimit parses a rate limit given as group:limit/within e.g. api:10/1, or as limit/within for the default
+// route. Within is in seconds.
+func ParseRateLimit(entry string) (RateLimitConfig, error) {
+	var limit RateLimitConfig
+
+	group, rate, found := strings.Cut(strings.TrimSpace(entry), ":")
+	if !found {
+		group, rate = "", group
+	}
+
+	limitValue, withinValue, found := strings.Cut(rate, "/")
+	if !found {
+		return limit, fmt.Errorf("%w %q: expected [group:]limit/within", errInvalidRateLimit, entry)
+	}
+
+	var err error
+	if limit.Limit, err = strconv.ParseUint(strings.TrimSpace(limitValue), 10, 64); err != nil {
+		return limit, fmt.Errorf("%w %q: %w", errInvalidRateLimit, entry, err)
+	}
+
+	if limit.Within, err = strconv.Atoi(strings.TrimSpace(withinValue)); err != nil {
+		return limit, fmt.Errorf("%w %q: %w", errInvalidRateLimit, entry, err)
+	}
+
+	if group = strings.TrimSpace(group); group != "" {
+		limit.Groups = []string{group}
+	}
+
+	return limit, nil
+}
+
+func (d *DeclarativeConfig) concurrencyLimitConfig() ConcurrencyLimitConfig {
+	limit := ConcurrencyLimitConfig{
+		Groups:       d.ConcurrencyGroups,
+		MaxInFlight:  d.ConcurrencyMaxInFlight,
+		QueueSize:    d.ConcurrencyQueueSize,
+		QueueTimeout: d.ConcurrencyQueueTimeout,
+		RetryAfter:   d.ConcurrencyRetryAfter,
+	}
+
+	if d.ConcurrencyAdaptiveMode != "" {
+		limit.Adaptive = &AdaptiveConcurrencyConfig{
+			Mode:          strings.ToLower(d.ConcurrencyAdaptiveMode),
+			MinLimit:      d.ConcurrencyMinLimit,
+			MaxLimit:      d.ConcurrencyMaxLimit,
+			LatencyTarget: d.ConcurrencyLatencyTarget,
+		}
+	}
+
+	return limit
+}
+
+func (d *DeclarativeConfig) compressionConfig() *CompressionConfig {
+	if !d.CompressionEnabled {
+		return nil
+	}
+
+	return &CompressionConfig{
+		Groups:              d.CompressionGroups,
+		Encodings:           d.CompressionEncodings,
+		GzipLevel:           d.CompressionGzipLevel,
+		MinSize:             d.CompressionMinSize,
+		ContentTypes:        d.CompressionContentTypes,
+		ExcludePaths:        d.CompressionExcludePaths,
+		DecompressRequests:  d.CompressionDecompressRequests,
+		MaxDecompressedSize: d.CompressionMaxDecompressedSize,
+	}
+}
+
+func (d *DeclarativeConfig) plainHTTPConfig() *PlainHTTPConfig {
+	if d.PlainHTTPListenAddress == "" {
+		return nil
+	}
+
+	return &PlainHTTPConfig{
+		ListenAddress:   d.PlainHTTPListenAddress,
+		ServePaths:      d.PlainHTTPServePaths,
+		DisableRedirect: d.PlainHTTPDisableRedirect,
+		RedirectStatus:  d.PlainHTTPRedirectStatus,
+		RedirectHost:    d.PlainHTTPRedirectHost,
+	}
+}
+
+func (d *DeclarativeConfig) idempotencyConfig() *IdempotencyConfig {
+	if !d.IdempotencyEnabled {
+		return nil
+	}
+
+	return &IdempotencyConfig{
+		Groups:   d.IdempotencyGroups,
+		TTL:      d.IdempotencyTTL,
+		Methods:  d.IdempotencyMethods,
+		Required: d.IdempotencyRequired,
+	}
+}
+
+func (d *DeclarativeConfig) maintenanceConfig() *MaintenanceConfig {
+	if !d.MaintenanceControlEnabled && d.MaintenanceFile == "" {
+		return nil
+	}
+
+	config := &MaintenanceConfig{
+		File:         d.MaintenanceFile,
+		RetryAfter:   d.MaintenanceRetryAfter,
+		AllowRoutes:  d.MaintenanceAllowRoutes,
+		AllowClients: d.MaintenanceAllowClients,
+		Unready:      d.MaintenanceUnready,
+		Endpoint:     d.MaintenanceControlEnabled,
+		Path:         d.MaintenancePath,
+		Group:        d.MaintenanceGroup,
+	}
+
+	if d.MaintenanceDetail != "" {
+		config.Problem = &Problem{Title: http.StatusText(http.StatusServiceUnavailable), Detail: d.MaintenanceDetail}
+	}
+
+	return config
+}
+
+func (d *DeclarativeConfig) staticAssetsConfig() StaticAssetsConfig {
+	return StaticAssetsConfig{
+		Prefix:             d.StaticAssetsPrefix,
+		FS:                 os.DirFS(d.StaticAssetsDir),
+		Group:              d.StaticAssetsGroup,
+		CacheControl:       d.StaticAssetsCacheControl,
+		IndexCacheControl:  d.StaticAssetsIndexCacheControl,
+		Precompressed:      d.StaticAssetsPrecompressed,
+		DirectoryListing:   d.StaticAssetsDirectoryListing,
+		SPAFallback:        d.StaticAssetsSPAFallback,
+		SPAExcludePrefixes: d.StaticAssetsSPAExcludePrefixes,
+	}
+}
+
+func (d *DeclarativeConfig) securityHeadersConfig() SecurityHeadersConfig {
+	return SecurityHeadersConfig{
+		Groups:                  d.SecurityHeadersGroups,
+		Preset:                  d.SecurityHeadersPreset,
+		StrictTransportSecurity: d.SecurityHeadersStrictTransportSecurity,
+		ContentSecurityPolicy:   d.SecurityHeadersContentSecurityPolicy,
+		ContentTypeOptions:      d.SecurityHeadersContentTypeOptions,
+		FrameOptions:            d.SecurityHeadersFrameOptions,
+		ReferrerPolicy:          d.SecurityHeadersReferrerPolicy,
+		PermissionsPolicy:       d.SecurityHeadersPermissionsPolicy,
+		Omit:                    d.SecurityHeadersOmit,
+	}
+}
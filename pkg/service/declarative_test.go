@@ -0,0 +1,241 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const declarativeYAML = `
+listenAddress: 127.0.0.1:9000
+logLevel: debug
+logLevelControlEnabled: true
+logLevelControlGroup: admin
+shutdownTimeout: 20s
+stopTimeout: 10s
+readTimeout: 30s
+writeTimeout: 1m
+idleTimeout: 2m
+readinessCheck: true
+metrics: true
+trustedProxies:
+  - 10.0.0.0/8
+cors:
+  corsEnabled: true
+  corsAllowOrigins:
+    - https://example.com
+rateLimit: 10
+rateLimitWithin: 1
+rateLimitGroups:
+  - api
+rateLimits:
+  - admin:100/60
+  - 1000/1
+compressionEnabled: true
+compressionMinSize: 512
+securityHeadersPreset: api
+ipFilterDeny:
+  - 192.0.2.0/24
+idempotencyEnabled: true
+idempotencyTTL: 1h
+plainHTTPListenAddress: 127.0.0.1:9080
+plainHTTPServePaths:
+  - /readiness
+`
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	t.Setenv("SERVICE_TLS_CERT_FILE", "server.crt")
+	t.Setenv("SERVICE_TLS_KEY_FILE", "server.key")
+	t.Setenv("SERVICE_IP_FILTER_ALLOW", "10.0.0.0/8,127.0.0.1")
+
+	handlers := []Handler{
+		{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint, Group: "api"},
+		{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/admin", Group: "admin"},
+	}
+	cfg, err := LoadConfig(writeConfigFile(t, "service.yaml", declarativeYAML), handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != "127.0.0.1:9000" || cfg.LogLevel != "debug" || cfg.ShutdownTimeout != 20*time.Second ||
+		cfg.StopTimeout != 10*time.Second {
+		t.Errorf("Unexpected listener settings %s %s %s %s.", cfg.ListenAddress, cfg.LogLevel, cfg.ShutdownTimeout,
+			cfg.StopTimeout)
+	}
+
+	if cfg.LogLevelControl == nil || !cfg.LogLevelControl.Endpoint || cfg.LogLevelControl.Group != "admin" {
+		t.Errorf("Unexpected log level control %+v.", cfg.LogLevelControl)
+	}
+
+	if !cfg.ReadinessCheck || !cfg.Metrics || len(cfg.TrustedProxies) != 1 || len(cfg.Handlers) != 2 {
+		t.Error("Unexpected endpoint settings.")
+	}
+
+	if cfg.Cors == nil || cfg.Cors.OverrideCfg == nil || cfg.Cors.OverrideCfg.AllowOrigins[0] != "https://example.com" {
+		t.Errorf("Unexpected CORS config %+v.", cfg.Cors)
+	}
+
+	if cfg.RateLimit == nil || cfg.RateLimit.Limit != 10 || cfg.RateLimit.Groups[0] != "api" {
+		t.Errorf("Unexpected rate limit config %+v.", cfg.RateLimit)
+	}
+
+	if len(cfg.RateLimits) != 2 || cfg.RateLimits[0].Groups[0] != "admin" || cfg.RateLimits[0].Limit != 100 ||
+		cfg.RateLimits[0].Within != 60 || len(cfg.RateLimits[1].Groups) != 0 || cfg.RateLimits[1].Limit != 1000 {
+		t.Errorf("Unexpected rate limits %+v.", cfg.RateLimits)
+	}
+
+	if cfg.Compression == nil || cfg.Compression.MinSize != 512 {
+		t.Errorf("Unexpected compression config %+v.", cfg.Compression)
+	}
+
+	if len(cfg.SecurityHeaders) != 1 || cfg.SecurityHeaders[0].Preset != SecurityPresetAPI {
+		t.Errorf("Unexpected security headers %+v.", cfg.SecurityHeaders)
+	}
+
+	if len(cfg.IPFilters) != 1 || len(cfg.IPFilters[0].Allow) != 2 || len(cfg.IPFilters[0].Deny) != 1 {
+		t.Errorf("Unexpected IP filters %+v.", cfg.IPFilters)
+	}
+
+	if cfg.Idempotency == nil || cfg.Idempotency.TTL != time.Hour {
+		t.Errorf("Unexpected idempotency config %+v.", cfg.Idempotency)
+	}
+
+	if cfg.CertConfig == nil || cfg.CertConfig.CertificateFile != "server.crt" || cfg.CertConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("Unexpected cert config %+v.", cfg.CertConfig)
+	}
+
+	if cfg.PlainHTTP == nil || cfg.PlainHTTP.ServePaths[0] != ReadinessEndpoint {
+		t.Errorf("Unexpected plain HTTP config %+v.", cfg.PlainHTTP)
+	}
+
+	if cfg.StaticAssets != nil || cfg.Upgrade != nil || cfg.SocketActivation != nil {
+		t.Error("Undeclared features should not be enabled.")
+	}
+
+	// The certificate files do not exist so are replaced to pass validation.
+	cfg.CertConfig.Certificate, _ = testCertificate(t)
+	svc, err := NewService(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create a service from the config: %s", err)
+	}
+
+	if svc.Server.ReadTimeout != 30*time.Second || svc.Server.WriteTimeout != time.Minute ||
+		svc.Server.IdleTimeout != 2*time.Minute {
+		t.Errorf("Unexpected server timeouts %s %s %s.", svc.Server.ReadTimeout, svc.Server.WriteTimeout,
+			svc.Server.IdleTimeout)
+	}
+}
+
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	t.Setenv("SERVICE_LISTEN_ADDRESS", "unix:///tmp/svc.sock")
+	t.Setenv("SERVICE_UNIX_SOCKET_MODE", "0600")
+	t.Setenv("SERVICE_STATIC_ASSETS_DIR", t.TempDir())
+	t.Setenv("SERVICE_STATIC_ASSETS_PREFIX", "/ui")
+	t.Setenv("SERVICE_CONCURRENCY_MAX_IN_FLIGHT", "50")
+	t.Setenv("SERVICE_CONCURRENCY_ADAPTIVE_MODE", "Gradient")
+	t.Setenv("SERVICE_MAINTENANCE_FILE", "/run/svc/maintenance")
+	t.Setenv("SERVICE_MAINTENANCE_ALLOW_CLIENTS", "10.0.0.0/8,192.0.2.1")
+	t.Setenv("SERVICE_MAINTENANCE_DETAIL", "Migrating the database.")
+
+	handlers := []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}}
+	cfg, err := LoadConfig("", handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != "unix:///tmp/svc.sock" || cfg.UnixSocketMode != 0o600 || cfg.LogLevel != "INFO" {
+		t.Errorf("Unexpected config %s %o %s.", cfg.ListenAddress, cfg.UnixSocketMode, cfg.LogLevel)
+	}
+
+	if len(cfg.StaticAssets) != 1 || cfg.StaticAssets[0].Prefix != "/ui" || cfg.StaticAssets[0].FS == nil {
+		t.Errorf("Unexpected static assets %+v.", cfg.StaticAssets)
+	}
+
+	if len(cfg.ConcurrencyLimits) != 1 || cfg.ConcurrencyLimits[0].MaxInFlight != 50 ||
+		cfg.ConcurrencyLimits[0].Adaptive == nil || cfg.ConcurrencyLimits[0].Adaptive.Mode != AdaptiveGradient {
+		t.Errorf("Unexpected concurrency limits %+v.", cfg.ConcurrencyLimits)
+	}
+
+	if m := cfg.Maintenance; m == nil || m.File != "/run/svc/maintenance" || len(m.AllowClients) != 2 ||
+		m.Problem == nil || m.Problem.Detail != "Migrating the database." {
+		t.Errorf("Unexpected maintenance config %+v.", cfg.Maintenance)
+	}
+
+	if cfg.CertConfig != nil || cfg.Cors != nil || cfg.RateLimit != nil || cfg.RateLimits != nil ||
+		cfg.Compression != nil {
+		t.Error("Undeclared features should not be enabled.")
+	}
+}
+
+func TestDeclarativeConfigTLSClientAuth(t *testing.T) {
+	declared := DeclarativeConfig{TLSSettings: TLSSettings{
+		TLSCertFile: "server.crt", TLSKeyFile: "server.key", TLSClientAuth: "sometimes",
+	}}
+
+	if _, err := declared.ServiceConfig(nil); !errors.Is(err, errInvalidClientAuth) {
+		t.Errorf("Expected an invalid client auth error but got %v.", err)
+	}
+
+	declared.TLSClientAuth = "require-and-verify"
+	declared.TLSClientCAFile = writeConfigFile(t, "ca.pem", "not a certificate")
+	if _, err := declared.ServiceConfig(nil); !errors.Is(err, errNoClientCAs) {
+		t.Errorf("Expected a no client CAs error but got %v.", err)
+	}
+}
+
+func TestDeclarativeConfigRateLimitPerGroup(t *testing.T) {
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("SERVICE_RATE_LIMITS", "api:1/60, admin:2/60")
+
+			handlers := []Handler{
+				{Method: http.MethodGet, HTTPHandler: userHandler(), Path: "/api", Group: "api"},
+				{Method: http.MethodGet, HTTPHandler: userHandler(), Path: "/admin", Group: "admin"},
+			}
+			cfg, err := LoadConfig("", handlers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Router = router
+
+			svc, err := NewService(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for path, codes := range map[string][]int{
+				"/api":   {http.StatusOK, http.StatusTooManyRequests},
+				"/admin": {http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+			} {
+				for i, code := range codes {
+					if rr := sendRequestFrom(svc, "192.0.2.1:1234", path); rr.Code != code {
+						t.Errorf("Expected request %d to %s to get %d but got %d.", i, path, code, rr.Code)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	for _, entry := range []string{"api", "api:10", "api:ten/1", "api:10/-", "10/1/1"} {
+		if _, err := ParseRateLimit(entry); !errors.Is(err, errInvalidRateLimit) {
+			t.Errorf("Expected %q to be an invalid rate limit but got %v.", entry, err)
+		}
+	}
+}
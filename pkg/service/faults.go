@@ -0,0 +1,386 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	neturl "net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultFaultInjectionPath is the default path of the fault injection admin endpoint.
+	DefaultFaultInjectionPath = "/admin/faults"
+	// EnvironmentProduction is the production Environment, in which faults are never injected.
+	EnvironmentProduction = "production"
+	// EnvironmentDevelopment is the development Environment, in which faults can be injected by default.
+	EnvironmentDevelopment = "development"
+	// EnvironmentTest is the test Environment, in which faults can be injected by default.
+	EnvironmentTest = "test"
+	// EnvironmentStaging is the staging Environment, in which faults can be injected by default.
+	EnvironmentStaging = "staging"
+
+	faultLatency  = "latency"
+	faultAbort    = "abort"
+	faultTruncate = "truncate"
+)
+
+var (
+	errFaultsInProduction = errors.New("faults can only be injected in the allowed environments, never production")
+	errInvalidPercentage  = errors.New("must be greater than zero and at most 100")
+	errNoFault            = errors.New("no latency, abort status or truncation")
+	errAbortAndTruncate   = errors.New("a fault can either abort or truncate a response, not both")
+	errInvalidAbortStatus = errors.New("invalid abort status")
+	errNegative           = errors.New("must not be negative")
+)
+
+// FaultInjectionConfig injects faults into requests to test how clients cope with them. Faults are only injected once
+// turned on, either by Enabled or by a PUT of {"enabled": true} to the admin endpoint, and neither is allowed unless
+// the service's Environment is one of the AllowedEnvironments. Production is never allowed. The admin endpoint is only
+// served when Endpoint is set, and then must be in a Group protecting it.
+type FaultInjectionConfig struct {
+	Enabled             bool     // If true, faults are injected from start up.
+	Faults              []Fault  // The faults, the first matching a request and drawn by its percentage is injected.
+	AllowedEnvironments []string // Optional - where faults can be injected. Default is development, test and staging.
+	Endpoint            bool     // If true, the admin endpoint is served.
+	Path                string   // Optional - the path of the admin endpoint. Default is /admin/faults.
+	Group               string   // The group of the admin endpoint, required with Endpoint e.g. with auth middleware.
+}
+
+// Fault delays, aborts or truncates a percentage of the requests it matches. A request matches when it matches every
+// criterion set. A delayed request is then aborted or truncated if the fault says so too.
+type Fault struct {
+	Percentage    float64           // The percentage of matching requests the fault is injected into, up to 100.
+	Method        string            // Optional - the method of the requests to match.
+	Route         string            // Optional - the route of the requests to match in gin's syntax e.g. /users/:id.
+	Headers       map[string]string // Optional - headers the requests must have, an empty value matching any value.
+	Clients       []string          // Optional - the CIDRs or IPs of the clients to match.
+	Latency       time.Duration     // Optional - how long to delay the requests.
+	AbortStatus   int               // Optional - the status to respond with in place of the handler e.g. 503.
+	TruncateAfter int               // Optional - the bytes of the response sent before the connection is cut.
+}
+
+// FaultInjection is the body of the fault injection admin endpoint.
+type FaultInjection struct {
+	Enabled     bool   `json:"enabled"`               // Whether faults are being injected.
+	Environment string `json:"environment,omitempty"` // The service's Environment. Ignored on a PUT.
+	Faults      int    `json:"faults,omitempty"`      // The number of faults configured. Ignored on a PUT.
+}
+
+// faultsAllowed reports whether faults can be injected in the environment, which must be one of the allowed
+// environments, or by default development, test or staging, and never production.
+func faultsAllowed(environment string, allowed []string) bool {
+	environment = strings.ToLower(strings.TrimSpace(environment))
+	if environment == "" || environment == EnvironmentProduction || environment == "prod" {
+		return false
+	}
+
+	if len(allowed) == 0 {
+		allowed = []string{EnvironmentDevelopment, EnvironmentTest, EnvironmentStaging}
+	}
+
+	for _, candidate := range allowed {
+		if strings.EqualFold(strings.TrimSpace(candidate), environment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateFaultInjection checks the faults and that they can only be turned on in the allowed environments.
+func (c *Config) validateFaultInjection(v *validation) {
+	config := c.FaultInjection
+	if config == nil {
+		return
+	}
+
+	if config.Enabled && !faultsAllowed(c.Environment, config.AllowedEnvironments) {
+		v.add("FaultInjection.Enabled", errFaultsInProduction)
+	}
+
+	if config.Endpoint && config.Group == "" {
+		v.add("FaultInjection.Group", errAdminEndpointGroup)
+	}
+
+	for i, fault := range config.Faults {
+		field := fmt.Sprintf("FaultInjection.Faults[%d]", i)
+		if fault.Percentage <= 0 || fault.Percentage > 100 {
+			v.add(field+".Percentage", errInvalidPercentage)
+		}
+
+		if fault.Route != "" && !strings.HasPrefix(fault.Route, "/") {
+			v.add(field+".Route", fmt.Errorf("%w: %q", errInvalidPath, fault.Route))
+		}
+
+		_, err := parsePrefixes(fault.Clients)
+		v.add(field+".Clients", err)
+
+		switch {
+		case fault.Latency < 0:
+			v.add(field+".Latency", errNegative)
+		case fault.TruncateAfter < 0:
+			v.add(field+".TruncateAfter", errNegative)
+		case fault.AbortStatus != 0 && (fault.AbortStatus < http.StatusBadRequest || fault.AbortStatus > 599):
+			v.add(field+".AbortStatus", fmt.Errorf("%w: %d", errInvalidAbortStatus, fault.AbortStatus))
+		case fault.AbortStatus != 0 && fault.TruncateAfter > 0:
+			v.add(field, errAbortAndTruncate)
+		case fault.Latency == 0 && fault.AbortStatus == 0 && fault.TruncateAfter == 0:
+			v.add(field, errNoFault)
+		}
+	}
+}
+
+// fault is a Fault with its route and clients parsed.
+type fault struct {
+	Fault
+	route   []string
+	clients []netip.Prefix
+}
+
+// matches reports whether the request is one the fault is injected into.
+func (f *fault) matches(c *gin.Context) bool {
+	if f.Method != "" && f.Method != AnyMethod && !strings.EqualFold(f.Method, c.Request.Method) {
+		return false
+	}
+
+	if f.route != nil && !matchSegments(f.route, pathSegments(requestedURL(c.Request).Path)) {
+		return false
+	}
+
+	for name, value := range f.Headers {
+		values, found := c.Request.Header[http.CanonicalHeaderKey(name)]
+		if !found || (value != "" && !containsFold(values, value)) {
+			return false
+		}
+	}
+
+	if len(f.clients) > 0 {
+		addr, err := netip.ParseAddr(c.ClientIP())
+		if err != nil || !containsAddr(f.clients, addr.Unmap()) {
+			return false
+		}
+	}
+
+	return rand.Float64()*100 < f.Percentage //nolint:gosec // chance does not need to be secure
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(strings.TrimSpace(candidate), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestedURL returns the URL the client requested, before any rewriting to route it to an API version.
+func requestedURL(r *http.Request) *neturl.URL {
+	if requested, ok := r.Context().Value(versionedURLKey{}).(*neturl.URL); ok {
+		return requested
+	}
+
+	return r.URL
+}
+
+// faultInjector injects the faults while it is turned on.
+type faultInjector struct {
+	enabled     atomic.Bool
+	environment string
+	allowed     []string
+	adminPath   string
+	faults      []*fault
+}
+
+// newFaultInjector returns the injector of the config or nil if fault injection is not configured.
+func newFaultInjector(cfg *Config) (*faultInjector, error) {
+	config := cfg.FaultInjection
+	if config == nil {
+		return nil, nil //nolint:nilnil // no fault injection is not an error
+	}
+
+	injector := &faultInjector{
+		environment: cfg.Environment,
+		allowed:     config.AllowedEnvironments,
+		adminPath:   faultInjectionPath(config),
+	}
+	for _, configured := range config.Faults {
+		clients, err := parsePrefixes(configured.Clients)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed := &fault{Fault: configured, clients: clients}
+		if configured.Route != "" {
+			parsed.route = pathSegments(configured.Route)
+		}
+		injector.faults = append(injector.faults, parsed)
+	}
+
+	if config.Enabled {
+		if err := injector.enable(true); err != nil {
+			return nil, err
+		}
+	}
+
+	return injector, nil
+}
+
+// enable turns fault injection on or off, refusing to turn it on outside the allowed environments.
+func (f *faultInjector) enable(enabled bool) error {
+	if enabled && !faultsAllowed(f.environment, f.allowed) {
+		return errFaultsInProduction
+	}
+
+	if f.enabled.Swap(enabled) != enabled {
+		logrus.Warnf("Fault injection turned %s.", map[bool]string{true: "on", false: "off"}[enabled])
+	}
+
+	return nil
+}
+
+func (f *faultInjector) current() FaultInjection {
+	return FaultInjection{Enabled: f.enabled.Load(), Environment: f.environment, Faults: len(f.faults)}
+}
+
+// middleware injects the first fault matching a request. The admin endpoint is never faulted so that it can always
+// turn fault injection off.
+func (f *faultInjector) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !f.enabled.Load() || c.Request.URL.Path == f.adminPath {
+			return
+		}
+
+		for _, fault := range f.faults {
+			if fault.matches(c) {
+				fault.inject(c)
+
+				return
+			}
+		}
+	}
+}
+
+// inject delays the request then aborts it or cuts its response short.
+func (f *fault) inject(c *gin.Context) {
+	path := requestedURL(c.Request).Path
+	if f.Latency > 0 {
+		injectedFaults.WithLabelValues(faultLatency).Inc()
+		logrus.Debugf("Delaying %s %s by %s.", c.Request.Method, path, f.Latency)
+
+		select {
+		case <-time.After(f.Latency):
+		case <-c.Request.Context().Done():
+			c.Abort()
+
+			return
+		}
+	}
+
+	if f.AbortStatus != 0 {
+		injectedFaults.WithLabelValues(faultAbort).Inc()
+		logrus.Debugf("Aborting %s %s with %d.", c.Request.Method, path, f.AbortStatus)
+		c.AbortWithStatusJSON(f.AbortStatus, gin.H{"error": "fault injected"})
+
+		return
+	}
+
+	if f.TruncateAfter > 0 {
+		writer := &truncateWriter{ResponseWriter: c.Writer, remaining: f.TruncateAfter}
+		c.Writer = writer
+		c.Next()
+
+		if writer.truncated {
+			injectedFaults.WithLabelValues(faultTruncate).Inc()
+			logrus.Debugf("Truncated the response to %s %s after %d bytes.", c.Request.Method, path, f.TruncateAfter)
+			// The server closes the connection without completing the response.
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// truncateWriter sends the first bytes of a response and discards the rest.
+type truncateWriter struct {
+	gin.ResponseWriter
+	remaining int
+	truncated bool
+}
+
+// Write implements io.Writer.
+func (w *truncateWriter) Write(data []byte) (int, error) {
+	if w.truncated {
+		return len(data), nil
+	}
+
+	if len(data) <= w.remaining {
+		w.remaining -= len(data)
+
+		return w.ResponseWriter.Write(data) //nolint:wrapcheck // the writer is passed through
+	}
+
+	if _, err := w.ResponseWriter.Write(data[:w.remaining]); err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+	w.ResponseWriter.Flush()
+	w.truncated = true
+
+	return len(data), nil
+}
+
+// WriteString implements io.StringWriter.
+func (w *truncateWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// handler returns the admin endpoint handler.
+func (f *faultInjector) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet {
+			c.JSON(http.StatusOK, f.current())
+
+			return
+		}
+
+		var request FaultInjection
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		if err := f.enable(request.Enabled); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, f.current())
+	}
+}
+
+// faultInjectionPath returns the path of the admin endpoint.
+func faultInjectionPath(config *FaultInjectionConfig) string {
+	if config.Path == "" {
+		return DefaultFaultInjectionPath
+	}
+
+	return config.Path
+}
+
+func setupFaultInjection(config *FaultInjectionConfig, injector *faultInjector, engine *gin.Engine) {
+	if injector == nil || !config.Endpoint {
+		return
+	}
+
+	group := getRouterGroup(engine, config.Group)
+	handleRoute(group, http.MethodGet, injector.adminPath, injector.handler())
+	handleRoute(group, http.MethodPut, injector.adminPath, injector.handler())
+}
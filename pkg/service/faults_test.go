@@ -0,0 +1,229 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func putFaultInjection(svc *Service, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, DefaultFaultInjectionPath, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func faultConfig(environment string, enabled bool, faults ...Fault) Config {
+	return Config{
+		ListenAddress: ":8888",
+		Environment:   environment,
+		Handlers: []Handler{
+			{Method: http.MethodGet, Path: "/users/:id", Handler: apiVersionHandler("1")},
+			{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler()},
+		},
+		FaultInjection: &FaultInjectionConfig{Enabled: enabled, Faults: faults, Endpoint: true, Group: "admin"},
+	}
+}
+
+func TestFaultInjectionAbortMatchedByRouteAndHeader(t *testing.T) {
+	cfg := faultConfig("test", true, Fault{
+		Percentage:  100,
+		Route:       "/users/:id",
+		Headers:     map[string]string{"X-Chaos": "abort"},
+		AbortStatus: http.StatusServiceUnavailable,
+	})
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := injectedFaults.WithLabelValues(faultAbort)
+	before := testutil.ToFloat64(counter)
+	chaos := headers{Name: "X-Chaos", Value: "abort"}
+
+	checkBody(t, svc, "/users/7", http.StatusServiceUnavailable, "", chaos)
+	checkBody(t, svc, "/users/7", http.StatusOK, "v1 7 /users/7")
+	checkBody(t, svc, "/users/7", http.StatusOK, "", headers{Name: "X-Chaos", Value: "latency"})
+	checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.", chaos)
+
+	if value := testutil.ToFloat64(counter); value != before+1 {
+		t.Errorf("Expected one abort to be counted but the count went from %f to %f.", before, value)
+	}
+}
+
+func TestFaultInjectionLatencyMatchedByClient(t *testing.T) {
+	latency := 50 * time.Millisecond
+	cfg := faultConfig("development", true, Fault{Percentage: 100, Clients: []string{"10.0.0.0/8"}, Latency: latency})
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if rr := sendRequestFrom(svc, "10.1.2.3:1234", testEndpoint); rr.Code != http.StatusOK {
+		t.Errorf("Expected a delayed request to be served but got %d.", rr.Code)
+	}
+
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("Expected the request to be delayed by %s but it took %s.", latency, elapsed)
+	}
+}
+
+func TestFaultInjectionAdminToggle(t *testing.T) {
+	cfg := faultConfig("staging", false, Fault{Percentage: 100, AbortStatus: http.StatusInternalServerError})
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.")
+
+	rr := putFaultInjection(svc, `{"enabled": true}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"enabled":true`) {
+		t.Errorf("Expected fault injection to be turned on but got %d %q.", rr.Code, rr.Body.String())
+	}
+
+	checkBody(t, svc, testEndpoint, http.StatusInternalServerError, "")
+	checkBody(t, svc, DefaultFaultInjectionPath, http.StatusOK,
+		`{"enabled":true,"environment":"staging","faults":1}`)
+
+	if rr := putFaultInjection(svc, `{"enabled": false}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected fault injection to be turned off but got %d.", rr.Code)
+	}
+
+	checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.")
+}
+
+func TestFaultInjectionRefusedInProduction(t *testing.T) {
+	for _, environment := range []string{"", EnvironmentProduction, "Prod", "prod-eu", "qa"} {
+		cfg := faultConfig(environment, false, Fault{Percentage: 100, AbortStatus: http.StatusInternalServerError})
+
+		svc, err := NewService(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := putFaultInjection(svc, `{"enabled": true}`)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected turning fault injection on in %q to be forbidden but got %d.", environment, rr.Code)
+		}
+
+		checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.")
+
+		cfg.FaultInjection.Enabled = true
+		if err := cfg.Validate(); !errors.Is(err, errFaultsInProduction) {
+			t.Errorf("Expected %q in %q but got %v.", errFaultsInProduction, environment, err)
+		}
+	}
+}
+
+func TestFaultInjectionAllowedEnvironments(t *testing.T) {
+	for environment, allowed := range map[string]bool{"qa": true, "Staging": false, EnvironmentProduction: false} {
+		cfg := faultConfig(environment, false, Fault{Percentage: 100, AbortStatus: http.StatusInternalServerError})
+		cfg.FaultInjection.AllowedEnvironments = []string{"qa", EnvironmentProduction}
+
+		svc, err := NewService(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr := putFaultInjection(svc, `{"enabled": true}`); (rr.Code == http.StatusOK) != allowed {
+			t.Errorf("Expected turning fault injection on in %q to be allowed %t but got %d.", environment, allowed,
+				rr.Code)
+		}
+	}
+}
+
+func TestFaultInjectionEndpointOptIn(t *testing.T) {
+	cfg := faultConfig("test", false, Fault{Percentage: 100, AbortStatus: http.StatusInternalServerError})
+	cfg.FaultInjection.Endpoint = false
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := putFaultInjection(svc, `{"enabled": true}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the admin endpoint not to be served but got %d.", rr.Code)
+	}
+
+	checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.")
+}
+
+func TestFaultInjectionTruncate(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			listener := testListener(t)
+			cfg := Config{
+				Listener:    listener,
+				DisableLog:  true,
+				Router:      router,
+				Environment: "test",
+				Handlers: []Handler{{Method: http.MethodGet, Path: testEndpoint, HTTPHandler: http.HandlerFunc(
+					func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte(body)) })}},
+				FaultInjection: &FaultInjectionConfig{
+					Enabled: true,
+					Faults:  []Fault{{Percentage: 100, TruncateAfter: 10}},
+				},
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := runService(t, svc)
+
+			resp, err := http.Get("http://" + listener.Addr().String() + testEndpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			received, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if err == nil || string(received) != body[:10] {
+				t.Errorf("Expected the response to be cut after 10 bytes but got %q and %v.", received, err)
+			}
+
+			svc.Stop()
+			if err := <-result; err != nil {
+				t.Errorf("Unexpected error %s.", err)
+			}
+		})
+	}
+}
+
+func TestValidateFaultInjection(t *testing.T) {
+	cfg := faultConfig("test", false,
+		Fault{Percentage: 0, Latency: time.Second},
+		Fault{Percentage: 50, Route: "users", Clients: []string{"not-an-ip"}, AbortStatus: 200},
+		Fault{Percentage: 50, AbortStatus: http.StatusBadGateway, TruncateAfter: 10},
+		Fault{Percentage: 50})
+	cfg.FaultInjection.Group = ""
+
+	err := cfg.Validate()
+	for field, target := range map[string]error{
+		"FaultInjection.Group":                 errAdminEndpointGroup,
+		"FaultInjection.Faults[0].Percentage":  errInvalidPercentage,
+		"FaultInjection.Faults[1].Route":       errInvalidPath,
+		"FaultInjection.Faults[1].Clients":     errInvalidIPFilter,
+		"FaultInjection.Faults[1].AbortStatus": errInvalidAbortStatus,
+		"FaultInjection.Faults[2]":             errAbortAndTruncate,
+		"FaultInjection.Faults[3]":             errNoFault,
+	} {
+		if !errors.Is(err, target) || !strings.Contains(err.Error(), field+": ") {
+			t.Errorf("Expected %s to be %q but got %v.", field, target, err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcListenerName  = "grpc"
+	grpcContentType   = "application/grpc"
+	grpcHealthService = "/grpc.health.v1.Health/"
+)
+
+var errNoGRPCServices = errors.New("gRPC config has no service registrations")
+
+// GRPCConfig specifies gRPC services served alongside the HTTP handlers. They are served on the HTTP listener, with
+// requests told apart by their content type, unless a separate listen address or listener is given. Either way the
+// TLS config is shared with the HTTP handlers and the gRPC health service is registered.
+type GRPCConfig struct {
+	Register           []func(s grpc.ServiceRegistrar) // The registrations e.g. pb.RegisterGreeterServer(s, greeter).
+	ListenAddress      string                          // Optional - a separate address to serve on e.g. :9090.
+	Listener           net.Listener                    // Optional - a separate listener to serve on.
+	Auth               GRPCAuthFunc                    // Optional - authenticates every call apart from health checks.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor   // Optional - run in order after logging, metrics and auth.
+	StreamInterceptors []grpc.StreamServerInterceptor  // Optional - run in order after logging, metrics and auth.
+	ServerOptions      []grpc.ServerOption             // Optional - further options e.g. keepalive parameters.
+}
+
+// GRPCAuthFunc authenticates a call, returning the context for the rest of the call. An error without a gRPC status
+// is returned to the client as Unauthenticated.
+type GRPCAuthFunc func(ctx context.Context, fullMethod string) (context.Context, error)
+
+// separate reports whether the gRPC services have their own listener.
+func (g *GRPCConfig) separate() bool {
+	return g.ListenAddress != "" || g.Listener != nil
+}
+
+// newGRPCServer creates the server with the logging, metrics and auth interceptors and the health service.
+func newGRPCServer(cfg *Config, accessLogger *logrus.Logger) (*grpc.Server, *health.Server, error) {
+	config := cfg.GRPC
+	if len(config.Register) == 0 {
+		return nil, nil, errNoGRPCServices
+	}
+
+	unary := []grpc.UnaryServerInterceptor{grpcUnaryLogger(accessLogger), grpcUnaryMetrics}
+	stream := []grpc.StreamServerInterceptor{grpcStreamLogger(accessLogger), grpcStreamMetrics}
+	if config.Auth != nil {
+		unary = append(unary, grpcUnaryAuth(config.Auth))
+		stream = append(stream, grpcStreamAuth(config.Auth))
+	}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append(unary, config.UnaryInterceptors...)...),
+		grpc.ChainStreamInterceptor(append(stream, config.StreamInterceptors...)...),
+	}
+
+	// On the HTTP listener the HTTP server terminates TLS.
+	if cfg.CertConfig != nil && config.separate() {
+		tlsConfig, err := serverTLSConfig(cfg.CertConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(append(options, config.ServerOptions...)...)
+	for _, register := range config.Register {
+		register(server)
+	}
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	for name := range server.GetServiceInfo() {
+		healthServer.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	return server, healthServer, nil
+}
+
+// grpcHandler sends HTTP/2 requests with a gRPC content type to the gRPC server and everything else to the handler.
+func grpcHandler(server *grpc.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get(headerContentType), grpcContentType) {
+			server.ServeHTTP(w, r)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// grpcProtocols returns the protocols of a server multiplexing gRPC without TLS, which needs unencrypted HTTP/2.
+func grpcProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return protocols
+}
+
+// listenGRPC returns the separate gRPC listener, preferring one handed over by an upgrade.
+func (s *Service) listenGRPC() (net.Listener, error) {
+	listener, inherited, err := s.inheritedListener(grpcListenerName)
+	if inherited {
+		return listener, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.GRPC.Listener != nil {
+		return s.config.GRPC.Listener, nil
+	}
+
+	listener, err = net.Listen("tcp", s.config.GRPC.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return listener, nil
+}
+
+// serveGRPC starts serving the separate gRPC listener.
+func (s *Service) serveGRPC(listener net.Listener) {
+	s.grpcListener = listener
+
+	go func() {
+		if err := s.grpcServer.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			logrus.Fatalf("Failed to start gRPC listener: %s\n", err)
+		}
+	}()
+}
+
+// stopGRPC waits for the calls on a separate listener to finish, cutting them off if the context expires first. Calls
+// on the HTTP listener have already been drained by the HTTP server, which cannot drain them gracefully itself.
+func (s *Service) stopGRPC(ctx context.Context) error {
+	if !s.config.GRPC.separate() {
+		s.grpcServer.Stop()
+
+		return nil
+	}
+
+	if waitWithContext(ctx, s.grpcServer.GracefulStop) {
+		return nil
+	}
+
+	s.grpcServer.Stop()
+
+	return fmt.Errorf("gRPC: %w", ctx.Err())
+}
+
+func grpcUnaryLogger(logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logGRPCCall(logger, info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+func grpcStreamLogger(logger *logrus.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logGRPCCall(logger, info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+// logGRPCCall logs a call to the access logger, at warn level if it failed. Nothing is logged if logging is disabled.
+func logGRPCCall(logger *logrus.Logger, method string, start time.Time, err error) {
+	if logger == nil {
+		return
+	}
+
+	code := status.Code(err)
+	entry := logger.WithFields(logrus.Fields{
+		"method":  method,
+		"code":    code.String(),
+		"latency": time.Since(start).Milliseconds(),
+	})
+
+	if code != codes.OK {
+		entry.Warnf("gRPC %s %s: %s", method, code, status.Convert(err).Message())
+
+		return
+	}
+
+	entry.Infof("gRPC %s %s", method, code)
+}
+
+func grpcUnaryMetrics(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	recordGRPCCall(info.FullMethod, start, err)
+
+	return resp, err
+}
+
+func grpcStreamMetrics(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	recordGRPCCall(info.FullMethod, start, err)
+
+	return err
+}
+
+func recordGRPCCall(method string, start time.Time, err error) {
+	grpcCalls.WithLabelValues(method, status.Code(err).String()).Inc()
+	grpcCallDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func grpcUnaryAuth(auth GRPCAuthFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticateGRPC(ctx, auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func grpcStreamAuth(auth GRPCAuthFunc) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateGRPC(ss.Context(), auth, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticateGRPC runs the auth function for everything but the health service.
+func authenticateGRPC(ctx context.Context, auth GRPCAuthFunc, method string) (context.Context, error) {
+	if strings.HasPrefix(method, grpcHealthService) {
+		return ctx, nil
+	}
+
+	authenticated, err := auth(ctx, method)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err //nolint:wrapcheck // the status is returned to the client as is
+		}
+
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return authenticated, nil
+}
+
+// authenticatedStream carries the context returned by the auth function.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context //nolint:containedctx // the stream's context must be replaced
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
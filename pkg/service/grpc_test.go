@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testgrpc "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var errNoToken = errors.New("no token")
+
+type testService struct {
+	testgrpc.UnimplementedTestServiceServer
+}
+
+func (testService) EmptyCall(context.Context, *testgrpc.Empty) (*testgrpc.Empty, error) {
+	return &testgrpc.Empty{}, nil
+}
+
+// tokenAuth accepts calls with the metadata token: secret.
+func tokenAuth(ctx context.Context, _ string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if tokens := md.Get("token"); len(tokens) == 0 || tokens[0] != "secret" {
+		return nil, errNoToken
+	}
+
+	return ctx, nil
+}
+
+func grpcTestConfig(listener net.Listener) *GRPCConfig {
+	return &GRPCConfig{
+		Register: []func(s grpc.ServiceRegistrar){func(s grpc.ServiceRegistrar) {
+			testgrpc.RegisterTestServiceServer(s, testService{})
+		}},
+		Listener: listener,
+		Auth:     tokenAuth,
+	}
+}
+
+// checkGRPC checks the health service and that calls are authenticated.
+func checkGRPC(t *testing.T, conn *grpc.ClientConn) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: testgrpc.TestService_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected the service to be serving but got %s.", health.GetStatus())
+	}
+
+	client := testgrpc.NewTestServiceClient(conn)
+	if _, err := client.EmptyCall(ctx, &testgrpc.Empty{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected an unauthenticated call to fail but got %v.", err)
+	}
+
+	before := grpcCallCount(t, codes.OK)
+	authenticated := metadata.AppendToOutgoingContext(ctx, "token", "secret")
+	if _, err := client.EmptyCall(authenticated, &testgrpc.Empty{}); err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+
+	if delta := grpcCallCount(t, codes.OK) - before; delta != 1 {
+		t.Errorf("Expected 1 successful call to be counted but got %f.", delta)
+	}
+}
+
+func grpcCallCount(t *testing.T, code codes.Code) float64 {
+	t.Helper()
+
+	metric, err := grpcCalls.GetMetricWithLabelValues("/grpc.testing.TestService/EmptyCall", code.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testutil.ToFloat64(metric)
+}
+
+func TestGRPCSeparateListener(t *testing.T) {
+	grpcListener := testListener(t)
+	cfg := Config{
+		Listener:   testListener(t),
+		DisableLog: true,
+		Handlers:   []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		GRPC:       grpcTestConfig(grpcListener),
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	conn, err := grpc.NewClient(grpcListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	checkGRPC(t, conn)
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+
+	if conn, err := net.Dial("tcp", grpcListener.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("Expected the gRPC listener to be closed.")
+	}
+}
+
+func TestGRPCMultiplexed(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	for name, certConfig := range map[string]*ServerCertificateConfig{
+		"h2c": nil,
+		"tls": {Certificate: cert},
+	} {
+		t.Run(name, func(t *testing.T) {
+			listener := testListener(t)
+			cfg := Config{
+				Listener:   listener,
+				DisableLog: true,
+				Handlers:   []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+				CertConfig: certConfig,
+				GRPC:       grpcTestConfig(nil),
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := runService(t, svc)
+
+			creds := insecure.NewCredentials()
+			scheme := "http"
+			client := http.DefaultClient
+			if certConfig != nil {
+				tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+				creds = credentials.NewTLS(tlsConfig)
+				scheme = "https"
+				client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			}
+
+			conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			checkGRPC(t, conn)
+
+			resp, err := client.Get(scheme + "://" + listener.Addr().String() + testEndpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected HTTP requests to be served too but got %d.", resp.StatusCode)
+			}
+
+			svc.Stop()
+			if err := <-result; err != nil {
+				t.Errorf("Unexpected error %s.", err)
+			}
+		})
+	}
+}
+
+func TestGRPCWithoutServicesErrors(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		GRPC:          &GRPCConfig{},
+	}
+
+	if _, err := NewService(&cfg); err == nil {
+		t.Error("A gRPC config without services should cause error.")
+	}
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses which were replayed from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL     = 24 * time.Hour
+	defaultIdempotencyMaxBody = 1 << 20
+	maxIdempotencyKeyLen      = 255
+)
+
+var errIdempotencyKeyInFlight = errors.New("a request with this idempotency key is in progress")
+
+// IdempotencyConfig specifies how requests with an Idempotency-Key header are handled. The first response for a key
+// is stored and replayed for any repeat of the request. Server errors are not stored, and the key is released if the
+// handler panics, so that the request can be retried. Only the headers set by the handler are stored.
+type IdempotencyConfig struct {
+	Groups      []string                    // Optional - which group(s) this runs on. Empty means the default route.
+	Store       IdempotencyStore            // Optional - where responses are stored. Default is an in memory store.
+	TTL         time.Duration               // Optional - how long responses are stored for. Default is 24 hours.
+	Methods     []string                    // Optional - the methods the key is honoured on. Default is POST and PATCH.
+	Required    bool                        // If true, requests without the header are rejected with a 400.
+	Principal   func(c *gin.Context) string // Optional - identifies the caller so keys are not shared between callers.
+	MaxBodySize int64                       // Optional - the largest body of a keyed request, else a 413. Default 1MB.
+}
+
+// IdempotencyRecord is the state stored for an idempotency key.
+type IdempotencyRecord struct {
+	Fingerprint string      // A hash of the method, path, query and body to detect a key reused for another request.
+	InFlight    bool        // True until the first request for the key completes.
+	Status      int         // The response status.
+	Header      http.Header // The response headers.
+	Body        []byte      // The response body.
+	Expires     time.Time   // When the record can be discarded.
+}
+
+// IdempotencyStore stores the responses for idempotency keys. Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Begin reserves the key with an in flight record. If an unexpired record already exists it is returned instead
+	// and the boolean is false.
+	Begin(key string, record *IdempotencyRecord) (*IdempotencyRecord, bool, error)
+	// Complete replaces the in flight record for the key with the completed one.
+	Complete(key string, record *IdempotencyRecord) error
+	// Release removes the record for the key so that the request can be retried.
+	Release(key string) error
+}
+
+// MemoryIdempotencyStore is an in memory IdempotencyStore. Records are lost when the service restarts and are not
+// shared between instances of a service.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*IdempotencyRecord
+	lastPrune time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty in memory IdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]*IdempotencyRecord), lastPrune: time.Now()}
+}
+
+// Begin implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Begin(key string, record *IdempotencyRecord) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	if existing, found := s.records[key]; found && now.Before(existing.Expires) {
+		return existing, false, nil
+	}
+
+	s.records[key] = record
+
+	return nil, true, nil
+}
+
+// Complete implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Complete(key string, record *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = record
+
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// prune removes expired records at most once a minute. It must be called with the lock held.
+func (s *MemoryIdempotencyStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+
+	for key, record := range s.records {
+		if !now.Before(record.Expires) {
+			delete(s.records, key)
+		}
+	}
+	s.lastPrune = now
+}
+
+// idempotencyHandler returns the middleware storing and replaying responses for idempotency keys.
+func idempotencyHandler(config *IdempotencyConfig) gin.HandlerFunc {
+	store := config.Store
+	if store == nil {
+		store = NewMemoryIdempotencyStore()
+	}
+
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost, http.MethodPatch}
+	}
+
+	maxBodySize := config.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultIdempotencyMaxBody
+	}
+
+	return func(c *gin.Context) {
+		if !containsString(methods, c.Request.Method) {
+			return
+		}
+
+		idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+		if idempotencyKey == "" {
+			if config.Required {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": IdempotencyKeyHeader + " header is required"})
+			}
+
+			return
+		}
+
+		if len(idempotencyKey) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": IdempotencyKeyHeader + " header is too long"})
+
+			return
+		}
+
+		fingerprint, err := requestFingerprint(c, maxBodySize)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to read request body"})
+			}
+
+			return
+		}
+
+		var principal string
+		if config.Principal != nil {
+			principal = config.Principal(c)
+		}
+		key := fmt.Sprintf("%s|%s %s|%s", principal, c.Request.Method, c.FullPath(), idempotencyKey)
+
+		existing, reserved, err := store.Begin(key, &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			InFlight:    true,
+			Expires:     time.Now().Add(ttl),
+		})
+		if err != nil {
+			logrus.Errorf("Unable to reserve idempotency key: %s", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+
+			return
+		}
+
+		if !reserved {
+			replayIdempotentResponse(c, existing, fingerprint)
+
+			return
+		}
+
+		// If the handler panics the key is released rather than left in flight until it expires.
+		completed := false
+		defer func() {
+			if !completed {
+				if err := store.Release(key); err != nil {
+					logrus.Errorf("Unable to release idempotency key: %s", err)
+				}
+			}
+		}()
+
+		outer := c.Writer.Header().Clone()
+		writer := &teeWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		completed = true
+		completeIdempotentRequest(store, key, &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      writer.Status(),
+			Header:      handlerHeader(outer, writer.Header()),
+			Body:        writer.buf.Bytes(),
+			Expires:     time.Now().Add(ttl),
+		})
+	}
+}
+
+// completeIdempotentRequest stores the response, or releases the key if the request failed with a server error or the
+// response could not be stored.
+func completeIdempotentRequest(store IdempotencyStore, key string, record *IdempotencyRecord) {
+	if record.Status >= http.StatusInternalServerError {
+		if err := store.Release(key); err != nil {
+			logrus.Errorf("Unable to release idempotency key: %s", err)
+		}
+
+		return
+	}
+
+	if err := store.Complete(key, record); err != nil {
+		logrus.Errorf("Unable to store idempotent response: %s", err)
+
+		// The key is released rather than left in flight so that retries are not rejected until it expires.
+		if err := store.Release(key); err != nil {
+			logrus.Errorf("Unable to release idempotency key: %s", err)
+		}
+	}
+}
+
+// replayIdempotentResponse writes the stored response, or rejects the request if it is still in flight or the payload
+// does not match the original request.
+func replayIdempotentResponse(c *gin.Context, record *IdempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity,
+			gin.H{"error": IdempotencyKeyHeader + " has already been used for a different request"})
+
+		return
+	}
+
+	if record.InFlight {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": errIdempotencyKeyInFlight.Error()})
+
+		return
+	}
+
+	header := c.Writer.Header()
+	for name, values := range record.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set(IdempotentReplayedHeader, "true")
+
+	c.Writer.WriteHeader(record.Status)
+	c.Writer.WriteHeaderNow()
+	if _, err := c.Writer.Write(record.Body); err != nil {
+		logrus.Debugf("Unable to write replayed response: %s", err)
+	}
+	c.Abort()
+}
+
+// requestFingerprint hashes the request method, path, query and body, leaving the body in place for the handler.
+// Bodies over the maximum size are not read.
+func requestFingerprint(c *gin.Context, maxBodySize int64) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\n"))
+
+	if c.Request.Body != nil {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// teeWriter keeps a copy of the body written through it.
+type teeWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer.
+func (w *teeWriter) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	n, err := w.ResponseWriter.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// WriteString implements io.StringWriter.
+func (w *teeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func setupIdempotency(config *IdempotencyConfig, engine *gin.Engine) {
+	if config != nil {
+		// A single handler is shared between groups so that they share the default store.
+		handler := idempotencyHandler(config)
+		if len(config.Groups) == 0 {
+			useOnDefaultRoute(engine, handler)
+		} else {
+			for _, groupLabel := range config.Groups {
+				group := getRouterGroup(engine, groupLabel)
+				group.Use(handler)
+			}
+		}
+	}
+}
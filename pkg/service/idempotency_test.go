@@ -0,0 +1,357 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createHandler returns a handler which responds with the number of times it has been called.
+func createHandler(calls *int32) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		count := atomic.AddInt32(calls, 1)
+		c.Header("X-Call", fmt.Sprint(count))
+		c.String(http.StatusCreated, "created %d", count)
+	}
+}
+
+func sendIdempotentRequest(svc *Service, method string, key string, body string,
+	extra ...headers,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, testEndpoint, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	for _, header := range extra {
+		req.Header.Set(header.Name, header.Value)
+	}
+	rr := httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	var calls int32
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: createHandler(&calls), Path: testEndpoint}},
+		Idempotency:   &IdempotencyConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := sendIdempotentRequest(svc, http.MethodPost, "key-1", "payload")
+	replay := sendIdempotentRequest(svc, http.MethodPost, "key-1", "payload")
+
+	if first.Code != http.StatusCreated || replay.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status codes %d and %d.", first.Code, replay.Code)
+	}
+
+	if replay.Body.String() != "created 1" || replay.Header().Get("X-Call") != "1" {
+		t.Errorf("Expected the first response to be replayed but got <%s>.", replay.Body.String())
+	}
+
+	if replay.Header().Get(IdempotentReplayedHeader) != "true" || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Error("Only the replayed response should be marked as replayed.")
+	}
+
+	other := sendIdempotentRequest(svc, http.MethodPost, "key-2", "payload")
+	unkeyed := sendIdempotentRequest(svc, http.MethodPost, "", "payload")
+	if other.Body.String() != "created 2" || unkeyed.Body.String() != "created 3" {
+		t.Errorf("Unexpected responses <%s> and <%s>.", other.Body.String(), unkeyed.Body.String())
+	}
+}
+
+func TestIdempotencyPayloadMismatch(t *testing.T) {
+	var calls int32
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: createHandler(&calls), Path: testEndpoint}},
+		Idempotency:   &IdempotencyConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendIdempotentRequest(svc, http.MethodPost, "key", "payload")
+	rr := sendIdempotentRequest(svc, http.MethodPost, "key", "different payload")
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d but got %d.", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected the handler to be called once but was called %d times.", calls)
+	}
+}
+
+func TestIdempotencyInFlightConflict(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{
+			Method: http.MethodPatch, Handler: handler, Path: testEndpoint, Idempotency: &IdempotencyConfig{},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- sendIdempotentRequest(svc, http.MethodPatch, "key", "payload")
+	}()
+	<-started
+
+	rr := sendIdempotentRequest(svc, http.MethodPatch, "key", "payload")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %d but got %d.", http.StatusConflict, rr.Code)
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusOK {
+		t.Errorf("Expected status %d but got %d.", http.StatusOK, first.Code)
+	}
+}
+
+func TestIdempotencyServerErrorsAreNotStored(t *testing.T) {
+	var calls int32
+	handler := func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			c.Status(http.StatusServiceUnavailable)
+
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: handler, Path: testEndpoint}},
+		Idempotency:   &IdempotencyConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "key", "payload"); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d but got %d.", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "key", "payload"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the retry to reach the handler but got %d.", rr.Code)
+	}
+}
+
+func TestIdempotencyPrincipalAndRequired(t *testing.T) {
+	var calls int32
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodPost, Handler: createHandler(&calls), Path: testEndpoint},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint},
+		},
+		Idempotency: &IdempotencyConfig{
+			Required: true,
+			Principal: func(c *gin.Context) string {
+				return c.GetHeader("X-User")
+			},
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := sendIdempotentRequest(svc, http.MethodPost, "key", "payload", headers{Name: "X-User", Value: "alice"})
+	bob := sendIdempotentRequest(svc, http.MethodPost, "key", "payload", headers{Name: "X-User", Value: "bob"})
+	if alice.Body.String() != "created 1" || bob.Body.String() != "created 2" {
+		t.Errorf("Keys should not be shared between principals: <%s> <%s>.", alice.Body.String(), bob.Body.String())
+	}
+
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "", "payload"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d but got %d.", http.StatusBadRequest, rr.Code)
+	}
+
+	if rr := sendIdempotentRequest(svc, http.MethodGet, "", ""); rr.Code != http.StatusOK {
+		t.Errorf("Safe methods should not require a key but got %d.", rr.Code)
+	}
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	record := &IdempotencyRecord{Fingerprint: "a", InFlight: true, Expires: time.Now().Add(-time.Second)}
+
+	if _, reserved, err := store.Begin("key", record); err != nil || !reserved {
+		t.Fatalf("Expected the key to be reserved: %v", err)
+	}
+
+	if _, reserved, err := store.Begin("key", record); err != nil || !reserved {
+		t.Error("Expected an expired record to be replaced.")
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	var calls int32
+	handler := func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("handler failed")
+		}
+		c.String(http.StatusOK, "ok")
+	}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: handler, Path: testEndpoint}},
+		Idempotency:   &IdempotencyConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the handler to panic.")
+			}
+		}()
+		sendIdempotentRequest(svc, http.MethodPost, "key", "payload")
+	}()
+
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "key", "payload"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the retry to reach the handler but got %d.", rr.Code)
+	}
+}
+
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	var calls int32
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: createHandler(&calls), Path: testEndpoint}},
+		Idempotency:   &IdempotencyConfig{MaxBodySize: 4},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "key", "payload"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d but got %d.", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "key", "body"); rr.Code != http.StatusCreated {
+		t.Errorf("Expected a body within the limit to be accepted but got %d.", rr.Code)
+	}
+}
+
+func TestIdempotencyReplaysOnlyHandlerHeaders(t *testing.T) {
+	var calls, requests int32
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: createHandler(&calls), Path: testEndpoint}},
+		MiddlewareHandlers: []MiddlewareHandler{{Handler: func(c *gin.Context) {
+			c.Header("X-Request", fmt.Sprint(atomic.AddInt32(&requests, 1)))
+		}}},
+		Idempotency: &IdempotencyConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendIdempotentRequest(svc, http.MethodPost, "key", "payload")
+	replay := sendIdempotentRequest(svc, http.MethodPost, "key", "payload")
+
+	if replay.Header().Get("X-Call") != "1" || replay.Header().Get("X-Request") != "2" {
+		t.Errorf("Expected the handler's headers and the current request's middleware headers but got %v.",
+			replay.Header())
+	}
+}
+
+func TestIdempotencyKeyReusedOnAnotherPath(t *testing.T) {
+	var calls int32
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: createHandler(&calls), Path: "/users/:id"}},
+		Idempotency:   &IdempotencyConfig{},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, url := range []string{"/users/1", "/users/2", "/users/1?notify=true"} {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader("payload"))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		rr := httptest.NewRecorder()
+		svc.Handler.ServeHTTP(rr, req)
+
+		expected := http.StatusUnprocessableEntity
+		if i == 0 {
+			expected = http.StatusCreated
+		}
+
+		if rr.Code != expected {
+			t.Errorf("Expected %s to respond %d but got %d.", url, expected, rr.Code)
+		}
+	}
+}
+
+// failingCompleteStore is a memory store which fails to complete records.
+type failingCompleteStore struct {
+	*MemoryIdempotencyStore
+}
+
+func (s failingCompleteStore) Complete(string, *IdempotencyRecord) error {
+	return errors.New("store unavailable")
+}
+
+func TestIdempotencyReleasesKeyWhenCompleteFails(t *testing.T) {
+	var calls int32
+	store := failingCompleteStore{NewMemoryIdempotencyStore()}
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodPost, Handler: createHandler(&calls), Path: testEndpoint}},
+		Idempotency:   &IdempotencyConfig{Store: store},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendIdempotentRequest(svc, http.MethodPost, "key", "payload")
+	if rr := sendIdempotentRequest(svc, http.MethodPost, "key", "payload"); rr.Code != http.StatusCreated {
+		t.Errorf("Expected the retry to reach the handler but got %d.", rr.Code)
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errInvalidTrustedProxy = errors.New("invalid trusted proxy")
+	errInvalidIPFilter     = errors.New("invalid IP filter entry")
+)
+
+// IPFilterConfig specifies which client IPs may reach a group or handler. Clients which are rejected get a 403.
+type IPFilterConfig struct {
+	Groups []string // Optional - which group(s) the filter should run on. Empty means the default route.
+	Allow  []string // Optional - CIDRs or IPs allowed. If set, any client not matching is rejected.
+	Deny   []string // Optional - CIDRs or IPs rejected. Deny takes precedence over allow.
+}
+
+// ipFilter holds the parsed allow and deny lists.
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// parsePrefixes parses CIDRs or plain IPs, a plain IP becoming a single address prefix.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s: %w", errInvalidIPFilter, entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %w", errInvalidIPFilter, entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func newIPFilter(config *IPFilterConfig) (*ipFilter, error) {
+	allow, err := parsePrefixes(config.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := parsePrefixes(config.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipFilter{allow: allow, deny: deny}, nil
+}
+
+// allowed reports whether the client IP passes the filter. Unparseable IPs are rejected unless both lists are empty.
+func (f *ipFilter) allowed(clientIP string) bool {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+	addr = addr.Unmap()
+
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipFilterHandler rejects clients which do not pass the filter. The client IP is resolved taking the trusted
+// proxies into account.
+func ipFilterHandler(config *IPFilterConfig) (gin.HandlerFunc, error) {
+	filter, err := newIPFilter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		clientIP := c.ClientIP()
+		if !filter.allowed(clientIP) {
+			logrus.Debugf("Rejecting request from %s to %s.", clientIP, c.Request.URL.Path)
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	}, nil
+}
+
+// setupTrustedProxies configures which proxies' headers are trusted when resolving the client IP. If none are given
+// then no proxy is trusted and the client IP is always the address of the connecting peer.
+func setupTrustedProxies(trustedProxies []string, remoteIPHeaders []string, engine *gin.Engine) error {
+	if err := engine.SetTrustedProxies(trustedProxies); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidTrustedProxy, err)
+	}
+
+	if len(remoteIPHeaders) > 0 {
+		engine.RemoteIPHeaders = remoteIPHeaders
+	}
+
+	return nil
+}
+
+func setupIPFilters(configs []IPFilterConfig, engine *gin.Engine) error {
+	for i := range configs {
+		config := &configs[i]
+		handler, err := ipFilterHandler(config)
+		if err != nil {
+			return err
+		}
+
+		if len(config.Groups) == 0 {
+			useOnDefaultRoute(engine, handler)
+		} else {
+			for _, groupLabel := range config.Groups {
+				group := getRouterGroup(engine, groupLabel)
+				group.Use(handler)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPHandler returns the client IP resolved for the request.
+func clientIPHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	}
+}
+
+func sendRequestFrom(svc *Service, remoteAddr string, url string, reqHeaders ...headers) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.RemoteAddr = remoteAddr
+	for _, header := range reqHeaders {
+		req.Header.Set(header.Name, header.Value)
+	}
+
+	svc.Handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestClientIPNotSpoofableByDefault(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: clientIPHandler(), Path: testEndpoint}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := sendRequestFrom(svc, "203.0.113.7:1234", testEndpoint, headers{Name: "X-Forwarded-For", Value: "10.0.0.1"})
+	if rr.Body.String() != "203.0.113.7" {
+		t.Errorf("Expected the peer address but got <%s>.", rr.Body.String())
+	}
+}
+
+func TestClientIPFromTrustedProxy(t *testing.T) {
+	cfg := Config{
+		ListenAddress:   ":8888",
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: clientIPHandler(), Path: testEndpoint}},
+		TrustedProxies:  []string{"10.0.0.0/8"},
+		RemoteIPHeaders: []string{"X-Real-IP"},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := sendRequestFrom(svc, "10.1.2.3:1234", testEndpoint, headers{Name: "X-Real-IP", Value: "198.51.100.4"})
+	if rr.Body.String() != "198.51.100.4" {
+		t.Errorf("Expected the forwarded address but got <%s>.", rr.Body.String())
+	}
+
+	rr = sendRequestFrom(svc, "203.0.113.7:1234", testEndpoint, headers{Name: "X-Real-IP", Value: "198.51.100.4"})
+	if rr.Body.String() != "203.0.113.7" {
+		t.Errorf("Expected the peer address but got <%s>.", rr.Body.String())
+	}
+}
+
+func TestInvalidTrustedProxyErrors(t *testing.T) {
+	cfg := Config{
+		ListenAddress:  ":8888",
+		Handlers:       []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		TrustedProxies: []string{"not-an-ip"},
+	}
+
+	if _, err := NewService(&cfg); err == nil {
+		t.Error("An invalid trusted proxy should cause error.")
+	}
+}
+
+func TestIPFilterOnGroup(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/admin", Group: "TestIPFilterOnGroup"},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint},
+		},
+		IPFilters: []IPFilterConfig{{
+			Groups: []string{"TestIPFilterOnGroup"},
+			Allow:  []string{"192.168.0.0/16", "::1"},
+			Deny:   []string{"192.168.1.1"},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		path       string
+		code       int
+	}{
+		{remoteAddr: "192.168.5.5:1234", path: "/admin", code: http.StatusOK},
+		{remoteAddr: "[::1]:1234", path: "/admin", code: http.StatusOK},
+		{remoteAddr: "192.168.1.1:1234", path: "/admin", code: http.StatusForbidden},
+		{remoteAddr: "203.0.113.7:1234", path: "/admin", code: http.StatusForbidden},
+		{remoteAddr: "203.0.113.7:1234", path: testEndpoint, code: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		rr := sendRequestFrom(svc, test.remoteAddr, test.path)
+		if rr.Code != test.code {
+			t.Errorf("Expected status %d for %s from %s but got %d.", test.code, test.path, test.remoteAddr, rr.Code)
+		}
+	}
+}
+
+func TestIPFilterOnDefaultRouteReachesGroups(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/api", Group: "api"},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/limited", Group: "limited"},
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint},
+		},
+		// Both create their groups before the IP filters are set up.
+		Compression:       &CompressionConfig{Groups: []string{"api"}},
+		ConcurrencyLimits: []ConcurrencyLimitConfig{{Groups: []string{"limited"}, MaxInFlight: 10}},
+		IPFilters:         []IPFilterConfig{{Deny: []string{"203.0.113.0/24"}}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/api", "/limited", testEndpoint} {
+		if rr := sendRequestFrom(svc, "203.0.113.7:1234", path); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected a denied client to be rejected on %s but got %d.", path, rr.Code)
+		}
+
+		if rr := sendRequestFrom(svc, "192.0.2.1:1234", path); rr.Code != http.StatusOK {
+			t.Errorf("Expected an allowed client to be served on %s but got %d.", path, rr.Code)
+		}
+	}
+}
+
+func TestIPFilterOnHandler(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{{
+			Method:   http.MethodGet,
+			Handler:  helloWorldHandler(),
+			Path:     testEndpoint,
+			IPFilter: &IPFilterConfig{Deny: []string{"203.0.113.0/24"}},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := sendRequestFrom(svc, "203.0.113.7:1234", testEndpoint); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d but got %d.", http.StatusForbidden, rr.Code)
+	}
+
+	if rr := sendRequestFrom(svc, "198.51.100.4:1234", testEndpoint); rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d but got %d.", http.StatusOK, rr.Code)
+	}
+}
+
+func TestInvalidIPFilterErrors(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		IPFilters:     []IPFilterConfig{{Allow: []string{"10.0.0.0/33"}}},
+	}
+
+	if _, err := NewService(&cfg); err == nil {
+		t.Error("An invalid IP filter should cause error.")
+	}
+}
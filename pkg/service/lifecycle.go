@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHookTimeout     = 30 * time.Second
+	defaultShutdownTimeout = 5 * time.Second
+	defaultStopTimeout     = 5 * time.Second
+)
+
+var (
+	errHookTimedOut   = errors.New("timed out")
+	errWorkersStopped = errors.New("timed out waiting for workers to stop")
+	errDispatchStop   = errors.New("timed out waiting for dispatcher to stop")
+)
+
+// Hook is a function run when the service starts or stops.
+type Hook struct {
+	Name    string                          // Identifies the hook in logs and errors, and an OnStop hook's OnStart hook.
+	Fn      func(ctx context.Context) error // The function to run. The context is cancelled when the timeout expires.
+	Timeout time.Duration                   // Optional - how long the hook may run for. Default is 30 seconds.
+}
+
+// Worker is a long running background function managed by the service.
+type Worker struct {
+	Name string                          // Used to identify the worker in logs and errors.
+	Fn   func(ctx context.Context) error // The function to run. It should return once the context is cancelled.
+}
+
+// lifecycle holds the state of the managed workers and of stopping the service.
+type lifecycle struct {
+	stopWorkers  context.CancelFunc
+	workers      sync.WaitGroup
+	workerErrsMu sync.Mutex
+	workerErrs   []error
+	stopOnce     sync.Once
+	stopping     chan struct{}
+}
+
+// runHook runs a hook, giving up once its timeout expires even if the function does not honour its context.
+func runHook(hook Hook) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- hook.Fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("hook %s: %w", hook.Name, err)
+		}
+
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("hook %s: %w after %s", hook.Name, errHookTimedOut, timeout)
+	}
+}
+
+// start runs the start hooks in order, stopping at the first failure, and then starts the dispatchers and workers.
+// If a start hook fails the stop hooks named after the start hooks which succeeded are run, in reverse order.
+func (s *Service) start() error {
+	for i, hook := range s.config.OnStart {
+		logrus.Debugf("Running start hook %s.", hook.Name)
+		if err := runHook(hook); err != nil {
+			return errors.Join(fmt.Errorf("start %w", err), s.unwind(s.config.OnStart[:i]))
+		}
+	}
+
+	for _, dispatcher := range s.config.Dispatchers {
+		dispatcher.Start()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopWorkers = cancel
+	workers := s.config.Workers
+	if watcher := s.maintenance.worker(); watcher != nil {
+		workers = append(append([]Worker{}, workers...), *watcher)
+	}
+
+	for _, worker := range workers {
+		s.workers.Add(1)
+		go s.runWorker(ctx, worker)
+	}
+
+	return nil
+}
+
+// unwind runs the stop hooks with the names of the start hooks which succeeded, in reverse order, returning their
+// failures.
+func (s *Service) unwind(started []Hook) error {
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		for _, hook := range s.config.OnStop {
+			if hook.Name != started[i].Name {
+				continue
+			}
+
+			logrus.Debugf("Running stop hook %s to undo its start hook.", hook.Name)
+			if err := runHook(hook); err != nil {
+				logrus.Errorf("Stop %s", err)
+				errs = append(errs, fmt.Errorf("stop %w", err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runWorker runs a worker, recording any error which was not caused by the service stopping.
+func (s *Service) runWorker(ctx context.Context, worker Worker) {
+	defer s.workers.Done()
+
+	logrus.Debugf("Starting worker %s.", worker.Name)
+	err := worker.Fn(ctx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logrus.Errorf("Worker %s failed: %s", worker.Name, err)
+		s.workerErrsMu.Lock()
+		s.workerErrs = append(s.workerErrs, fmt.Errorf("worker %s: %w", worker.Name, err))
+		s.workerErrsMu.Unlock()
+	}
+}
+
+// stop stops the workers and the dispatchers and then runs the stop hooks in order. All failures are returned.
+func (s *Service) stop(ctx context.Context) []error {
+	var errs []error
+
+	if s.stopWorkers != nil {
+		s.stopWorkers()
+		if !waitWithContext(ctx, s.workers.Wait) {
+			errs = append(errs, errWorkersStopped)
+		}
+	}
+
+	s.workerErrsMu.Lock()
+	errs = append(errs, s.workerErrs...)
+	s.workerErrsMu.Unlock()
+
+	for _, dispatcher := range s.config.Dispatchers {
+		if !waitWithContext(ctx, dispatcher.Stop) {
+			errs = append(errs, errDispatchStop)
+		}
+	}
+
+	for _, hook := range s.config.OnStop {
+		logrus.Debugf("Running stop hook %s.", hook.Name)
+		if err := runHook(hook); err != nil {
+			logrus.Errorf("Stop %s", err)
+			errs = append(errs, fmt.Errorf("stop %w", err))
+		}
+	}
+
+	return errs
+}
+
+// waitWithContext calls fn and waits for it to return or the context to be done. It returns false if the context
+// was done first.
+func waitWithContext(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Stop asks a running service to shut down as if it had been interrupted.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/go-libs/pkg/concurrency"
+)
+
+var errHookFailed = errors.New("hook failed")
+
+// recorder records the order in which lifecycle functions are called.
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recorder) hook(name string, err error) Hook {
+	return Hook{Name: name, Fn: func(_ context.Context) error {
+		r.record(name)
+
+		return err
+	}}
+}
+
+func lifecycleConfig() Config {
+	return Config{
+		ListenAddress: "127.0.0.1:0",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+	}
+}
+
+func runService(t *testing.T, svc *Service) <-chan error {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- svc.Run()
+	}()
+
+	return result
+}
+
+func TestLifecycleHooksAndWorkers(t *testing.T) {
+	rec := &recorder{}
+	workerStarted := make(chan struct{})
+
+	cfg := lifecycleConfig()
+	cfg.OnStart = []Hook{rec.hook("start1", nil), rec.hook("start2", nil)}
+	cfg.OnStop = []Hook{rec.hook("stop1", nil), rec.hook("stop2", nil)}
+	cfg.Workers = []Worker{{Name: "worker", Fn: func(ctx context.Context) error {
+		close(workerStarted)
+		<-ctx.Done()
+		rec.record("worker")
+
+		return ctx.Err()
+	}}}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runService(t, svc)
+
+	select {
+	case <-workerStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Worker was not started.")
+	}
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"start1", "start2", "worker", "stop1", "stop2"}
+	if len(rec.calls) != len(expected) {
+		t.Fatalf("Unexpected calls %v, expected %v.", rec.calls, expected)
+	}
+
+	for i := range expected {
+		if rec.calls[i] != expected[i] {
+			t.Errorf("Unexpected calls %v, expected %v.", rec.calls, expected)
+		}
+	}
+}
+
+func TestLifecycleStartHookFailureAbortsStartup(t *testing.T) {
+	rec := &recorder{}
+
+	cfg := lifecycleConfig()
+	cfg.OnStart = []Hook{rec.hook("start1", errHookFailed), rec.hook("start2", nil)}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Run(); !errors.Is(err, errHookFailed) {
+		t.Errorf("Expected %s but got %v.", errHookFailed, err)
+	}
+
+	if len(rec.calls) != 1 {
+		t.Errorf("Start should stop at the first failure, calls were %v.", rec.calls)
+	}
+}
+
+func TestLifecycleStartHookFailureUnwindsStartedHooks(t *testing.T) {
+	rec := &recorder{}
+	stop := func(name string) Hook {
+		return Hook{Name: name, Fn: func(_ context.Context) error {
+			rec.record("stop " + name)
+
+			return nil
+		}}
+	}
+
+	cfg := lifecycleConfig()
+	cfg.OnStart = []Hook{rec.hook("db", nil), rec.hook("cache", nil), rec.hook("queue", errHookFailed)}
+	cfg.OnStop = []Hook{stop("db"), stop("queue"), stop("other"), stop("cache")}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Run(); !errors.Is(err, errHookFailed) {
+		t.Errorf("Expected %s but got %v.", errHookFailed, err)
+	}
+
+	expected := []string{"db", "cache", "queue", "stop cache", "stop db"}
+	if !slices.Equal(rec.calls, expected) {
+		t.Errorf("Unexpected calls %v, expected %v.", rec.calls, expected)
+	}
+}
+
+func TestLifecycleStopHasItsOwnTimeout(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+
+	listener := testListener(t)
+	cfg := Config{
+		Listener:        listener,
+		DisableLog:      true,
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: blockingHandler(started, release), Path: "/slow"}},
+		ShutdownTimeout: 50 * time.Millisecond,
+		StopTimeout:     5 * time.Second,
+		Workers: []Worker{{Name: "slow to stop", Fn: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(200 * time.Millisecond)
+
+			return ctx.Err()
+		}}},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	go func() {
+		if resp, err := http.Get("http://" + listener.Addr().String() + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// The drain uses up the whole ShutdownTimeout as the request never completes.
+	svc.Stop()
+	err = <-result
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the drain to time out but got %v.", err)
+	}
+
+	if errors.Is(err, errWorkersStopped) {
+		t.Errorf("Expected the worker to be given the StopTimeout but got %v.", err)
+	}
+}
+
+func TestLifecycleHookTimeout(t *testing.T) {
+	hook := Hook{Name: "slow", Timeout: 10 * time.Millisecond, Fn: func(_ context.Context) error {
+		time.Sleep(time.Second)
+
+		return nil
+	}}
+
+	if err := runHook(hook); !errors.Is(err, errHookTimedOut) {
+		t.Errorf("Expected %s but got %v.", errHookTimedOut, err)
+	}
+}
+
+func TestLifecycleFailuresReportedOnShutdown(t *testing.T) {
+	rec := &recorder{}
+	workerErr := errors.New("worker failed")
+	workerDone := make(chan struct{})
+
+	cfg := lifecycleConfig()
+	cfg.OnStop = []Hook{rec.hook("stop1", errHookFailed), rec.hook("stop2", nil)}
+	cfg.Workers = []Worker{{Name: "failing", Fn: func(_ context.Context) error {
+		defer close(workerDone)
+
+		return workerErr
+	}}}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runService(t, svc)
+	<-workerDone
+	svc.Stop()
+
+	err = <-result
+	if !errors.Is(err, errHookFailed) || !errors.Is(err, workerErr) {
+		t.Errorf("Expected the stop hook and worker failures but got %v.", err)
+	}
+
+	if len(rec.calls) != 2 {
+		t.Errorf("All stop hooks should run, calls were %v.", rec.calls)
+	}
+}
+
+func TestLifecycleDispatchers(t *testing.T) {
+	dispatcher := concurrency.NewDispatcher("lifecycle", 1, 1)
+
+	cfg := lifecycleConfig()
+	cfg.Dispatchers = []concurrency.Dispatcher{dispatcher}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runService(t, svc)
+
+	processed := make(chan struct{})
+	if err := dispatcher.SubmitWork(func() error {
+		close(processed)
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The dispatcher was not started.")
+	}
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	if dispatcher.ProcessedJobs() != 1 {
+		t.Errorf("Expected 1 processed job but got %d.", dispatcher.ProcessedJobs())
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// UnixAddressPrefix is the ListenAddress prefix used to listen on a unix domain socket e.g. unix:///run/svc.sock.
+	UnixAddressPrefix = "unix://"
+
+	defaultUnixSocketMode  = 0o660
+	staleSocketDialTimeout = time.Second
+)
+
+var (
+	errInvalidUnixSocketPath    = errors.New("invalid unix socket path")
+	errUnixSocketInUse          = errors.New("unix socket is already in use")
+	errUnixSocketPathNotSocket  = errors.New("unix socket path exists and is not a socket")
+	errNoSocketActivation       = errors.New("no listeners passed in by socket activation")
+	errSocketActivationNotFound = errors.New("no socket activation listener with the requested name")
+)
+
+// SocketActivationConfig specifies how to pick up listeners passed in by systemd socket activation.
+type SocketActivationConfig struct {
+	Name string // Optional - the FileDescriptorName of the socket to use. Empty means the first one.
+}
+
+// listen returns the listener the service should serve on. A listener handed over by an upgrade takes precedence over
+// an injected listener, which takes precedence over socket activation and then the listen address.
+func (s *Service) listen() (net.Listener, error) {
+	listener, inherited, err := s.inheritedListener(httpListenerName)
+	if inherited {
+		return listener, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.Listener != nil {
+		return s.config.Listener, nil
+	}
+
+	if s.config.SocketActivation != nil {
+		return socketActivationListener(s.config.SocketActivation.Name)
+	}
+
+	if socketPath, ok := strings.CutPrefix(s.config.ListenAddress, UnixAddressPrefix); ok {
+		return listenUnix(socketPath, s.config.UnixSocketMode)
+	}
+
+	listener, err = net.Listen("tcp", s.config.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return listener, nil
+}
+
+// listenUnix listens on a unix domain socket, removing a stale socket left behind by a previous process. The socket
+// file is removed when the listener is closed.
+func listenUnix(socketPath string, mode os.FileMode) (net.Listener, error) {
+	if socketPath == "" {
+		return nil, errInvalidUnixSocketPath
+	}
+
+	if err := removeStaleSocket(socketPath); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if mode == 0 {
+		mode = defaultUnixSocketMode
+	}
+
+	if err := os.Chmod(socketPath, mode); err != nil {
+		closeErr := listener.Close()
+		if closeErr != nil {
+			logrus.Warnf("Unable to close unix socket %s: %s", socketPath, closeErr)
+		}
+
+		return nil, fmt.Errorf("unable to set permissions on unix socket %s: %w", socketPath, err)
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket file nothing is listening on. Anything other than a socket is left alone.
+func removeStaleSocket(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%w: %s", errUnixSocketPathNotSocket, socketPath)
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, staleSocketDialTimeout)
+	if err == nil {
+		closeErr := conn.Close()
+		if closeErr != nil {
+			logrus.Warnf("Unable to close connection to unix socket %s: %s", socketPath, closeErr)
+		}
+
+		return fmt.Errorf("%w: %s", errUnixSocketInUse, socketPath)
+	}
+
+	logrus.Infof("Removing stale unix socket %s.", socketPath)
+	if err := os.Remove(socketPath); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// socketActivationListener returns the named listener passed in by systemd through LISTEN_FDS. The others are closed
+// as nothing else serves on them.
+func socketActivationListener(name string) (net.Listener, error) {
+	listeners, err := socketActivationListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := selectListener(listeners, name)
+	closeUnselectedListeners(listeners, listener)
+
+	return listener, err
+}
+
+// closeUnselectedListeners closes every listener other than the selected one, which may be nil to close them all.
+func closeUnselectedListeners(listeners []namedListener, selected net.Listener) {
+	for _, listener := range listeners {
+		if listener.Listener == selected {
+			continue
+		}
+
+		if err := listener.Close(); err != nil {
+			logrus.Warnf("Unable to close the unused listener %s: %s", listener.name, err)
+		}
+	}
+}
+
+// selectListener returns the listener with the given name or the first listener if no name is given.
+func selectListener(listeners []namedListener, name string) (net.Listener, error) {
+	if len(listeners) == 0 {
+		return nil, errNoSocketActivation
+	}
+
+	if name == "" {
+		return listeners[0].Listener, nil
+	}
+
+	for _, listener := range listeners {
+		if listener.name == name {
+			return listener.Listener, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", errSocketActivationNotFound, name)
+}
+
+// namedListener is a listener passed in by another process along with its name.
+type namedListener struct {
+	net.Listener
+	name string
+}
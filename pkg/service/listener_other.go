@@ -0,0 +1,8 @@
+//go:build !unix
+
+package service
+
+// socketActivationListeners always errors as socket activation is only available on unix.
+func socketActivationListeners() ([]namedListener, error) {
+	return nil, errNoSocketActivation
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func unixClient(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "service.sock")
+	cfg := Config{
+		ListenAddress: UnixAddressPrefix + socketPath,
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := svc.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != defaultUnixSocketMode {
+		t.Errorf("Expected socket permissions %o but got %o.", defaultUnixSocketMode, info.Mode().Perm())
+	}
+
+	go func() {
+		if err := svc.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			t.Error(err)
+		}
+	}()
+
+	resp, err := unixClient(socketPath).Get("http://unix" + testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "Hello World." {
+		t.Errorf("handler returned unexpected body: got <%v> want <Hello World.>", string(body))
+	}
+
+	if err := svc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(socketPath); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Socket file should be removed when the service closes.")
+	}
+}
+
+func TestUnixSocketStaleAndInUse(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "service.sock")
+
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	if err := stale.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := listenUnix(socketPath, 0o600)
+	if err != nil {
+		t.Fatalf("A stale socket should be replaced: %s", err)
+	}
+	defer listener.Close()
+
+	if _, err := listenUnix(socketPath, 0o600); !errors.Is(err, errUnixSocketInUse) {
+		t.Errorf("Expected %s but got %v.", errUnixSocketInUse, err)
+	}
+}
+
+func TestUnixSocketPathNotSocket(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(filePath, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listenUnix(filePath, 0); !errors.Is(err, errUnixSocketPathNotSocket) {
+		t.Errorf("Expected %s but got %v.", errUnixSocketPathNotSocket, err)
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		t.Error("A file which is not a socket should never be removed.")
+	}
+}
+
+func TestInjectedListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cfg := Config{
+		Listener: listener,
+		Handlers: []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := svc.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual != listener {
+		t.Error("The injected listener should be used.")
+	}
+}
+
+func TestSocketActivationWithoutListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	if _, err := socketActivationListener(""); !errors.Is(err, errNoSocketActivation) {
+		t.Errorf("Expected %s but got %v.", errNoSocketActivation, err)
+	}
+}
+
+func TestCloseUnselectedListeners(t *testing.T) {
+	selected, unselected := testListener(t), testListener(t)
+	defer selected.Close()
+	listeners := []namedListener{{Listener: unselected, name: "http"}, {Listener: selected, name: "https"}}
+
+	closeUnselectedListeners(listeners, selected)
+
+	if _, err := unselected.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected the unselected listener to be closed but got %v.", err)
+	}
+
+	conn, err := net.Dial("tcp", selected.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected the selected listener to stay open but got %s.", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	systemdListenFDsStart = 3
+	systemdListenPID      = "LISTEN_PID"
+	systemdListenFDs      = "LISTEN_FDS"
+	systemdListenFDNames  = "LISTEN_FDNAMES"
+)
+
+// socketActivationListeners returns the listeners passed in by systemd in file descriptor order. The environment
+// variables are cleared so that they are not inherited by child processes.
+func socketActivationListeners() ([]namedListener, error) {
+	pid, err := strconv.Atoi(os.Getenv(systemdListenPID))
+	if err != nil || pid != os.Getpid() {
+		return nil, errNoSocketActivation
+	}
+
+	count, err := strconv.Atoi(os.Getenv(systemdListenFDs))
+	if err != nil || count < 1 {
+		return nil, errNoSocketActivation
+	}
+
+	var names []string
+	if fdNames := os.Getenv(systemdListenFDNames); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	for _, env := range []string{systemdListenPID, systemdListenFDs, systemdListenFDNames} {
+		if err := os.Unsetenv(env); err != nil {
+			logrus.Warnf("Unable to unset %s: %s", env, err)
+		}
+	}
+
+	return inheritedListeners(systemdListenFDsStart, count, names)
+}
+
+// inheritedListeners converts count file descriptors starting at startFD into listeners. Listeners without a name are
+// named after their file descriptor.
+func inheritedListeners(startFD int, count int, names []string) ([]namedListener, error) {
+	listeners := make([]namedListener, 0, count)
+	for i := range count {
+		fd := startFD + i
+		syscall.CloseOnExec(fd)
+
+		name := strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		// FileListener duplicates the file descriptor so the original is no longer needed.
+		closeErr := file.Close()
+		if closeErr != nil {
+			logrus.Warnf("Unable to close inherited file descriptor %d: %s", fd, closeErr)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("file descriptor %d is not a listener: %w", fd, err)
+		}
+
+		listeners = append(listeners, namedListener{Listener: listener, name: name})
+	}
+
+	return listeners, nil
+}
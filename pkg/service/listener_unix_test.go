@@ -0,0 +1,51 @@
+//go:build unix
+
+package service
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	file, err := tcpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hand over a duplicate as inheritedListeners takes ownership of the file descriptor.
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := inheritedListeners(fd, 1, []string{"http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].Close()
+
+	listener, err := selectListener(listeners, "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if listener.Addr().String() != tcpListener.Addr().String() {
+		t.Errorf("Expected address %s but got %s.", tcpListener.Addr(), listener.Addr())
+	}
+
+	if _, err := selectListener(listeners, "https"); !errors.Is(err, errSocketActivationNotFound) {
+		t.Errorf("Expected %s but got %v.", errSocketActivationNotFound, err)
+	}
+}
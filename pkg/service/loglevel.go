@@ -0,0 +1,227 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultLogLevelPath is the default path of the log level admin endpoint.
+const DefaultLogLevelPath = "/admin/loglevel"
+
+var errInvalidLogLevelTTL = errors.New("invalid ttl")
+
+// LogLevelConfig enables changing the log level of a running service. The admin endpoint returns the level on a GET
+// and sets it on a PUT, optionally reverting to the configured level after a TTL. The signals toggle between the
+// configured level and debug.
+type LogLevelConfig struct {
+	Endpoint bool        // If true, the admin endpoint is served.
+	Path     string      // Optional - the path of the admin endpoint. Default is /admin/loglevel.
+	Group    string      // The group of the admin endpoint, required with Endpoint e.g. with auth middleware.
+	Signals  []os.Signal // Optional - the signals which toggle debug logging. Default is SIGUSR1 and SIGHUP on unix.
+}
+
+// LogLevel is the body of the log level admin endpoint.
+type LogLevel struct {
+	Level      string     `json:"level"`                // The current level.
+	Configured string     `json:"configured,omitempty"` // The level reverted to. Ignored on a PUT.
+	TTL        string     `json:"ttl,omitempty"`        // Optional on a PUT - revert after this duration e.g. 10m.
+	RevertAt   *time.Time `json:"revert_at,omitempty"`  // When the level will be reverted. Ignored on a PUT.
+}
+
+// logLevels sets the level of both the standard logger and the access logger, remembering their configured levels so
+// that they can be reverted.
+type logLevels struct {
+	mu               sync.Mutex
+	accessLogger     *logrus.Logger
+	configured       logrus.Level
+	accessConfigured logrus.Level
+	overridden       bool
+	revertTimer      *time.Timer
+	revertAt         time.Time
+	generation       uint64 // Incremented whenever a pending revert is cancelled.
+}
+
+func newLogLevels(accessLogger *logrus.Logger) *logLevels {
+	levels := &logLevels{accessLogger: accessLogger, configured: logrus.GetLevel()}
+	if accessLogger != nil {
+		levels.accessConfigured = accessLogger.GetLevel()
+	}
+
+	return levels
+}
+
+// capture records the current levels as the configured ones. It is called once the service has set its log level.
+func (l *logLevels) capture() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.configured = logrus.GetLevel()
+	if l.accessLogger != nil {
+		l.accessConfigured = l.accessLogger.GetLevel()
+	}
+}
+
+// set changes the level of both loggers, reverting to the configured levels after the TTL if it is set.
+func (l *logLevels) set(level logrus.Level, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopRevert()
+	logrus.SetLevel(level)
+	if l.accessLogger != nil {
+		l.accessLogger.SetLevel(level)
+	}
+	l.overridden = true
+
+	if ttl > 0 {
+		generation := l.generation
+		l.revertAt = time.Now().Add(ttl)
+		l.revertTimer = time.AfterFunc(ttl, func() {
+			l.revert(generation)
+		})
+	}
+
+	logrus.Infof("Log level set to %s.", level)
+}
+
+// revert restores the configured levels unless the revert of this generation has since been cancelled. A timer which
+// has already fired cannot be stopped, so it may be waiting on the lock while the level is set again.
+func (l *logLevels) revert(generation uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if generation == l.generation {
+		l.revertLocked()
+	}
+}
+
+func (l *logLevels) revertLocked() {
+	l.stopRevert()
+	logrus.SetLevel(l.configured)
+	if l.accessLogger != nil {
+		l.accessLogger.SetLevel(l.accessConfigured)
+	}
+	l.overridden = false
+
+	logrus.Infof("Log level reverted to %s.", l.configured)
+}
+
+// toggle switches between the configured levels and debug.
+func (l *logLevels) toggle() {
+	l.mu.Lock()
+	overridden := l.overridden
+	if overridden {
+		l.revertLocked()
+	}
+	l.mu.Unlock()
+
+	if !overridden {
+		l.set(logrus.DebugLevel, 0)
+	}
+}
+
+// stopRevert cancels a pending revert. It must be called with the lock held.
+func (l *logLevels) stopRevert() {
+	if l.revertTimer != nil {
+		l.revertTimer.Stop()
+		l.revertTimer = nil
+	}
+	l.revertAt = time.Time{}
+	l.generation++
+}
+
+func (l *logLevels) current() LogLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := LogLevel{Level: logrus.GetLevel().String(), Configured: l.configured.String()}
+	if !l.revertAt.IsZero() {
+		revertAt := l.revertAt
+		current.RevertAt = &revertAt
+		current.TTL = time.Until(revertAt).Round(time.Second).String()
+	}
+
+	return current
+}
+
+// handler returns the admin endpoint handler.
+func (l *logLevels) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet {
+			c.JSON(http.StatusOK, l.current())
+
+			return
+		}
+
+		var request LogLevel
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		level, err := logrus.ParseLevel(strings.TrimSpace(request.Level))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		var ttl time.Duration
+		if request.TTL != "" {
+			ttl, err = time.ParseDuration(request.TTL)
+			if err != nil || ttl < 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %s", errInvalidLogLevelTTL,
+					request.TTL)})
+
+				return
+			}
+		}
+
+		l.set(level, ttl)
+		c.JSON(http.StatusOK, l.current())
+	}
+}
+
+// logLevelSignals returns the signals which toggle debug logging or nil if log level control is not enabled.
+func (s *Service) logLevelSignals() []os.Signal {
+	if s.config.LogLevelControl == nil {
+		return nil
+	}
+
+	if len(s.config.LogLevelControl.Signals) > 0 {
+		return s.config.LogLevelControl.Signals
+	}
+
+	return defaultLogLevelSignals()
+}
+
+func setupLogLevelControl(config *LogLevelConfig, levels *logLevels, engine *gin.Engine) {
+	if config == nil || !config.Endpoint {
+		return
+	}
+
+	path := config.Path
+	if path == "" {
+		path = DefaultLogLevelPath
+	}
+
+	group := getRouterGroup(engine, config.Group)
+	handleRoute(group, http.MethodGet, path, levels.handler())
+	handleRoute(group, http.MethodPut, path, levels.handler())
+}
+
+// validateLogLevelControl checks that the admin endpoint is protected by a group.
+func (c *Config) validateLogLevelControl(v *validation) {
+	if c.LogLevelControl != nil && c.LogLevelControl.Endpoint && c.LogLevelControl.Group == "" {
+		v.add("LogLevelControl.Group", errAdminEndpointGroup)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package service
+
+import "os"
+
+// defaultLogLevelSignals returns no signals as SIGUSR1 is only available on unix.
+func defaultLogLevelSignals() []os.Signal {
+	return nil
+}
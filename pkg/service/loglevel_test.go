@@ -0,0 +1,157 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// restoreLogLevel puts the standard logger back to its level when the test finishes.
+func restoreLogLevel(t *testing.T) {
+	t.Helper()
+
+	level := logrus.GetLevel()
+	t.Cleanup(func() {
+		logrus.SetLevel(level)
+	})
+}
+
+func putLogLevel(svc *Service, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, DefaultLogLevelPath, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	restoreLogLevel(t)
+	logrus.SetLevel(logrus.InfoLevel)
+
+	cfg := Config{
+		ListenAddress:   ":8888",
+		LogLevel:        "info",
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		LogLevelControl: &LogLevelConfig{Endpoint: true, Group: "admin"},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, DefaultLogLevelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var level LogLevel
+	if err := json.Unmarshal(rr.Body.Bytes(), &level); err != nil {
+		t.Fatal(err)
+	}
+
+	if level.Level != "info" || level.Configured != "info" || level.RevertAt != nil {
+		t.Errorf("Unexpected level %+v.", level)
+	}
+
+	rr = putLogLevel(svc, `{"level": "trace", "ttl": "50ms"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but got %d <%s>.", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &level); err != nil {
+		t.Fatal(err)
+	}
+
+	if level.Level != "trace" || level.RevertAt == nil {
+		t.Errorf("Unexpected level %+v.", level)
+	}
+
+	if logrus.GetLevel() != logrus.TraceLevel || svc.logLevels.accessLogger.GetLevel() != logrus.TraceLevel {
+		t.Error("Expected both loggers to be at trace.")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logrus.GetLevel() != logrus.InfoLevel && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if logrus.GetLevel() != logrus.InfoLevel || svc.logLevels.accessLogger.GetLevel() != logrus.InfoLevel {
+		t.Error("Expected both loggers to revert to info.")
+	}
+
+	for _, body := range []string{`{"level": "loud"}`, `{"level": "debug", "ttl": "soon"}`, `not json`} {
+		if rr := putLogLevel(svc, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s but got %d.", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+}
+
+func TestLogLevelToggle(t *testing.T) {
+	restoreLogLevel(t)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	accessLogger := logrus.New()
+	accessLogger.SetLevel(logrus.ErrorLevel)
+	levels := newLogLevels(accessLogger)
+
+	levels.toggle()
+	if logrus.GetLevel() != logrus.DebugLevel || accessLogger.GetLevel() != logrus.DebugLevel {
+		t.Error("Expected the first toggle to switch to debug.")
+	}
+
+	levels.toggle()
+	if logrus.GetLevel() != logrus.WarnLevel || accessLogger.GetLevel() != logrus.ErrorLevel {
+		t.Error("Expected the second toggle to revert to the configured levels.")
+	}
+}
+
+func TestLogLevelStaleRevertIsIgnored(t *testing.T) {
+	restoreLogLevel(t)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	levels := newLogLevels(nil)
+	levels.set(logrus.TraceLevel, time.Hour)
+	stale := levels.generation
+
+	// A timer which fired just before the level was set again runs once it gets the lock.
+	levels.set(logrus.DebugLevel, 0)
+	levels.revert(stale)
+
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Errorf("Expected the stale revert to be ignored but the level is %s.", logrus.GetLevel())
+	}
+}
+
+func TestLogLevelEndpointNotAddedByDefault(t *testing.T) {
+	for name, control := range map[string]*LogLevelConfig{"disabled": nil, "signals only": {}} {
+		cfg := Config{
+			ListenAddress:   ":8888",
+			Handlers:        []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+			LogLevelControl: control,
+		}
+
+		if _, err := checkResponseCode(http.MethodGet, DefaultLogLevelPath, cfg, http.StatusNotFound); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestValidateLogLevelControl(t *testing.T) {
+	cfg := Config{
+		ListenAddress:   ":8888",
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		LogLevelControl: &LogLevelConfig{Endpoint: true},
+	}
+
+	err := cfg.Validate()
+	if !errors.Is(err, errAdminEndpointGroup) || !strings.Contains(err.Error(), "LogLevelControl.Group: ") {
+		t.Errorf("Expected LogLevelControl.Group to be %q but got %v.", errAdminEndpointGroup, err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+// defaultLogLevelSignals returns the signals which toggle debug logging by default.
+func defaultLogLevelSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR1, syscall.SIGHUP}
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package service
+
+import (
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogLevelSignalToggles(t *testing.T) {
+	restoreLogLevel(t)
+
+	cfg := lifecycleConfig()
+	cfg.LogLevel = "warn"
+	// SIGWINCH is ignored by default so it is safe to send before the service is listening for it.
+	cfg.LogLevelControl = &LogLevelConfig{Signals: []os.Signal{syscall.SIGWINCH}}
+	cfg.Handlers = []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logrus.GetLevel() != logrus.DebugLevel && time.Now().Before(deadline) {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGWINCH); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Error("Expected the signal to switch to debug logging.")
+	}
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaintenancePath is the default path of the maintenance mode admin endpoint.
+	DefaultMaintenancePath = "/admin/maintenance"
+
+	contentTypeProblem           = "application/problem+json"
+	defaultMaintenancePoll       = time.Second
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+)
+
+var (
+	errMaintenanceStatus  = errors.New("maintenance responses are always a 503")
+	errAdminEndpointGroup = errors.New("the admin endpoint must be in a group protecting it e.g. with auth middleware")
+)
+
+// MaintenanceConfig enables maintenance mode, in which requests are answered with a 503 and a problem body while the
+// service keeps running. It is turned on by Enabled, by a PUT of {"enabled": true} to the admin endpoint or while the
+// File exists. The admin endpoint, the readiness and metrics endpoints and the allowed routes and clients are still
+// served. The admin endpoint is only served when Endpoint is set, and then must be in a Group protecting it.
+type MaintenanceConfig struct {
+	Enabled      bool          // If true, the service starts in maintenance mode.
+	File         string        // Optional - maintenance mode is on while this file exists.
+	PollInterval time.Duration // Optional - how often the file is checked for. Default is 1s.
+	RetryAfter   time.Duration // Optional - the Retry-After sent with the 503s. Default is 5m.
+	Problem      *Problem      // Optional - the body of the 503s. Default is a Service Unavailable problem.
+	AllowRoutes  []string      // Optional - routes still served, in gin's syntax e.g. /status or /users/:id.
+	AllowClients []string      // Optional - CIDRs or IPs of the clients still served e.g. the operators' network.
+	Unready      bool          // If true, the readiness endpoint responds 503 in maintenance mode.
+	Endpoint     bool          // If true, the admin endpoint is served.
+	Path         string        // Optional - the path of the admin endpoint. Default is /admin/maintenance.
+	Group        string        // The group of the admin endpoint, required with Endpoint e.g. with auth middleware.
+}
+
+// Problem is a problem details body as described by RFC 9457.
+type Problem struct {
+	Type     string `json:"type,omitempty"`     // A URI identifying the problem type. Default is about:blank.
+	Title    string `json:"title,omitempty"`    // A short summary of the problem type.
+	Status   int    `json:"status,omitempty"`   // The status code.
+	Detail   string `json:"detail,omitempty"`   // An explanation of this occurrence of the problem.
+	Instance string `json:"instance,omitempty"` // A URI identifying this occurrence of the problem.
+}
+
+// Maintenance is the body of the maintenance mode admin endpoint.
+type Maintenance struct {
+	Enabled bool       `json:"enabled"`         // Whether the service is in maintenance mode. Sets the toggle on a PUT.
+	Toggled bool       `json:"toggled"`         // Whether the toggle is on. Ignored on a PUT.
+	File    bool       `json:"file"`            // Whether the watched file exists. Ignored on a PUT.
+	Since   *time.Time `json:"since,omitempty"` // When maintenance mode was turned on. Ignored on a PUT.
+}
+
+// validateMaintenance checks the maintenance mode config.
+func (c *Config) validateMaintenance(v *validation) {
+	config := c.Maintenance
+	if config == nil {
+		return
+	}
+
+	if config.PollInterval < 0 {
+		v.add("Maintenance.PollInterval", errNegative)
+	}
+
+	if config.RetryAfter < 0 {
+		v.add("Maintenance.RetryAfter", errNegative)
+	}
+
+	if config.Problem != nil && config.Problem.Status != 0 && config.Problem.Status != http.StatusServiceUnavailable {
+		v.add("Maintenance.Problem.Status", fmt.Errorf("%w: %d", errMaintenanceStatus, config.Problem.Status))
+	}
+
+	for i, route := range config.AllowRoutes {
+		if len(route) == 0 || route[0] != '/' {
+			v.add(fmt.Sprintf("Maintenance.AllowRoutes[%d]", i), fmt.Errorf("%w: %q", errInvalidPath, route))
+		}
+	}
+
+	_, err := parsePrefixes(config.AllowClients)
+	v.add("Maintenance.AllowClients", err)
+
+	if config.Endpoint && config.Group == "" {
+		v.add("Maintenance.Group", errAdminEndpointGroup)
+	}
+}
+
+// maintenanceMode is on while either its toggle is on or its file exists.
+type maintenanceMode struct {
+	mu         sync.Mutex
+	toggled    bool
+	file       bool
+	since      time.Time
+	config     *MaintenanceConfig
+	adminPath  string
+	allowed    [][]string
+	clients    []netip.Prefix
+	retryAfter string
+	problem    Problem
+}
+
+// newMaintenanceMode returns the maintenance mode of the config or nil if it is not configured.
+func newMaintenanceMode(config *MaintenanceConfig) (*maintenanceMode, error) {
+	if config == nil {
+		return nil, nil //nolint:nilnil // no maintenance mode is not an error
+	}
+
+	clients, err := parsePrefixes(config.AllowClients)
+	if err != nil {
+		return nil, err
+	}
+
+	retryAfter := config.RetryAfter
+	if retryAfter == 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+
+	problem := Problem{
+		Title:  http.StatusText(http.StatusServiceUnavailable),
+		Detail: "The service is undergoing maintenance.",
+	}
+	if config.Problem != nil {
+		problem = *config.Problem
+	}
+	problem.Status = http.StatusServiceUnavailable
+
+	maintenance := &maintenanceMode{
+		config:     config,
+		adminPath:  config.Path,
+		clients:    clients,
+		retryAfter: strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+		problem:    problem,
+	}
+
+	if maintenance.adminPath == "" {
+		maintenance.adminPath = DefaultMaintenancePath
+	}
+
+	for _, route := range config.AllowRoutes {
+		maintenance.allowed = append(maintenance.allowed, pathSegments(route))
+	}
+
+	file := maintenance.fileExists()
+	maintenance.update(func() { maintenance.toggled, maintenance.file = config.Enabled, file })
+
+	return maintenance, nil
+}
+
+// update changes the toggle or whether the file exists, logging when maintenance mode is turned on or off.
+func (m *maintenanceMode) update(change func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	was := m.toggled || m.file
+	change()
+	is := m.toggled || m.file
+
+	switch {
+	case is && !was:
+		m.since = time.Now()
+		maintenanceModeGauge.Set(1)
+		logrus.Warn("Maintenance mode turned on.")
+	case was && !is:
+		m.since = time.Time{}
+		maintenanceModeGauge.Set(0)
+		logrus.Info("Maintenance mode turned off.")
+	}
+}
+
+func (m *maintenanceMode) toggle(toggled bool) {
+	m.update(func() { m.toggled = toggled })
+}
+
+func (m *maintenanceMode) current() Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := Maintenance{Enabled: m.toggled || m.file, Toggled: m.toggled, File: m.file}
+	if !m.since.IsZero() {
+		since := m.since
+		current.Since = &since
+	}
+
+	return current
+}
+
+func (m *maintenanceMode) enabled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.toggled || m.file
+}
+
+// fileExists reports whether the watched file exists, false if there is none.
+func (m *maintenanceMode) fileExists() bool {
+	if m.config.File == "" {
+		return false
+	}
+
+	_, err := os.Stat(m.config.File)
+
+	return err == nil
+}
+
+// watch checks for the file until the context is cancelled.
+func (m *maintenanceMode) watch(ctx context.Context) error {
+	interval := m.config.PollInterval
+	if interval == 0 {
+		interval = defaultMaintenancePoll
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w", ctx.Err())
+		case <-ticker.C:
+			file := m.fileExists()
+			m.update(func() { m.file = file })
+		}
+	}
+}
+
+// worker returns the worker watching the file or nil if there is no file to watch.
+func (m *maintenanceMode) worker() *Worker {
+	if m == nil || m.config.File == "" {
+		return nil
+	}
+
+	return &Worker{Name: "maintenance file watcher", Fn: m.watch}
+}
+
+// allows reports whether the request is still served in maintenance mode.
+func (m *maintenanceMode) allows(c *gin.Context) bool {
+	path := requestedURL(c.Request).Path
+	switch path {
+	case m.adminPath, ReadinessEndpoint, "/metrics":
+		return true
+	}
+
+	segments := pathSegments(path)
+	for _, route := range m.allowed {
+		if matchSegments(route, segments) {
+			return true
+		}
+	}
+
+	if len(m.clients) > 0 {
+		addr, err := netip.ParseAddr(c.ClientIP())
+		if err == nil && containsAddr(m.clients, addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// middleware answers the requests which are not allowed with a 503 while in maintenance mode.
+func (m *maintenanceMode) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.enabled() || m.allows(c) {
+			return
+		}
+
+		problem := m.problem
+		if problem.Instance == "" {
+			problem.Instance = c.Request.URL.RequestURI()
+		}
+
+		c.Header("Retry-After", m.retryAfter)
+		c.Header("Content-Type", contentTypeProblem)
+		c.Abort()
+		c.JSON(http.StatusServiceUnavailable, problem)
+	}
+}
+
+// handler returns the admin endpoint handler.
+func (m *maintenanceMode) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet {
+			c.JSON(http.StatusOK, m.current())
+
+			return
+		}
+
+		var request Maintenance
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		m.toggle(request.Enabled)
+		c.JSON(http.StatusOK, m.current())
+	}
+}
+
+func setupMaintenance(maintenance *maintenanceMode, engine *gin.Engine) {
+	if maintenance == nil || !maintenance.config.Endpoint {
+		return
+	}
+
+	group := getRouterGroup(engine, maintenance.config.Group)
+	handleRoute(group, http.MethodGet, maintenance.adminPath, maintenance.handler())
+	handleRoute(group, http.MethodPut, maintenance.adminPath, maintenance.handler())
+}
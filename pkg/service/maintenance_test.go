@@ -0,0 +1,234 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func putMaintenance(svc *Service, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, DefaultMaintenancePath, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	svc.Handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestMaintenanceAdminToggle(t *testing.T) {
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				ListenAddress:  ":8888",
+				Router:         router,
+				ReadinessCheck: true,
+				Handlers: []Handler{
+					{Method: http.MethodGet, Path: "/users/:id", HTTPHandler: userHandler()},
+					{Method: http.MethodGet, Path: testEndpoint, HTTPHandler: userHandler()},
+				},
+				Maintenance: &MaintenanceConfig{
+					RetryAfter:   90 * time.Second,
+					Problem:      &Problem{Type: "https://example.com/maintenance", Title: "Down for maintenance"},
+					AllowRoutes:  []string{"/users/:id"},
+					AllowClients: []string{"10.0.0.0/8"},
+					Endpoint:     true,
+					Group:        "admin",
+				},
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkBody(t, svc, testEndpoint, http.StatusOK, "")
+			checkBody(t, svc, ReadinessEndpoint, http.StatusOK, `{"maintenance":false,"status":"UP"}`)
+
+			if rr := putMaintenance(svc, `{"enabled": true}`); rr.Code != http.StatusOK {
+				t.Fatalf("Expected maintenance mode to be turned on but got %d %q.", rr.Code, rr.Body.String())
+			}
+
+			if value := testutil.ToFloat64(maintenanceModeGauge); value != 1 {
+				t.Errorf("Expected the maintenance mode gauge to be 1 but got %f.", value)
+			}
+
+			rr := sendRequestFrom(svc, "192.0.2.1:1234", testEndpoint+"?a=b")
+			if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "90" ||
+				rr.Header().Get("Content-Type") != contentTypeProblem {
+				t.Errorf("Unexpected maintenance response %d %v.", rr.Code, rr.Header())
+			}
+
+			var problem Problem
+			if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := Problem{Type: "https://example.com/maintenance", Title: "Down for maintenance",
+				Status: http.StatusServiceUnavailable, Instance: testEndpoint + "?a=b"}
+			if problem != expected {
+				t.Errorf("Expected the problem %+v but got %+v.", expected, problem)
+			}
+
+			if rr := sendRequestFrom(svc, "192.0.2.1:1234", "/users/7"); rr.Code != http.StatusOK {
+				t.Errorf("Expected an allowed route to be served but got %d.", rr.Code)
+			}
+
+			if rr := sendRequestFrom(svc, "10.1.2.3:1234", testEndpoint); rr.Code != http.StatusOK {
+				t.Errorf("Expected an allowed client to be served but got %d.", rr.Code)
+			}
+
+			checkBody(t, svc, ReadinessEndpoint, http.StatusOK, `{"maintenance":true,"status":"UP"}`)
+
+			if rr := putMaintenance(svc, `{"enabled": false}`); rr.Code != http.StatusOK {
+				t.Errorf("Expected maintenance mode to be turned off but got %d.", rr.Code)
+			}
+
+			checkBody(t, svc, testEndpoint, http.StatusOK, "")
+		})
+	}
+}
+
+func TestMaintenanceFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maintenance")
+	listener := testListener(t)
+	cfg := Config{
+		Listener:       listener,
+		DisableLog:     true,
+		ReadinessCheck: true,
+		Handlers:       []Handler{{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler()}},
+		Maintenance:    &MaintenanceConfig{File: file, PollInterval: 10 * time.Millisecond, Unready: true},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	baseURL := "http://" + listener.Addr().String()
+	// Without keep-alives no unused client connection is left to hold the graceful shutdown open.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	waitForStatus := func(url string, code int) {
+		t.Helper()
+
+		status := 0
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			resp, err := client.Get(baseURL + url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if status = resp.StatusCode; status == code {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Errorf("Expected %s to respond %d but got %d.", url, code, status)
+	}
+
+	waitForStatus(testEndpoint, http.StatusOK)
+
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(testEndpoint, http.StatusServiceUnavailable)
+	waitForStatus(ReadinessEndpoint, http.StatusServiceUnavailable)
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(testEndpoint, http.StatusOK)
+	waitForStatus(ReadinessEndpoint, http.StatusOK)
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+}
+
+func TestMaintenanceFileOverridesToggle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maintenance")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler()}},
+		Maintenance:   &MaintenanceConfig{File: file, Endpoint: true, Group: "admin"},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBody(t, svc, testEndpoint, http.StatusServiceUnavailable, "")
+
+	rr := putMaintenance(svc, `{"enabled": false}`)
+	var state Maintenance
+	if err := json.Unmarshal(rr.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	if !state.Enabled || state.Toggled || !state.File || state.Since == nil {
+		t.Errorf("Expected the file to keep maintenance mode on but got %+v.", state)
+	}
+}
+
+func TestMaintenanceEndpointOptIn(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler()}},
+		Maintenance:   &MaintenanceConfig{},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := putMaintenance(svc, `{"enabled": true}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the admin endpoint not to be served but got %d.", rr.Code)
+	}
+
+	checkBody(t, svc, testEndpoint, http.StatusOK, "")
+}
+
+func TestValidateMaintenance(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler()}},
+		Maintenance: &MaintenanceConfig{
+			RetryAfter:   -time.Second,
+			Problem:      &Problem{Status: http.StatusOK},
+			AllowRoutes:  []string{"status"},
+			AllowClients: []string{"not-an-ip"},
+			Endpoint:     true,
+		},
+	}
+
+	err := cfg.Validate()
+	for field, target := range map[string]error{
+		"Maintenance.Group":          errAdminEndpointGroup,
+		"Maintenance.RetryAfter":     errNegative,
+		"Maintenance.Problem.Status": errMaintenanceStatus,
+		"Maintenance.AllowRoutes[0]": errInvalidPath,
+		"Maintenance.AllowClients":   errInvalidIPFilter,
+	} {
+		if !errors.Is(err, target) || !strings.Contains(err.Error(), field+": ") {
+			t.Errorf("Expected %s to be %q but got %v.", field, target, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "service"
+
+// cacheRequests counts the requests to handlers with a response cache by whether they were served from it.
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "response_cache_requests_total",
+	Help:      "Requests to handlers with a response cache, by cache and result (hit or miss).",
+}, []string{"cache", "result"})
+
+// openStreams is the number of open SSE and WebSocket streams.
+var openStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "open_streams",
+	Help:      "The number of open streams, by type (sse or websocket).",
+}, []string{"type"})
+
+// buildInfo is always 1, the labels describe how the service was built.
+var buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "build_info",
+	Help:      "Always 1, labelled by the version, revision, build time, modified flag and Go version of the build.",
+}, []string{"version", "revision", "build_time", "modified", "go_version"})
+
+// concurrencyLimit is the current limit of each concurrency limiter.
+var concurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "concurrency_limit",
+	Help:      "The number of requests which can be handled at once, by limiter.",
+}, []string{"limiter"})
+
+// concurrencyInFlight is the number of requests being handled by each concurrency limiter.
+var concurrencyInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "concurrency_in_flight",
+	Help:      "The number of requests being handled, by limiter.",
+}, []string{"limiter"})
+
+// concurrencyQueued is the number of requests waiting for each concurrency limiter.
+var concurrencyQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "concurrency_queued",
+	Help:      "The number of requests waiting to be handled, by limiter.",
+}, []string{"limiter"})
+
+// concurrencyShed counts the requests rejected by each concurrency limiter.
+var concurrencyShed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "concurrency_shed_total",
+	Help:      "Requests rejected with a 503, by limiter and reason (queue_full, queue_timeout or canceled).",
+}, []string{"limiter", "reason"})
+
+// grpcCalls counts the gRPC calls handled.
+var grpcCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "grpc_calls_total",
+	Help:      "gRPC calls handled, by method and status code.",
+}, []string{"method", "code"})
+
+// grpcCallDuration is the time taken to handle gRPC calls.
+var grpcCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "grpc_call_duration_seconds",
+	Help:      "The time taken to handle gRPC calls, by method.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method"})
+
+// deprecatedCalls counts the calls to deprecated handlers.
+var deprecatedCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "deprecated_calls_total",
+	Help:      "Calls to deprecated handlers, by method, path, API version and whether they were rejected after sunset.",
+}, []string{"method", "path", "version", "rejected"})
+
+// injectedFaults counts the faults injected into requests.
+var injectedFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "injected_faults_total",
+	Help:      "Faults injected into requests, by fault (latency, abort or truncate).",
+}, []string{"fault"})
+
+// maintenanceModeGauge is 1 while the service is in maintenance mode.
+var maintenanceModeGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "maintenance_mode",
+	Help:      "1 while the service is in maintenance mode, otherwise 0.",
+})
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	plainListenerName     = "plain"
+	defaultHTTPSPort      = 443
+	defaultRedirectStatus = http.StatusPermanentRedirect
+)
+
+var (
+	errPlainHTTPWithoutTLS    = errors.New("a plain HTTP listener can only be added to a service with a CertConfig")
+	errPlainHTTPListenAddress = errors.New("invalid plain HTTP listen address")
+	errInvalidRedirectStatus  = errors.New("redirect status must be a 3xx status")
+)
+
+// PlainHTTPConfig adds a plain HTTP listener to a service served over HTTPS. Requests are redirected to HTTPS apart
+// from those for the paths served over plain HTTP. Both listeners are started and stopped together.
+type PlainHTTPConfig struct {
+	ListenAddress   string       // The address of the plain HTTP listener e.g. :80.
+	Listener        net.Listener // Optional - a listener to use instead of the listen address.
+	ServePaths      []string     // Optional - paths served over plain HTTP. A trailing "*" matches a prefix.
+	DisableRedirect bool         // If true, requests for any other path get a 404 instead of a redirect.
+	RedirectStatus  int          // Optional - the status of the redirect. Default is 308.
+	RedirectHost    string       // Optional - the host[:port] to redirect to. Default is the request host.
+}
+
+func validatePlainHTTP(cfg *Config) error {
+	if cfg.PlainHTTP == nil {
+		return nil
+	}
+
+	if cfg.CertConfig == nil {
+		return errPlainHTTPWithoutTLS
+	}
+
+	if cfg.PlainHTTP.ListenAddress == "" && cfg.PlainHTTP.Listener == nil {
+		return errPlainHTTPListenAddress
+	}
+
+	if status := cfg.PlainHTTP.RedirectStatus; status != 0 && (status < 300 || status > 399) {
+		return fmt.Errorf("%w: %d", errInvalidRedirectStatus, status)
+	}
+
+	return nil
+}
+
+// newPlainServer returns the server for the plain HTTP listener. It serves the allowed paths with the handler and
+// redirects everything else to the HTTPS port.
+func newPlainServer(config *PlainHTTPConfig, handler http.Handler, httpsPort func() int) *http.Server {
+	status := config.RedirectStatus
+	if status == 0 {
+		status = defaultRedirectStatus
+	}
+
+	return &http.Server{
+		ReadHeaderTimeout: readHeaderTimeout,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if matchPaths(config.ServePaths, r.URL.Path) {
+				handler.ServeHTTP(w, r)
+
+				return
+			}
+
+			if config.DisableRedirect {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			host := config.RedirectHost
+			if host == "" {
+				host = httpsHost(r.Host, httpsPort())
+			}
+
+			target := "https://" + host + r.URL.RequestURI()
+			http.Redirect(w, r, target, status)
+		}),
+	}
+}
+
+// httpsHost replaces the port of the request host with the HTTPS port, leaving it out if it is the default.
+func httpsHost(requestHost string, port int) string {
+	host, _, err := net.SplitHostPort(requestHost)
+	if err != nil {
+		host = strings.Trim(requestHost, "[]")
+	}
+
+	if port == 0 || port == defaultHTTPSPort {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+
+		return host
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// httpsPort returns the port the service is listening on, or 0 if it is not a TCP listener.
+func (s *Service) httpsPort() int {
+	if s.listener == nil {
+		return 0
+	}
+
+	if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+
+	return 0
+}
+
+// listenPlain returns the listener for the plain HTTP listener, preferring one handed over by an upgrade.
+func (s *Service) listenPlain() (net.Listener, error) {
+	listener, inherited, err := s.inheritedListener(plainListenerName)
+	if inherited {
+		return listener, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.PlainHTTP.Listener != nil {
+		return s.config.PlainHTTP.Listener, nil
+	}
+
+	listener, err = net.Listen("tcp", s.config.PlainHTTP.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return listener, nil
+}
+
+// servePlain starts serving the plain HTTP listener.
+func (s *Service) servePlain(listener net.Listener) {
+	s.plainListener = listener
+
+	go func() {
+		if err := s.plainServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logrus.Fatalf("Failed to start plain HTTP listener: %s\n", err)
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/puppetlabs/go-libs/pkg/certificate"
+)
+
+// testCertificate returns a certificate for 127.0.0.1 and a pool with the CA which signed it.
+func testCertificate(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	ca, err := certificate.GenerateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := certificate.GenerateSignedCert(ca, certificate.HostNames{"127.0.0.1"}, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.X509KeyPair(pair.Certificate, pair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.Certificate)
+
+	return &cert, pool
+}
+
+func testListener(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return listener
+}
+
+func TestPlainHTTPRedirectsAndServesPaths(t *testing.T) {
+	cert, pool := testCertificate(t)
+	httpsListener := testListener(t)
+	plainListener := testListener(t)
+
+	cfg := Config{
+		Listener:        httpsListener,
+		DisableLog:      true,
+		ReadinessCheck:  true,
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		CertConfig:      &ServerCertificateConfig{Certificate: cert},
+		PlainHTTP:       &PlainHTTPConfig{Listener: plainListener, ServePaths: []string{ReadinessEndpoint}},
+		SecurityHeaders: []SecurityHeadersConfig{{StrictTransportSecurity: "max-age=60"}},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	plainURL := "http://" + plainListener.Addr().String()
+	httpsAddr := httpsListener.Addr().String()
+
+	resp, err := client.Get(plainURL + testEndpoint + "?a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	expected := fmt.Sprintf("https://%s%s?a=b", httpsAddr, testEndpoint)
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != expected {
+		t.Errorf("Expected a redirect to %s but got %d %s.", expected, resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, err = client.Get(plainURL + ReadinessEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(headerStrictTransportSecurity) != "" {
+		t.Errorf("Expected the readiness endpoint to be served over plain HTTP without HSTS but got %d %s.",
+			resp.StatusCode, resp.Header.Get(headerStrictTransportSecurity))
+	}
+
+	resp, err = client.Get("https://" + httpsAddr + testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(headerStrictTransportSecurity) != "max-age=60" {
+		t.Errorf("Expected status %d with HSTS over HTTPS but got %d %s.", http.StatusOK, resp.StatusCode,
+			resp.Header.Get(headerStrictTransportSecurity))
+	}
+
+	// An unused client connection would hold the graceful shutdown open.
+	client.CloseIdleConnections()
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+
+	if conn, err := net.Dial("tcp", plainListener.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("Expected the plain HTTP listener to be closed.")
+	}
+}
+
+func TestPlainHTTPRedirectOptions(t *testing.T) {
+	handler := newPlainServer(&PlainHTTPConfig{
+		RedirectStatus: http.StatusMovedPermanently,
+		RedirectHost:   "secure.example.com",
+	}, http.NotFoundHandler(), func() int { return 8443 }).Handler
+
+	rr, err := sendRequest(&Service{Server: &http.Server{Handler: handler}}, http.MethodGet, "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "https://secure.example.com/path" {
+		t.Errorf("Unexpected redirect %d %s.", rr.Code, rr.Header().Get("Location"))
+	}
+
+	noPort := func() int { return 0 }
+	handler = newPlainServer(&PlainHTTPConfig{DisableRedirect: true}, http.NotFoundHandler(), noPort).Handler
+	rr, err = sendRequest(&Service{Server: &http.Server{Handler: handler}}, http.MethodGet, "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d but got %d.", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestHTTPSHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{host: "example.com", port: 443, expected: "example.com"},
+		{host: "example.com:80", port: 443, expected: "example.com"},
+		{host: "example.com:8080", port: 8443, expected: "example.com:8443"},
+		{host: "[::1]:80", port: 443, expected: "[::1]"},
+		{host: "[::1]", port: 8443, expected: "[::1]:8443"},
+		{host: "example.com", port: 0, expected: "example.com"},
+	}
+
+	for _, test := range tests {
+		if actual := httpsHost(test.host, test.port); actual != test.expected {
+			t.Errorf("Host %s port %d: got <%s> want <%s>", test.host, test.port, actual, test.expected)
+		}
+	}
+}
+
+func TestPlainHTTPValidation(t *testing.T) {
+	handlers := []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}}
+	tests := []struct {
+		cfg      Config
+		expected error
+	}{
+		{
+			cfg:      Config{ListenAddress: ":8443", Handlers: handlers, PlainHTTP: &PlainHTTPConfig{ListenAddress: ":8080"}},
+			expected: errPlainHTTPWithoutTLS,
+		},
+		{
+			cfg: Config{
+				ListenAddress: ":8443", Handlers: handlers, CertConfig: &ServerCertificateConfig{},
+				PlainHTTP: &PlainHTTPConfig{},
+			},
+			expected: errPlainHTTPListenAddress,
+		},
+		{
+			cfg: Config{
+				ListenAddress: ":8443", Handlers: handlers, CertConfig: &ServerCertificateConfig{},
+				PlainHTTP: &PlainHTTPConfig{ListenAddress: ":8080", RedirectStatus: http.StatusOK},
+			},
+			expected: errInvalidRedirectStatus,
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := NewService(&test.cfg); !errors.Is(err, test.expected) {
+			t.Errorf("Expected error %s but got %v.", test.expected, err)
+		}
+	}
+}
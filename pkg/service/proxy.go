@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/puppetlabs/go-libs/pkg/certificate"
+	"github.com/puppetlabs/go-libs/pkg/url"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultProxyScheme           = "http"
+	defaultProxyTLSScheme        = "https"
+	defaultProxyTimeout          = 30 * time.Second
+	defaultProxyDialTimeout      = 5 * time.Second
+	defaultProxyFailureThreshold = 3
+	defaultProxyUnhealthyFor     = 10 * time.Second
+)
+
+var (
+	errNoUpstreams         = errors.New("proxy has no upstreams")
+	errInvalidUpstream     = errors.New("invalid upstream")
+	errInvalidProxyRootCA  = errors.New("unable to parse the proxy root CA")
+	errInvalidProxyKeyPair = errors.New("unable to load the proxy client certificate")
+)
+
+// idempotentMethods are the methods whose requests can safely be sent to another upstream after a failure.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// ProxyConfig specifies how a handler forwards requests to upstream services.
+type ProxyConfig struct {
+	Upstreams            []string                 // The upstreams e.g. https://api.internal:8443 or api.internal:8080.
+	DefaultScheme        string                   // Optional - the scheme of upstreams without one. Default http(s).
+	StripPrefix          string                   // Optional - segments removed from the start of the path e.g. /api.
+	AddPrefix            string                   // Optional - added to the start of the path once stripped e.g. /v2.
+	Rewrite              func(path string) string // Optional - rewrites the escaped path once the prefixes are handled.
+	SetHeaders           map[string]string        // Optional - headers set on the upstream request.
+	StripHeaders         []string                 // Optional - headers removed from the upstream request.
+	StripResponseHeaders []string                 // Optional - headers removed from the upstream response.
+	ClientCert           *certificate.KeyPair     // Optional - the client certificate for mutual TLS.
+	RootCA               *certificate.KeyPair     // Optional - the CA upstream certificates are verified against.
+	Timeout              time.Duration            // Optional - the time allowed for a response. Default 30s.
+	DialTimeout          time.Duration            // Optional - the time allowed to connect to an upstream. Default 5s.
+	Retries              int                      // Optional - the further upstreams an idempotent request is tried on.
+	FailureThreshold     int                      // Optional - failures in a row making it unhealthy. Default 3.
+	UnhealthyFor         time.Duration            // Optional - how long an unhealthy upstream is skipped. Default 10s.
+}
+
+// upstream is a normalised upstream and its passive health.
+type upstream struct {
+	url            *neturl.URL
+	mu             sync.Mutex
+	failures       int
+	unhealthyUntil time.Time
+}
+
+// healthy reports whether the upstream can be picked.
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return !now.Before(u.unhealthyUntil)
+}
+
+// record marks the upstream unhealthy after the threshold of failures in a row.
+func (u *upstream) record(failed bool, threshold int, unhealthyFor time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !failed {
+		u.failures = 0
+
+		return
+	}
+
+	u.failures++
+	if u.failures >= threshold {
+		logrus.Warnf("Marking upstream %s unhealthy for %s after %d failures.", u.url, unhealthyFor, u.failures)
+		u.unhealthyUntil = time.Now().Add(unhealthyFor)
+		u.failures = 0
+	}
+}
+
+// upstreams picks upstreams round robin, skipping unhealthy ones unless they all are.
+type upstreams struct {
+	all  []*upstream
+	next atomic.Uint64
+}
+
+func (u *upstreams) pick() *upstream {
+	now := time.Now()
+	for range u.all {
+		candidate := u.all[(u.next.Add(1)-1)%uint64(len(u.all))]
+		if candidate.healthy(now) {
+			return candidate
+		}
+	}
+
+	return u.all[(u.next.Add(1)-1)%uint64(len(u.all))]
+}
+
+// proxyTransport sends each attempt to the next upstream, retrying idempotent requests without a body.
+type proxyTransport struct {
+	base         http.RoundTripper
+	upstreams    *upstreams
+	retries      int
+	threshold    int
+	unhealthyFor time.Duration
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := idempotentMethods[req.Method] && (req.Body == nil || req.Body == http.NoBody)
+
+	for attempt := 0; ; attempt++ {
+		target := t.upstreams.pick()
+		out := req.Clone(req.Context())
+		out.URL.Scheme = target.url.Scheme
+		out.URL.Host = target.url.Host
+
+		resp, err := t.base.RoundTrip(out)
+		failed := err != nil || upstreamUnavailable(resp.StatusCode)
+		target.record(failed, t.threshold, t.unhealthyFor)
+
+		if !failed || !retryable || attempt >= t.retries || req.Context().Err() != nil {
+			if err != nil {
+				return nil, fmt.Errorf("%w", err)
+			}
+
+			return resp, nil
+		}
+
+		if resp != nil {
+			closeErr := resp.Body.Close()
+			if closeErr != nil {
+				logrus.Debugf("Unable to close the response from upstream %s: %s", target.url, closeErr)
+			}
+		}
+		logrus.Debugf("Retrying %s %s after upstream %s failed.", req.Method, req.URL.Path, target.url)
+	}
+}
+
+// upstreamUnavailable reports whether the status means the upstream could not handle the request.
+func upstreamUnavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// Proxy returns a handler forwarding requests to the upstreams, to register as a Handler's HTTPHandler on any Router.
+// Register it with a wildcard path and AnyMethod e.g. /api/*path to forward a whole path space. Each upstream is
+// normalised with url.BuildURL, any path is dropped.
+func Proxy(config ProxyConfig) (http.Handler, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, errNoUpstreams
+	}
+
+	tlsConfig, err := proxyTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := config.DefaultScheme
+	if scheme == "" {
+		scheme = defaultProxyScheme
+		if config.ClientCert != nil || config.RootCA != nil {
+			scheme = defaultProxyTLSScheme
+		}
+	}
+
+	pool := &upstreams{}
+	for _, address := range config.Upstreams {
+		normalised, err := url.BuildURL(address, scheme, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %w", errInvalidUpstream, address, err)
+		}
+
+		parsed, err := neturl.Parse(normalised)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("%w: %s", errInvalidUpstream, address)
+		}
+		pool.all = append(pool.all, &upstream{url: parsed})
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite:        proxyRewrite(config, pool.all[0].url),
+		Transport:      newProxyTransport(config, tlsConfig, pool),
+		ModifyResponse: proxyModifyResponse(config),
+		ErrorHandler:   proxyErrorHandler,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTP(proxyResponseWriter{w}, r)
+	}), nil
+}
+
+// proxyResponseWriter hides the CloseNotify of gin's writer, which panics when the underlying writer does not have one
+// e.g. a httptest.ResponseRecorder. The reverse proxy uses the request context instead.
+type proxyResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap allows the reverse proxy to flush through gin's writer.
+func (w proxyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func newProxyTransport(config ProxyConfig, tlsConfig *tls.Config, pool *upstreams) *proxyTransport {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultProxyTimeout
+	}
+
+	dialTimeout := config.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultProxyDialTimeout
+	}
+
+	threshold := config.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultProxyFailureThreshold
+	}
+
+	unhealthyFor := config.UnhealthyFor
+	if unhealthyFor <= 0 {
+		unhealthyFor = defaultProxyUnhealthyFor
+	}
+
+	base, _ := http.DefaultTransport.(*http.Transport)
+	transport := base.Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout}).DialContext
+	transport.ResponseHeaderTimeout = timeout
+	transport.TLSClientConfig = tlsConfig
+
+	return &proxyTransport{
+		base:         transport,
+		upstreams:    pool,
+		retries:      config.Retries,
+		threshold:    threshold,
+		unhealthyFor: unhealthyFor,
+	}
+}
+
+// proxyTLSConfig returns the TLS config for mutual TLS with the upstreams.
+func proxyTLSConfig(config ProxyConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.ClientCert != nil {
+		cert, err := tls.X509KeyPair(config.ClientCert.Certificate, config.ClientCert.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidProxyKeyPair, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.RootCA != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(config.RootCA.Certificate) {
+			return nil, errInvalidProxyRootCA
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// stripPathPrefix removes the prefix from the escaped path if it is made of whole segments of it, so that /api is
+// removed from /api/users but not from /apiary.
+func stripPathPrefix(path string, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	switch {
+	case prefix == "":
+		return path
+	case path == prefix:
+		return "/"
+	case strings.HasPrefix(path, prefix+"/"):
+		return path[len(prefix):]
+	}
+
+	return path
+}
+
+// proxyRewrite returns the function rewriting the path and headers of the upstream request. The path is rewritten in
+// its escaped form so that escaped characters such as %2F reach the upstream unchanged. The upstream is chosen by the
+// transport for each attempt.
+func proxyRewrite(config ProxyConfig, initial *neturl.URL) func(r *httputil.ProxyRequest) {
+	stripPrefix := (&neturl.URL{Path: config.StripPrefix}).EscapedPath()
+	addPrefix := (&neturl.URL{Path: config.AddPrefix}).EscapedPath()
+
+	return func(r *httputil.ProxyRequest) {
+		path := stripPathPrefix(r.In.URL.EscapedPath(), stripPrefix)
+		if addPrefix != "" {
+			path = strings.TrimSuffix(addPrefix, "/") + "/" + strings.TrimPrefix(path, "/")
+		}
+
+		if config.Rewrite != nil {
+			path = config.Rewrite(path)
+		}
+
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		r.Out.URL.Scheme = initial.Scheme
+		r.Out.URL.Host = initial.Host
+		r.Out.URL.Path = path
+		r.Out.URL.RawPath = ""
+		if unescaped, err := neturl.PathUnescape(path); err == nil {
+			r.Out.URL.Path = unescaped
+			r.Out.URL.RawPath = path
+		}
+		r.Out.Host = ""
+		r.SetXForwarded()
+
+		for _, header := range config.StripHeaders {
+			r.Out.Header.Del(header)
+		}
+
+		for header, value := range config.SetHeaders {
+			r.Out.Header.Set(header, value)
+		}
+	}
+}
+
+func proxyModifyResponse(config ProxyConfig) func(resp *http.Response) error {
+	return func(resp *http.Response) error {
+		for _, header := range config.StripResponseHeaders {
+			resp.Header.Del(header)
+		}
+
+		return nil
+	}
+}
+
+// proxyErrorHandler responds with a 504 if the upstream timed out and a 502 otherwise.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		status = http.StatusGatewayTimeout
+	}
+
+	logrus.Warnf("Unable to proxy %s %s: %s", r.Method, r.URL.Path, err)
+	w.WriteHeader(status)
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/go-libs/pkg/certificate"
+)
+
+// upstreamServer returns a server writing its name, the path and the X-Injected header it received.
+func upstreamServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", name)
+		w.Header().Set("X-Internal", "secret")
+		_, _ = w.Write([]byte(name + " " + r.URL.Path + " " + r.Header.Get("X-Injected") + r.Header.Get("Cookie")))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func proxyService(t *testing.T, config ProxyConfig) *Service {
+	t.Helper()
+
+	proxy, err := Proxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: AnyMethod, HTTPHandler: proxy, Path: "/api/*path"}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc
+}
+
+func TestProxyRewritesAndBalances(t *testing.T) {
+	first, second := upstreamServer(t, "first"), upstreamServer(t, "second")
+	svc := proxyService(t, ProxyConfig{
+		Upstreams:            []string{first.URL, strings.TrimPrefix(second.URL, "http://") + "/ignored"},
+		StripPrefix:          "/api",
+		AddPrefix:            "/v2",
+		SetHeaders:           map[string]string{"X-Injected": "yes"},
+		StripHeaders:         []string{"Cookie"},
+		StripResponseHeaders: []string{"X-Internal"},
+	})
+
+	var bodies []string
+	for range 2 {
+		rr, err := sendRequest(svc, http.MethodGet, "/api/users/1", headers{Name: "Cookie", Value: "session=1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != http.StatusOK || rr.Header().Get("X-Internal") != "" {
+			t.Errorf("Unexpected response %d %v.", rr.Code, rr.Header())
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+
+	expected := []string{"first /v2/users/1 yes", "second /v2/users/1 yes"}
+	if strings.Join(bodies, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected bodies %v, expected %v.", bodies, expected)
+	}
+}
+
+func TestProxyStripsWholeSegmentsAndKeepsEscaping(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RequestURI))
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxy, err := Proxy(ProxyConfig{Upstreams: []string{upstream.URL}, StripPrefix: "/api/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				ListenAddress: ":8888",
+				Router:        router,
+				Handlers:      []Handler{{Method: AnyMethod, HTTPHandler: proxy, Path: "/*path"}},
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for path, expected := range map[string]string{
+				"/api/users?page=2": "/users?page=2",
+				"/api":              "/",
+				"/apiary/bees":      "/apiary/bees",
+				"/api/files/a%2Fb":  "/files/a%2Fb",
+			} {
+				checkBody(t, svc, path, http.StatusOK, expected)
+			}
+		})
+	}
+}
+
+func TestProxyRetriesAndMarksUnhealthy(t *testing.T) {
+	healthy := upstreamServer(t, "healthy")
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	svc := proxyService(t, ProxyConfig{
+		Upstreams:        []string{downURL, healthy.URL},
+		Retries:          1,
+		FailureThreshold: 1,
+		UnhealthyFor:     time.Minute,
+	})
+
+	for range 3 {
+		rr, err := sendRequest(svc, http.MethodGet, "/api/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Body.String(), "healthy") {
+			t.Errorf("Expected the healthy upstream but got %d <%s>.", rr.Code, rr.Body.String())
+		}
+	}
+
+	rr, err := sendRequest(svc, http.MethodPost, "/api/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("The unhealthy upstream should be skipped but got %d.", rr.Code)
+	}
+}
+
+func TestProxyDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	healthy := upstreamServer(t, "healthy")
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	svc := proxyService(t, ProxyConfig{Upstreams: []string{downURL, healthy.URL}, Retries: 1})
+
+	rr, err := sendRequest(svc, http.MethodPost, "/api/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d but got %d.", http.StatusBadGateway, rr.Code)
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	svc := proxyService(t, ProxyConfig{Upstreams: []string{slow.URL}, Timeout: 20 * time.Millisecond})
+
+	rr, err := sendRequest(svc, http.MethodGet, "/api/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %d but got %d.", http.StatusGatewayTimeout, rr.Code)
+	}
+}
+
+func TestProxyMutualTLS(t *testing.T) {
+	ca, err := certificate.GenerateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverPair, err := certificate.GenerateSignedCert(ca, certificate.HostNames{"127.0.0.1"}, "upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPair, err := certificate.GenerateSignedCert(ca, nil, "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCert, err := tls.X509KeyPair(serverPair.Certificate, serverPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.Certificate)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	upstream.StartTLS()
+	t.Cleanup(upstream.Close)
+
+	svc := proxyService(t, ProxyConfig{
+		Upstreams:  []string{strings.TrimPrefix(upstream.URL, "https://")},
+		ClientCert: clientPair,
+		RootCA:     ca,
+	})
+
+	rr, err := sendRequest(svc, http.MethodGet, "/api/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "proxy" {
+		t.Errorf("Unexpected response %d <%s>.", rr.Code, rr.Body.String())
+	}
+}
+
+func TestProxyInvalidConfigErrors(t *testing.T) {
+	for _, config := range []ProxyConfig{
+		{},
+		{Upstreams: []string{""}},
+		{Upstreams: []string{"localhost:8080"}, RootCA: &certificate.KeyPair{Certificate: []byte("invalid")}},
+	} {
+		if _, err := Proxy(config); err == nil {
+			t.Errorf("Expected an error for %+v.", config)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	ginlogrus "github.com/toorop/gin-logrus"
+)
+
+var errRouterUnsupported = errors.New("not supported by the router, only by the gin router")
+
+// Middleware wraps the next handler in the chain, calling it to carry on handling the request. It is the router
+// independent form of gin middleware.
+type Middleware func(next http.Handler) http.Handler
+
+// Router registers the routes and middleware of a service. GinRouter is the default and supports every feature. Other
+// routers, such as ServeMuxRouter, support handlers, middleware, groups, CORS, rate limits, IP filters, maintenance
+// mode, fault injection, the error handler and the readiness, metrics, log level and version endpoints, running gin
+// handlers and middleware on a gin engine shared by the service. Compression, caching, idempotency, concurrency limits,
+// security headers, static assets, the profiler, SSE and WebSockets need the GinRouter and Config.Validate reports them
+// configured with any other.
+type Router interface {
+	http.Handler
+	// Group returns the named group of routes, or the default route if the name is empty.
+	Group(name string) RouteGroup
+}
+
+// RouteGroup is a set of routes sharing middleware. On the GinRouter middleware only runs on the routes handled after
+// it is added, as gin's does, while on the ServeMuxRouter it runs on all the group's routes.
+type RouteGroup interface {
+	Use(middleware ...Middleware)
+	// Handle registers the handler for the method, or AnyMethod, on the path. Paths are in gin's syntax e.g.
+	// /users/:id/*rest and the parameters are available from the request's PathValue.
+	Handle(method, path string, handler http.Handler)
+}
+
+// GinRouter is the default router, registering the routes on a gin engine.
+type GinRouter struct {
+	engine *gin.Engine
+}
+
+// NewGinRouter returns a router on a new gin engine.
+func NewGinRouter() *GinRouter {
+	gin.SetMode(gin.ReleaseMode)
+
+	return &GinRouter{engine: gin.New()}
+}
+
+// Engine returns the gin engine e.g. to register routes which are not part of the config.
+func (r *GinRouter) Engine() *gin.Engine {
+	return r.engine
+}
+
+func (r *GinRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.engine.ServeHTTP(w, req)
+}
+
+// Group returns the gin router group of the name, the same group the config's handlers of the group are added to.
+func (r *GinRouter) Group(name string) RouteGroup {
+	return ginGroup{group: getRouterGroup(r.engine, name)}
+}
+
+type ginGroup struct {
+	group *gin.RouterGroup
+}
+
+func (g ginGroup) Use(middleware ...Middleware) {
+	for _, m := range middleware {
+		g.group.Use(wrapMiddleware(m))
+	}
+}
+
+func (g ginGroup) Handle(method, path string, handler http.Handler) {
+	handleRoute(g.group, method, path, wrapHTTPHandler(handler))
+}
+
+// wrapHTTPHandler runs a standard handler from gin, setting the route's parameters as the request's path values the
+// way http.ServeMux does e.g. without the leading / of a catch all parameter.
+func wrapHTTPHandler(handler http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, param := range c.Params {
+			c.Request.SetPathValue(param.Key, strings.TrimPrefix(param.Value, "/"))
+		}
+
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// ginChain is the state of a request passing through standard middleware run from gin.
+type ginChain struct {
+	c      *gin.Context
+	called bool
+}
+
+type ginChainKey struct{}
+
+// wrapMiddleware runs standard middleware from gin. The rest of the gin chain runs when the middleware calls the next
+// handler, with the request and writer it was passed, and is aborted if it does not.
+func wrapMiddleware(middleware Middleware) gin.HandlerFunc {
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chain, _ := r.Context().Value(ginChainKey{}).(*ginChain)
+		chain.called = true
+
+		c := chain.c
+		writer := c.Writer
+		c.Request = r
+		if w != http.ResponseWriter(writer) {
+			c.Writer = &middlewareWriter{ResponseWriter: writer, writer: w}
+		}
+
+		c.Next()
+		c.Writer = writer
+	}))
+
+	return func(c *gin.Context) {
+		chain := &ginChain{c: c}
+		handler.ServeHTTP(c.Writer, c.Request.WithContext(context.WithValue(c.Request.Context(), ginChainKey{}, chain)))
+
+		if !chain.called {
+			c.Abort()
+		}
+	}
+}
+
+// middlewareWriter sends what the rest of the gin chain writes to the writer standard middleware passed on.
+type middlewareWriter struct {
+	gin.ResponseWriter
+	writer http.ResponseWriter
+}
+
+func (w *middlewareWriter) Header() http.Header {
+	return w.writer.Header()
+}
+
+func (w *middlewareWriter) WriteHeader(code int) {
+	w.writer.WriteHeader(code)
+}
+
+// WriteHeaderNow does nothing as the status has already been passed on by WriteHeader.
+func (w *middlewareWriter) WriteHeaderNow() {}
+
+func (w *middlewareWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+func (w *middlewareWriter) WriteString(s string) (int, error) {
+	n, err := io.WriteString(w.writer, s)
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+func (w *middlewareWriter) Flush() {
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// bridgeMethod is the method of the bridge's routes, which no client request is sent with.
+const bridgeMethod = "BRIDGE"
+
+// bridgedKey marks the gin context of a bridged chain, whose FullPath is that of the bridge's route.
+const bridgedKey = "service.bridged"
+
+type bridgeRequestKey struct{}
+
+// ginBridge runs gin middleware and handlers on routers other than gin. Each chain is a route of its own on one gin
+// engine, which resolves the client IP as the service's config says, and requests are sent to the chain's route with
+// their method and URL restored before the chain runs.
+type ginBridge struct {
+	engine *gin.Engine
+	chains int
+}
+
+func newGinBridge(trustedProxies []string, remoteIPHeaders []string) (*ginBridge, error) {
+	engine := gin.New()
+	if err := setupTrustedProxies(trustedProxies, remoteIPHeaders, engine); err != nil {
+		return nil, err
+	}
+
+	return &ginBridge{engine: engine}, nil
+}
+
+// route registers the gin chain, returning a standard handler which runs it.
+func (b *ginBridge) route(handlers ...gin.HandlerFunc) http.Handler {
+	b.chains++
+	path := fmt.Sprintf("/%d", b.chains)
+	restore := func(c *gin.Context) {
+		c.Request, _ = c.Request.Context().Value(bridgeRequestKey{}).(*http.Request)
+		c.Set(bridgedKey, true)
+	}
+	b.engine.Handle(bridgeMethod, path, append([]gin.HandlerFunc{restore}, handlers...)...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routed := r.WithContext(context.WithValue(r.Context(), bridgeRequestKey{}, r))
+		routed.Method = bridgeMethod
+		routed.URL = &url.URL{Path: path}
+		b.engine.ServeHTTP(w, routed)
+	})
+}
+
+// middleware returns standard middleware running the gin middleware before the next handler.
+func (b *ginBridge) middleware(handlers ...gin.HandlerFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return b.route(append(append([]gin.HandlerFunc{}, handlers...), func(c *gin.Context) {
+			next.ServeHTTP(c.Writer, c.Request)
+		})...)
+	}
+}
+
+// handler returns a standard handler running the gin handlers.
+func (b *ginBridge) handler(handlers ...gin.HandlerFunc) http.Handler {
+	return b.route(handlers...)
+}
+
+// bridged reports whether the gin context is that of a bridged chain, which has no matched route of its own.
+func bridged(c *gin.Context) bool {
+	return c.GetBool(bridgedKey)
+}
+
+// routeParams sets the gin parameters of the route, in gin's syntax, from the request's path values the way gin
+// would e.g. with the leading / of a catch all parameter.
+func routeParams(path string) gin.HandlerFunc {
+	var wildcards []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			wildcards = append(wildcards, segment)
+		}
+	}
+
+	return func(c *gin.Context) {
+		for _, wildcard := range wildcards {
+			value := c.Request.PathValue(wildcard[1:])
+			if wildcard[0] == '*' {
+				value = "/" + value
+			}
+			c.Params = append(c.Params, gin.Param{Key: wildcard[1:], Value: value})
+		}
+	}
+}
+
+// chainMiddleware returns the handler wrapped in the middleware, the first of which runs first.
+func chainMiddleware(middleware []Middleware, handler http.Handler) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// supportedMethod reports whether handlers can be registered for the method.
+func supportedMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete, http.MethodOptions, AnyMethod:
+		return true
+	default:
+		return false
+	}
+}
+
+// setupRouter registers the config on a router other than gin, see Router for the features supported.
+func setupRouter(cfg *Config, router Router, accessLogger *logrus.Logger, levels *logLevels,
+	maintenance *maintenanceMode,
+) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w, error caught: %v", errRecoveredFromPanic, r)
+		}
+	}()
+
+	bridge, err := newGinBridge(cfg.TrustedProxies, cfg.RemoteIPHeaders)
+	if err != nil {
+		return err
+	}
+
+	root := router.Group("")
+	if accessLogger != nil {
+		root.Use(bridge.middleware(ginlogrus.Logger(accessLogger, cfg.LogIgnorePaths...)))
+	}
+
+	if maintenance != nil {
+		root.Use(bridge.middleware(maintenance.middleware()))
+	}
+
+	faults, err := newFaultInjector(cfg)
+	if err != nil {
+		return err
+	}
+
+	if faults != nil {
+		root.Use(bridge.middleware(faults.middleware()))
+	}
+
+	for i := range cfg.IPFilters {
+		filter, err := ipFilterHandler(&cfg.IPFilters[i])
+		if err != nil {
+			return err
+		}
+		useOnGroups(router, cfg.IPFilters[i].Groups, bridge.middleware(filter))
+	}
+
+	if cfg.Cors != nil && cfg.Cors.Enabled {
+		corsHandler := cors.Default()
+		if cfg.Cors.OverrideCfg != nil {
+			corsHandler = cors.New(*cfg.Cors.OverrideCfg)
+		}
+		corsHandler = skipCorsOverrides(corsOverrides(cfg.Handlers), corsHandler)
+		useOnGroups(router, cfg.Cors.Groups, bridge.middleware(corsHandler))
+	}
+
+	if cfg.ReadinessCheck {
+		root.Handle(http.MethodGet, ReadinessEndpoint, bridge.handler(readinessHandler(maintenance)))
+	}
+
+	if cfg.Metrics {
+		recordBuildInfo()
+		root.Handle(http.MethodGet, "/metrics", promhttp.Handler())
+	}
+
+	if cfg.ErrorHandler != nil {
+		errorHandler := cfg.ErrorHandler.Handler
+		useOnGroups(router, cfg.ErrorHandler.Groups, bridge.middleware(func(c *gin.Context) {
+			c.Next()
+
+			errorHandler(c)
+		}))
+	}
+
+	for _, limit := range cfg.rateLimits() {
+		useOnGroups(router, limit.Groups, bridge.middleware(rateLimitHandler(limit.Limit, limit.Within)))
+	}
+
+	for _, middleware := range cfg.MiddlewareHandlers {
+		if middleware.Middleware != nil {
+			useOnGroups(router, middleware.Groups, middleware.Middleware)
+		} else {
+			useOnGroups(router, middleware.Groups, bridge.middleware(middleware.Handler))
+		}
+	}
+
+	if err := setupRouterEndpoints(cfg.Handlers, router, bridge); err != nil {
+		return err
+	}
+
+	if config := cfg.LogLevelControl; config != nil {
+		path := config.Path
+		if path == "" {
+			path = DefaultLogLevelPath
+		}
+
+		handler := bridge.handler(levels.handler())
+		router.Group(config.Group).Handle(http.MethodGet, path, handler)
+		router.Group(config.Group).Handle(http.MethodPut, path, handler)
+	}
+
+	if maintenance != nil && maintenance.config.Endpoint {
+		handler := bridge.handler(maintenance.handler())
+		router.Group(maintenance.config.Group).Handle(http.MethodGet, maintenance.adminPath, handler)
+		router.Group(maintenance.config.Group).Handle(http.MethodPut, maintenance.adminPath, handler)
+	}
+
+	if faults != nil && cfg.FaultInjection.Endpoint {
+		handler := bridge.handler(faults.handler())
+		router.Group(cfg.FaultInjection.Group).Handle(http.MethodGet, faults.adminPath, handler)
+		router.Group(cfg.FaultInjection.Group).Handle(http.MethodPut, faults.adminPath, handler)
+	}
+
+	if config := cfg.Version; config != nil {
+		path := config.Path
+		if path == "" {
+			path = DefaultVersionPath
+		}
+		router.Group(config.Group).Handle(http.MethodGet, path, bridge.handler(versionHandler(config)))
+	}
+
+	return nil
+}
+
+// useOnGroups adds the middleware to the groups, or the default route if there are none.
+func useOnGroups(router Router, groups []string, middleware Middleware) {
+	if len(groups) == 0 {
+		router.Group("").Use(middleware)
+
+		return
+	}
+
+	for _, group := range groups {
+		router.Group(group).Use(middleware)
+	}
+}
+
+// setupRouterEndpoints registers the handlers. The middleware runs in the order given by Handler except that the
+// handler's rate limiter runs after its group's middleware.
+func setupRouterEndpoints(handlers []Handler, router Router, bridge *ginBridge) error {
+	preflights := make(map[string]bool)
+	for _, handler := range handlers {
+		if handler.Method == http.MethodOptions || handler.Method == AnyMethod {
+			preflights[handler.Path] = true
+		}
+	}
+
+	for _, handler := range handlers {
+		if !supportedMethod(handler.Method) {
+			logrus.Warnf("HTTP method %s unsupported.", handler.Method)
+
+			continue
+		}
+
+		var access []Middleware
+		if handler.IPFilter != nil {
+			filter, err := ipFilterHandler(handler.IPFilter)
+			if err != nil {
+				return err
+			}
+			access = append(access, bridge.middleware(filter))
+		}
+
+		if handler.Cors != nil {
+			access = append(access, bridge.middleware(cors.New(*handler.Cors)))
+		}
+
+		var chain []Middleware
+		if handler.RateLimitConfig != nil {
+			chain = append(chain, bridge.middleware(getRateLimitHandler(handler.RateLimitConfig)))
+		}
+		chain = append(chain, access...)
+
+		params := routeParams(handler.Path)
+		for _, middleware := range handler.Middleware {
+			chain = append(chain, bridge.middleware(params, middleware))
+		}
+		chain = append(chain, handler.HTTPMiddleware...)
+
+		endpoint := handler.HTTPHandler
+		if endpoint == nil {
+			endpoint = bridge.handler(params, handler.Handler)
+		}
+
+		group := router.Group(handler.Group)
+		group.Handle(handler.Method, handler.Path, chainMiddleware(chain, endpoint))
+
+		// The first handler with a CORS config for a path decides its preflight response.
+		if handler.Cors != nil && !preflights[handler.Path] {
+			preflights[handler.Path] = true
+			preflight := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+			group.Handle(http.MethodOptions, handler.Path, chainMiddleware(access, preflight))
+		}
+	}
+
+	return nil
+}
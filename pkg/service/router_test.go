@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// userHandler responds with the id path value.
+func userHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("user " + r.PathValue("id") + " " + r.PathValue("rest")))
+	})
+}
+
+// headerMiddleware adds its name to the X-Chain response header before carrying on.
+func headerMiddleware(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// upperCaseWriter upper cases the body, standing in for middleware which replaces the writer.
+type upperCaseWriter struct {
+	http.ResponseWriter
+}
+
+func (w upperCaseWriter) Write(data []byte) (int, error) {
+	return w.ResponseWriter.Write([]byte(strings.ToUpper(string(data))))
+}
+
+func upperCaseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(upperCaseWriter{w}, r)
+	})
+}
+
+func routerConfig(router Router) Config {
+	ginMiddleware := func(c *gin.Context) { c.Writer.Header().Add("X-Chain", "gin") }
+
+	return Config{
+		ListenAddress:  ":8888",
+		Router:         router,
+		ReadinessCheck: true,
+		Handlers: []Handler{
+			{
+				Method:         http.MethodGet,
+				Path:           "/users/:id/*rest",
+				Group:          "api",
+				HTTPHandler:    userHandler(),
+				Middleware:     []func(c *gin.Context){ginMiddleware},
+				HTTPMiddleware: []Middleware{headerMiddleware("handler"), upperCaseMiddleware},
+			},
+		},
+		MiddlewareHandlers: []MiddlewareHandler{{Groups: []string{"api"}, Middleware: headerMiddleware("group")}},
+	}
+}
+
+func TestRouters(t *testing.T) {
+	for name, router := range map[string]Router{"gin": nil, "ginRouter": NewGinRouter(), "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			cfg := routerConfig(router)
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr, err := sendRequest(svc, http.MethodGet, "/users/42/a/b")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Code != http.StatusOK || rr.Body.String() != "USER 42 A/B" {
+				t.Errorf("Unexpected response %d %q.", rr.Code, rr.Body.String())
+			}
+
+			if chain := rr.Header().Values("X-Chain"); strings.Join(chain, ",") != "group,gin,handler" {
+				t.Errorf("Expected the middleware to run in order but got %v.", chain)
+			}
+
+			rr, err = sendRequest(svc, http.MethodGet, ReadinessEndpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "UP") {
+				t.Errorf("Unexpected readiness response %d %q.", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddlewareAbortsChain(t *testing.T) {
+	deny := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			cfg := Config{
+				ListenAddress: ":8888",
+				Router:        router,
+				Handlers: []Handler{{Method: http.MethodGet, Path: testEndpoint, HTTPHandler: http.HandlerFunc(
+					func(http.ResponseWriter, *http.Request) { called = true })}},
+				MiddlewareHandlers: []MiddlewareHandler{{Middleware: deny}},
+			}
+
+			_, err := checkResponseCode(http.MethodGet, testEndpoint, cfg, http.StatusForbidden)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if called {
+				t.Error("The handler should not run when the middleware does not call the next handler.")
+			}
+		})
+	}
+}
+
+func TestServeMuxRouterCorsAndRateLimit(t *testing.T) {
+	corsConfig := &cors.Config{AllowOrigins: []string{"https://example.com"}, AllowMethods: []string{"POST"}}
+	cfg := Config{
+		ListenAddress: ":8888",
+		Router:        NewServeMuxRouter(),
+		Handlers: []Handler{{
+			Method:          http.MethodPost,
+			Path:            testEndpoint,
+			HTTPHandler:     userHandler(),
+			Cors:            corsConfig,
+			RateLimitConfig: &HandlerRateLimitConfig{Limit: 1, Within: 60},
+		}},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodOptions, testEndpoint, headers{Name: "Origin", Value: "https://example.com"},
+		headers{Name: "Access-Control-Request-Method", Value: http.MethodPost})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Unexpected preflight response %d %v.", rr.Code, rr.Header())
+	}
+
+	for _, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, testEndpoint, nil)
+		svc.Handler.ServeHTTP(rr, req)
+
+		if rr.Code != code {
+			t.Errorf("Expected %d but got %d.", code, rr.Code)
+		}
+	}
+}
+
+func TestValidateRouterUnsupported(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Router:        NewServeMuxRouter(),
+		Handlers: []Handler{
+			{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler(), Cache: &CacheConfig{}},
+		},
+		Compression: &CompressionConfig{},
+	}
+
+	err := cfg.Validate()
+	for _, field := range []string{"Compression", "Handlers[0].Cache"} {
+		if !strings.Contains(err.Error(), field+": ") || !errors.Is(err, errRouterUnsupported) {
+			t.Errorf("Expected %s to be unsupported but got %v.", field, err)
+		}
+	}
+
+	cfg.Router = NewGinRouter()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the gin router to support the config but got %s.", err)
+	}
+}
+
+func TestGinHandlersOnAnyRouter(t *testing.T) {
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, "user %s %s %s", c.Param("id"), c.Param("rest"), c.GetHeader("X-Chain"))
+	}
+	middleware := func(c *gin.Context) {
+		c.Request.Header.Set("X-Chain", "middleware "+c.Param("id"))
+	}
+
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				ListenAddress: ":8888",
+				Router:        router,
+				Handlers: []Handler{{
+					Method:     http.MethodGet,
+					Path:       "/users/:id/*rest",
+					Handler:    handler,
+					Middleware: []func(c *gin.Context){middleware},
+				}},
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkBody(t, svc, "/users/42/a/b", http.StatusOK, "user 42 /a/b middleware 42")
+		})
+	}
+}
+
+func TestServeMuxRouterGroupMiddlewareAddedAfterRoutes(t *testing.T) {
+	router := NewServeMuxRouter()
+	router.Group("api").Handle(http.MethodGet, testEndpoint, userHandler())
+	router.Group("api").Use(headerMiddleware("first"), headerMiddleware("second"))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, testEndpoint, nil))
+
+	if chain := rr.Header().Values("X-Chain"); strings.Join(chain, ",") != "first,second" {
+		t.Errorf("Expected the group's middleware to run on its earlier routes but got %v.", chain)
+	}
+}
+
+func TestMuxPattern(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/":                 "GET /{$}",
+		"/users":            "GET /users",
+		"/users/":           "GET /users/{$}",
+		"/users/:id":        "GET /users/{id}",
+		"/users/:id/*rest":  "GET /users/{id}/{rest...}",
+		"/files/*path":      "GET /files/{path...}",
+		"/users/:id/detail": "GET /users/{id}/detail",
+	} {
+		if pattern := muxPattern(http.MethodGet, path); pattern != expected {
+			t.Errorf("Expected %s to become %s but got %s.", path, expected, pattern)
+		}
+	}
+
+	if pattern := muxPattern(AnyMethod, "/users"); pattern != "/users" {
+		t.Errorf("Expected any method to have no method in the pattern but got %s.", pattern)
+	}
+}
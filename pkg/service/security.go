@@ -0,0 +1,198 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SecurityPresetStrict locks everything down. Suitable for services which only return data.
+	SecurityPresetStrict = "strict"
+	// SecurityPresetAPI is for JSON APIs which may be called from browsers.
+	SecurityPresetAPI = "api"
+	// SecurityPresetUI is for services serving a UI. Scripts and styles must be from the service or carry the nonce.
+	SecurityPresetUI = "ui"
+
+	// CSPNoncePlaceholder is replaced with a per request nonce in a Content-Security-Policy.
+	CSPNoncePlaceholder = "{nonce}"
+	// CSPNonceKey is the gin context key the nonce for the request is stored under.
+	CSPNonceKey = "cspNonce"
+
+	headerStrictTransportSecurity = "Strict-Transport-Security"
+	headerContentSecurityPolicy   = "Content-Security-Policy"
+	headerContentTypeOptions      = "X-Content-Type-Options"
+	headerFrameOptions            = "X-Frame-Options"
+	headerReferrerPolicy          = "Referrer-Policy"
+	headerPermissionsPolicy       = "Permissions-Policy"
+
+	cspNonceBytes = 16
+)
+
+// securityPresets holds the header values for each preset.
+var securityPresets = map[string]map[string]string{
+	SecurityPresetStrict: {
+		headerStrictTransportSecurity: "max-age=63072000; includeSubDomains",
+		headerContentSecurityPolicy:   "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'",
+		headerContentTypeOptions:      "nosniff",
+		headerFrameOptions:            "DENY",
+		headerReferrerPolicy:          "no-referrer",
+		headerPermissionsPolicy:       "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+	},
+	SecurityPresetAPI: {
+		headerStrictTransportSecurity: "max-age=31536000; includeSubDomains",
+		headerContentSecurityPolicy:   "default-src 'none'; frame-ancestors 'none'",
+		headerContentTypeOptions:      "nosniff",
+		headerFrameOptions:            "DENY",
+		headerReferrerPolicy:          "no-referrer",
+		headerPermissionsPolicy:       "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+	},
+	SecurityPresetUI: {
+		headerStrictTransportSecurity: "max-age=31536000",
+		headerContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-" + CSPNoncePlaceholder +
+			"'; style-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; img-src 'self' data:; object-src 'none'; " +
+			"base-uri 'self'; frame-ancestors 'self'",
+		headerContentTypeOptions: "nosniff",
+		headerFrameOptions:       "SAMEORIGIN",
+		headerReferrerPolicy:     "strict-origin-when-cross-origin",
+		headerPermissionsPolicy:  "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+	},
+}
+
+// securityHeaderNames lists every header the security headers middleware controls.
+var securityHeaderNames = []string{
+	headerStrictTransportSecurity,
+	headerContentSecurityPolicy,
+	headerContentTypeOptions,
+	headerFrameOptions,
+	headerReferrerPolicy,
+	headerPermissionsPolicy,
+}
+
+// SecurityHeadersConfig specifies the security headers added to responses. The preset provides the defaults and any
+// header set here overrides it. Configs on groups are applied after the config on the default route so override it.
+type SecurityHeadersConfig struct {
+	Groups                  []string // Optional - which group(s) the headers are added on. Empty means the default route.
+	Preset                  string   // Optional - strict, api or ui. Default is strict.
+	StrictTransportSecurity string   // Optional - Strict-Transport-Security. Only ever sent on requests over TLS.
+	ContentSecurityPolicy   string   // Optional - Content-Security-Policy. {nonce} is replaced with a per request nonce.
+	ContentTypeOptions      string   // Optional - X-Content-Type-Options.
+	FrameOptions            string   // Optional - X-Frame-Options.
+	ReferrerPolicy          string   // Optional - Referrer-Policy.
+	PermissionsPolicy       string   // Optional - Permissions-Policy.
+	Omit                    []string // Optional - names of headers which should not be sent at all.
+}
+
+// headers returns the header values after applying the overrides to the preset.
+func (config *SecurityHeadersConfig) headers() map[string]string {
+	preset, found := securityPresets[config.Preset]
+	if !found {
+		if config.Preset != "" {
+			logrus.Warnf("Security headers preset %s unknown, using %s.", config.Preset, SecurityPresetStrict)
+		}
+		preset = securityPresets[SecurityPresetStrict]
+	}
+
+	values := make(map[string]string, len(preset))
+	for name, value := range preset {
+		values[name] = value
+	}
+
+	overrides := map[string]string{
+		headerStrictTransportSecurity: config.StrictTransportSecurity,
+		headerContentSecurityPolicy:   config.ContentSecurityPolicy,
+		headerContentTypeOptions:      config.ContentTypeOptions,
+		headerFrameOptions:            config.FrameOptions,
+		headerReferrerPolicy:          config.ReferrerPolicy,
+		headerPermissionsPolicy:       config.PermissionsPolicy,
+	}
+	for name, value := range overrides {
+		if value != "" {
+			values[name] = value
+		}
+	}
+
+	for _, name := range config.Omit {
+		delete(values, http.CanonicalHeaderKey(name))
+	}
+
+	return values
+}
+
+// securityHeadersHandler sets every controlled header, removing those without a value so that a group config fully
+// replaces the default route config. HSTS is only sent on requests over TLS as over plain HTTP, e.g. the paths served
+// by the plain HTTP listener, it is ignored by browsers and wrongly pins hosts which are not served over TLS.
+func securityHeadersHandler(config *SecurityHeadersConfig) gin.HandlerFunc {
+	values := config.headers()
+	csp := values[headerContentSecurityPolicy]
+	useNonce := strings.Contains(csp, CSPNoncePlaceholder)
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		for _, name := range securityHeaderNames {
+			value, found := values[name]
+			if !found || (name == headerStrictTransportSecurity && c.Request.TLS == nil) {
+				header.Del(name)
+
+				continue
+			}
+			header.Set(name, value)
+		}
+
+		if useNonce {
+			nonce, err := generateCSPNonce()
+			if err != nil {
+				logrus.Errorf("Unable to generate CSP nonce: %s", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+
+				return
+			}
+			c.Set(CSPNonceKey, nonce)
+			header.Set(headerContentSecurityPolicy, strings.ReplaceAll(csp, CSPNoncePlaceholder, nonce))
+		} else {
+			c.Set(CSPNonceKey, "")
+		}
+	}
+}
+
+func generateCSPNonce() (string, error) {
+	nonce := make([]byte, cspNonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err //nolint:wrapcheck // crypto/rand never returns an error on supported platforms.
+	}
+
+	return base64.StdEncoding.EncodeToString(nonce), nil
+}
+
+// CSPNonce returns the Content-Security-Policy nonce for the request so that it can be passed to templates, e.g.
+// c.HTML(http.StatusOK, "index.tmpl", gin.H{"nonce": service.CSPNonce(c)}). It is empty if no nonce is in use.
+func CSPNonce(c *gin.Context) string {
+	return c.GetString(CSPNonceKey)
+}
+
+func setupSecurityHeaders(configs []SecurityHeadersConfig, engine *gin.Engine) {
+	// Apply the configs on the default route first so that the group configs override them.
+	ordered := make([]*SecurityHeadersConfig, 0, len(configs))
+	for i := range configs {
+		ordered = append(ordered, &configs[i])
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return len(ordered[i].Groups) == 0 && len(ordered[j].Groups) > 0
+	})
+
+	for _, config := range ordered {
+		if len(config.Groups) == 0 {
+			useOnDefaultRoute(engine, securityHeadersHandler(config))
+		} else {
+			for _, groupLabel := range config.Groups {
+				group := getRouterGroup(engine, groupLabel)
+				group.Use(securityHeadersHandler(config))
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nonceHandler returns the CSP nonce for the request.
+func nonceHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.String(http.StatusOK, CSPNonce(c))
+	}
+}
+
+func TestSecurityHeadersStrictPreset(t *testing.T) {
+	cfg := Config{
+		ListenAddress:   ":8888",
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		SecurityHeaders: []SecurityHeadersConfig{{}},
+	}
+
+	rr, err := checkResponseCode(http.MethodGet, testEndpoint, cfg, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range securityPresets[SecurityPresetStrict] {
+		if name == headerStrictTransportSecurity {
+			continue
+		}
+
+		if rr.Header().Get(name) != value {
+			t.Errorf("Expected %s to be <%s> but got <%s>.", name, value, rr.Header().Get(name))
+		}
+	}
+
+	if rr.Header().Get(headerStrictTransportSecurity) != "" {
+		t.Error("HSTS should only be sent on requests over TLS.")
+	}
+}
+
+func TestSecurityHeadersHSTSWithTLS(t *testing.T) {
+	cert, _ := testCertificate(t)
+	cfg := Config{
+		ListenAddress:   ":8888",
+		Handlers:        []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		CertConfig:      &ServerCertificateConfig{Certificate: cert},
+		SecurityHeaders: []SecurityHeadersConfig{{Preset: SecurityPresetAPI, StrictTransportSecurity: "max-age=60"}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest marks a request for an https URL as received over TLS.
+	for url, expected := range map[string]string{"https://example.com" + testEndpoint: "max-age=60", testEndpoint: ""} {
+		rr := httptest.NewRecorder()
+		svc.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+
+		if rr.Header().Get(headerStrictTransportSecurity) != expected {
+			t.Errorf("Expected the HSTS header <%s> for %s but got <%s>.", expected, url,
+				rr.Header().Get(headerStrictTransportSecurity))
+		}
+	}
+}
+
+func TestSecurityHeadersGroupOverride(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint},
+			{Method: http.MethodGet, Handler: nonceHandler(), Path: "/ui", Group: "TestSecurityHeadersGroupOverride"},
+		},
+		SecurityHeaders: []SecurityHeadersConfig{
+			{Groups: []string{"TestSecurityHeadersGroupOverride"}, Preset: SecurityPresetUI, Omit: []string{"permissions-policy"}},
+			{Preset: SecurityPresetStrict},
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strictRr, err := sendRequest(svc, http.MethodGet, testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strictRr.Header().Get(headerFrameOptions) != "DENY" {
+		t.Errorf("Unexpected X-Frame-Options <%s>.", strictRr.Header().Get(headerFrameOptions))
+	}
+
+	uiRr, err := sendRequest(svc, http.MethodGet, "/ui")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uiRr.Header().Get(headerFrameOptions) != "SAMEORIGIN" {
+		t.Errorf("Unexpected X-Frame-Options <%s>.", uiRr.Header().Get(headerFrameOptions))
+	}
+
+	if uiRr.Header().Get(headerPermissionsPolicy) != "" {
+		t.Error("Omitted headers should not be sent.")
+	}
+
+	nonce := uiRr.Body.String()
+	if nonce == "" || !strings.Contains(uiRr.Header().Get(headerContentSecurityPolicy), "'nonce-"+nonce+"'") {
+		t.Errorf("Expected nonce <%s> in CSP <%s>.", nonce, uiRr.Header().Get(headerContentSecurityPolicy))
+	}
+
+	secondRr, err := sendRequest(svc, http.MethodGet, "/ui")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if secondRr.Body.String() == nonce {
+		t.Error("A new nonce should be generated for each request.")
+	}
+}
+
+func TestSecurityHeadersOnDefaultRouteReachGroups(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: nonceHandler(), Path: "/ui", Group: "ui"},
+		},
+		// Compression creates the group before the security headers are set up.
+		Compression:     &CompressionConfig{Groups: []string{"ui"}},
+		SecurityHeaders: []SecurityHeadersConfig{{Preset: SecurityPresetUI}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, "/ui")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerFrameOptions) != "SAMEORIGIN" {
+		t.Errorf("Unexpected X-Frame-Options <%s> on a grouped handler.", rr.Header().Get(headerFrameOptions))
+	}
+
+	nonce := rr.Body.String()
+	if nonce == "" || !strings.Contains(rr.Header().Get(headerContentSecurityPolicy), "'nonce-"+nonce+"'") {
+		t.Errorf("Expected nonce <%s> in CSP <%s>.", nonce, rr.Header().Get(headerContentSecurityPolicy))
+	}
+}
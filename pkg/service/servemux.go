@@ -0,0 +1,119 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ServeMuxRouter registers the routes on a http.ServeMux. Paths in gin's syntax are converted to ServeMux patterns
+// e.g. /users/:id/*rest becomes /users/{id}/{rest...} and a path ending in / only matches itself, so handlers can move
+// between routers unchanged. Middleware on the default route runs on every request, including those matching no route,
+// and middleware on a group runs on all its routes whatever the order they were added in.
+type ServeMuxRouter struct {
+	mux     *http.ServeMux
+	handler http.Handler
+	root    *muxGroup
+	groups  map[string]*muxGroup
+}
+
+// NewServeMuxRouter returns a router on a new http.ServeMux.
+func NewServeMuxRouter() *ServeMuxRouter {
+	router := &ServeMuxRouter{mux: http.NewServeMux(), groups: make(map[string]*muxGroup)}
+	router.handler = router.mux
+	router.root = &muxGroup{router: router}
+
+	return router
+}
+
+// Mux returns the http.ServeMux e.g. to register routes which are not part of the config.
+func (r *ServeMuxRouter) Mux() *http.ServeMux {
+	return r.mux
+}
+
+func (r *ServeMuxRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
+
+func (r *ServeMuxRouter) Group(name string) RouteGroup {
+	if name == "" {
+		return r.root
+	}
+
+	group, found := r.groups[name]
+	if !found {
+		group = &muxGroup{router: r}
+		r.groups[name] = group
+	}
+
+	return group
+}
+
+type muxGroup struct {
+	router     *ServeMuxRouter
+	middleware []Middleware
+	routes     []*muxRoute
+}
+
+// muxRoute is a route of a named group, its chain being rebuilt whenever middleware is added to the group.
+type muxRoute struct {
+	handler http.Handler
+	chain   http.Handler
+}
+
+func (r *muxRoute) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.chain.ServeHTTP(w, req)
+}
+
+// Use adds the middleware to every route of the group, whether it is handled before or after. Middleware must be added
+// before the router serves requests.
+func (g *muxGroup) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+	if g == g.router.root {
+		g.router.handler = chainMiddleware(g.middleware, g.router.mux)
+
+		return
+	}
+
+	for _, route := range g.routes {
+		route.chain = chainMiddleware(g.middleware, route.handler)
+	}
+}
+
+// Handle registers the route, panicking if it conflicts with another as http.ServeMux does.
+func (g *muxGroup) Handle(method, path string, handler http.Handler) {
+	if g != g.router.root {
+		route := &muxRoute{handler: handler, chain: chainMiddleware(g.middleware, handler)}
+		g.routes = append(g.routes, route)
+		handler = route
+	}
+
+	pattern := muxPattern(method, path)
+	g.router.mux.Handle(pattern, handler)
+	logrus.Infof("Route %s %s: %s", method, path, pattern)
+}
+
+// muxPattern converts a method and gin path to a ServeMux pattern e.g. GET /users/{id}/{rest...}.
+func muxPattern(method, path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = "{" + segment[1:] + "}"
+		case strings.HasPrefix(segment, "*"):
+			segments[i] = "{" + segment[1:] + "...}"
+		}
+	}
+
+	pattern := strings.Join(segments, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "{$}"
+	}
+
+	if method == AnyMethod {
+		return pattern
+	}
+
+	return method + " " + pattern
+}
@@ -4,11 +4,16 @@ package service
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cnjack/throttle"
@@ -17,8 +22,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/puppetlabs/go-libs/internal/log"
+	"github.com/puppetlabs/go-libs/pkg/concurrency"
 	"github.com/sirupsen/logrus"
 	ginlogrus "github.com/toorop/gin-logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 const (
@@ -26,11 +34,13 @@ const (
 	AnyMethod = "Any"
 	// ReadinessEndpoint is the default URL for a readiness endpoint.
 	ReadinessEndpoint = "/readiness"
+
+	readHeaderTimeout = 5 * time.Second
 )
 
 // Config will hold the configuration of the service.
 type Config struct {
-	ListenAddress      string                   // Address in the format [host/ip]:port. Mandatory.
+	ListenAddress      string                   // Address in the format [host/ip]:port or unix:///path. Mandatory.
 	DisableLog         bool                     // Turn off service logging. Default is false.
 	LogLevel           string                   // INFO,FATAL,ERROR,WARN, DEBUG, TRACE.
 	Cors               *CorsConfig              // Optional cors config.
@@ -43,28 +53,83 @@ type Config struct {
 	ErrorHandler       *MiddlewareHandler       // Optional. If true a handler will be added to the end of the chain.
 	LogIgnorePaths     []string                 // Optional. If set, these paths will not be logged by the gin logger.
 	EnabledProfiler    bool                     // Optional. If true, pprof will be registered.
+	Compression        *CompressionConfig       // Optional response compression config.
+	StaticAssets       []StaticAssetsConfig     // Optional file systems to be served e.g. a UI built into an embed.FS.
+	UnixSocketMode     os.FileMode              // Optional. Permissions of a unix socket listen address. Default 0660.
+	Listener           net.Listener             // Optional. A listener to serve on in place of the listen address.
+	SocketActivation   *SocketActivationConfig  // Optional. If set, serve on a listener passed in via LISTEN_FDS.
+	Upgrade            *UpgradeConfig           // Optional. If set, a signal hands the listeners over to a new process.
+	OnStart            []Hook                   // Optional. Run in order before serving, any failure aborts start up.
+	OnStop             []Hook                   // Optional. Run in order once the server and workers have stopped.
+	Workers            []Worker                 // Optional. Background functions run for the lifetime of the service.
+	Dispatchers        []concurrency.Dispatcher // Optional. Started before serving and stopped after the server.
+	ShutdownTimeout    time.Duration            // Optional. Time allowed to drain connections. Default 5s.
+	StopTimeout        time.Duration            // Optional. Time then allowed for workers and dispatchers. Default 5s.
+	SecurityHeaders    []SecurityHeadersConfig  // Optional. Security headers, group configs override the default route.
+	TrustedProxies     []string                 // Optional. CIDRs or IPs of proxies trusted to report the client IP.
+	RemoteIPHeaders    []string                 // Optional. Headers trusted proxies report the client IP in.
+	IPFilters          []IPFilterConfig         // Optional. Client IP allow and deny lists.
+	Idempotency        *IdempotencyConfig       // Optional. Store and replay responses for an Idempotency-Key header.
+	PlainHTTP          *PlainHTTPConfig         // Optional. A plain HTTP listener alongside HTTPS redirecting to it.
+	LogLevelControl    *LogLevelConfig          // Optional. Change the log level at runtime by endpoint or signal.
+	Version            *VersionConfig           // Optional. Serve the build info e.g. the revision at /version.
+	ConcurrencyLimits  []ConcurrencyLimitConfig // Optional. Caps on in flight requests, shedding those over the cap.
+	GRPC               *GRPCConfig              // Optional. gRPC services served alongside the handlers.
+	Router             Router                   // Optional. The router the routes are registered on. Default is gin.
+	APIVersioning      *APIVersioningConfig     // Optional. How requests are routed to handlers with an APIVersion.
+	Environment        string                   // Optional. Where the service runs e.g. staging or production.
+	FaultInjection     *FaultInjectionConfig    // Optional. Delay, abort or truncate requests, outside production.
+	Maintenance        *MaintenanceConfig       // Optional. Answer with a 503 while toggled on or while a file exists.
+	RateLimits         []RateLimitConfig        // Optional. Rate limits in addition to RateLimit e.g. one per group.
+	ReadTimeout        time.Duration            // Optional. Time allowed to read a whole request. Default none.
+	WriteTimeout       time.Duration            // Optional. Time allowed to write a response, cutting off long streams.
+	IdleTimeout        time.Duration            // Optional. Time a keep-alive connection may idle. Default ReadTimeout.
 }
 
-// Handler will hold all the callback handlers to be registered. N.B. gin will be used.
+// Handler will hold all the callback handlers to be registered. SSE and WebSocket need the gin router, a Handler or an
+// HTTPHandler can be registered on any Router.
+// A request runs the handler's rate limiter, then the middleware of its group in the order it was set up (logging,
+// maintenance mode, fault injection, concurrency limits, compression, security headers, IP filters, CORS, rate
+// limiting, the error handler, MiddlewareHandlers and idempotency), then the handler's IPFilter, Cors, Middleware in
+// order, Compression, Cache and Idempotency and finally the handler itself. HTTPMiddleware runs after Middleware.
 type Handler struct {
 	Method          string                  // HTTP method or service.AnyMethod to support all limits.
-	Path            string                  // The path the endpoint runs on.
+	Path            string                  // The path the endpoint runs on. A relative path is served from the root.
 	Group           string                  // Optional - specify a group (used to control which middlewares will run)
 	Handler         func(c *gin.Context)    // The handler to be used.
 	RateLimitConfig *HandlerRateLimitConfig // Optional rate limiting config specifically for the handler.
+	Compression     *CompressionConfig      // Optional compression config specifically for the handler.
+	IPFilter        *IPFilterConfig         // Optional client IP allow and deny lists specifically for the handler.
+	Cache           *CacheConfig            // Optional ETags, conditional requests and response caching.
+	Idempotency     *IdempotencyConfig      // Optional Idempotency-Key handling specifically for the handler.
+	SSE             *SSEHub                 // Optional - serve the hub's event stream in place of Handler.
+	WebSocket       *WebSocketConfig        // Optional - serve WebSocket connections in place of Handler.
+	Middleware      []func(c *gin.Context)  // Optional middleware run in order specifically for the handler.
+	Cors            *cors.Config            // Optional CORS config for the handler, replacing the service and group one.
+	Priority        Priority                // Optional - how requests are treated when a concurrency limit is hit.
+	HTTPHandler     http.Handler            // Optional - a standard handler to be used in place of Handler.
+	HTTPMiddleware  []Middleware            // Optional standard middleware run in order specifically for the handler.
+	APIVersion      string                  // Optional - the API version e.g. 2, routed as APIVersioning says.
+	Deprecation     *DeprecationConfig      // Optional - marks the handler deprecated, see DeprecationConfig.
 }
 
 // MiddlewareHandler will hold a middleware handler and the groups on which it should be registered.
 type MiddlewareHandler struct {
-	Groups  []string             // Optional - what group should this middleware run on. Empty means the default route.
-	Handler func(c *gin.Context) // The handler to be used.
+	Groups     []string             // Optional - the groups to run on. Empty means the default route.
+	Handler    func(c *gin.Context) // The handler to be used.
+	Middleware Middleware           // Optional - standard middleware to be used in place of Handler.
 }
 
 // ServerCertificateConfig holds detail of the certificate config to be used.
 type ServerCertificateConfig struct {
-	CertificateFile string // The TLS certificate file.
+	CertificateFile string // The TLS certificate file, optionally followed by its intermediates.
 	KeyFile         string // The TLS private key file.
 	Certificate     *tls.Certificate
+	ClientCAs       *x509.CertPool     // Optional - the CAs client certificates are verified against.
+	ClientAuth      tls.ClientAuthType // Optional - the client certificate policy e.g. tls.RequireAndVerifyClientCert.
+	ChainFile       string             // Optional - the intermediates if they are not in the certificate file.
+	SNICertificates []SNICertificate   // Optional - further certificates chosen by the server name a client asks for.
+	Profile         string             // Optional - TLSProfileModern, TLSProfileIntermediate or TLSProfileFIPS.
 }
 
 // RateLimitConfig specifies the rate limiting config.
@@ -89,8 +154,19 @@ type HandlerRateLimitConfig struct {
 
 // Service will be the actual structure returned.
 type Service struct {
-	*http.Server         // Anonymous embedded struct to allow access to http server methods.
-	config       *Config // The config.
+	*http.Server               // Anonymous embedded struct to allow access to http server methods.
+	config        *Config      // The config.
+	listener      net.Listener // The listener being served on once the service is running.
+	inherited     []namedListener
+	streams       *streams     // The open SSE and WebSocket streams.
+	plainServer   *http.Server // The server for the plain HTTP listener if there is one.
+	plainListener net.Listener
+	logLevels     *logLevels // The levels of the standard and access loggers.
+	maintenance   *maintenanceMode
+	grpcServer    *grpc.Server // The gRPC server if there are gRPC services.
+	grpcHealth    *health.Server
+	grpcListener  net.Listener // The separate gRPC listener if there is one.
+	lifecycle
 }
 
 var (
@@ -99,13 +175,33 @@ var (
 	errRecoveredFromPanic             = errors.New("recovered from panic")
 )
 
-var routerMap = make(map[string]*gin.RouterGroup)
+// routerGroups holds the named groups of each engine while its service is being set up.
+var (
+	routerGroupsMu sync.Mutex
+	routerGroups   = make(map[*gin.Engine]map[string]*gin.RouterGroup)
+)
 
-// Defining the readiness handler for potential use by k8s.
-func readinessHandler() gin.HandlerFunc {
+// Defining the readiness handler for potential use by k8s. With maintenance mode configured it reports whether the
+// service is in maintenance mode.
+func readinessHandler(maintenance *maintenanceMode) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "UP",
+		if maintenance == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"status": "UP",
+			})
+
+			return
+		}
+
+		status := http.StatusOK
+		inMaintenance := maintenance.enabled()
+		if inMaintenance && maintenance.config.Unready {
+			status = http.StatusServiceUnavailable
+		}
+
+		c.JSON(status, gin.H{
+			"status":      "UP",
+			"maintenance": inMaintenance,
 		})
 	}
 }
@@ -118,50 +214,166 @@ func rateLimitHandler(limit uint64, within int) gin.HandlerFunc {
 	})
 }
 
-func setCorsOnRoute(group *gin.RouterGroup, overrideConfig *cors.Config) {
+func corsHandler(overrideConfig *cors.Config, overrides []corsRoute) gin.HandlerFunc {
 	if overrideConfig != nil {
-		group.Use(cors.New(*overrideConfig))
-	} else {
-		group.Use(cors.Default())
+		return skipCorsOverrides(overrides, cors.New(*overrideConfig))
 	}
+
+	return skipCorsOverrides(overrides, cors.Default())
 }
 
-func setupCors(engine *gin.Engine, config *CorsConfig) {
+func setupCors(engine *gin.Engine, config *CorsConfig, handlers []Handler) {
 	if config != nil {
 		if config.Enabled {
-			var corsGroup *gin.RouterGroup
+			overrides := corsOverrides(handlers)
 			if len(config.Groups) == 0 {
-				corsGroup = &engine.RouterGroup
-				setCorsOnRoute(corsGroup, config.OverrideCfg)
+				useOnDefaultRoute(engine, corsHandler(config.OverrideCfg, overrides))
 			} else {
 				for _, rlGroupLabel := range config.Groups {
-					corsGroup = getRouterGroup(engine, rlGroupLabel)
-					setCorsOnRoute(corsGroup, config.OverrideCfg)
+					corsGroup := getRouterGroup(engine, rlGroupLabel)
+					corsGroup.Use(corsHandler(config.OverrideCfg, overrides))
 				}
 			}
 		}
 	}
 }
 
+// corsRoute is the route of a handler with its own CORS config, or of its preflight.
+type corsRoute struct {
+	method   string
+	path     string
+	segments []string
+}
+
+// corsOverrides returns the routes on which the handler's own CORS config replaces the service and group config.
+func corsOverrides(handlers []Handler) []corsRoute {
+	preflights := make(map[string]bool)
+	for _, handler := range handlers {
+		if handler.Method == http.MethodOptions || handler.Method == AnyMethod {
+			preflights[handler.Path] = true
+		}
+	}
+
+	var routes []corsRoute
+	for _, handler := range handlers {
+		if handler.Cors == nil {
+			continue
+		}
+
+		segments := pathSegments(handler.Path)
+		routes = append(routes, corsRoute{method: handler.Method, path: handler.Path, segments: segments})
+		if !preflights[handler.Path] {
+			routes = append(routes, corsRoute{method: http.MethodOptions, path: handler.Path, segments: segments})
+		}
+	}
+
+	return routes
+}
+
+// skipCorsOverrides returns the CORS middleware skipping the routes with their own CORS config, which would otherwise
+// be rejected before their config is checked. Gin's matched route is used when there is one.
+func skipCorsOverrides(routes []corsRoute, handler gin.HandlerFunc) gin.HandlerFunc {
+	if len(routes) == 0 {
+		return handler
+	}
+
+	return func(c *gin.Context) {
+		fullPath := c.FullPath()
+		if bridged(c) {
+			fullPath = ""
+		}
+		segments := pathSegments(c.Request.URL.Path)
+		for _, route := range routes {
+			if route.method != AnyMethod && route.method != c.Request.Method {
+				continue
+			}
+
+			if fullPath != "" && fullPath == route.path || fullPath == "" && matchSegments(route.segments, segments) {
+				return
+			}
+		}
+
+		handler(c)
+	}
+}
+
 func getRouterGroup(engine *gin.Engine, handlerGroup string) *gin.RouterGroup {
 	if handlerGroup == "" {
 		return &engine.RouterGroup
 	}
-	routeGroup, found := routerMap[handlerGroup]
+
+	routerGroupsMu.Lock()
+	defer routerGroupsMu.Unlock()
+
+	groups, found := routerGroups[engine]
+	if !found {
+		groups = make(map[string]*gin.RouterGroup)
+		routerGroups[engine] = groups
+	}
+
+	routeGroup, found := groups[handlerGroup]
 	if found {
 		return routeGroup
 	}
 
 	newGroup := engine.Group("/")
-	routerMap[handlerGroup] = newGroup
+	groups[handlerGroup] = newGroup
 
 	return newGroup
 }
 
-func setupRateLimiting(config *RateLimitConfig, engine *gin.Engine) {
-	if config != nil {
+// useOnDefaultRoute adds the middleware to the default route and to the named groups already created from it, as gin
+// copies the default route's middleware into a group when the group is created.
+func useOnDefaultRoute(engine *gin.Engine, middleware ...gin.HandlerFunc) {
+	engine.RouterGroup.Use(middleware...)
+
+	routerGroupsMu.Lock()
+	defer routerGroupsMu.Unlock()
+
+	for _, group := range routerGroups[engine] {
+		group.Use(middleware...)
+	}
+}
+
+// matchPaths reports whether the path is one of the paths. A trailing "*" matches a prefix.
+func matchPaths(paths []string, path string) bool {
+	for _, candidate := range paths {
+		if prefix, ok := strings.CutSuffix(candidate, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rootedHandlers returns a copy of the handlers with relative paths e.g. users made absolute, as gin serves them from
+// the root.
+func rootedHandlers(handlers []Handler) []Handler {
+	rooted := make([]Handler, len(handlers))
+	copy(rooted, handlers)
+	for i := range rooted {
+		rooted[i].Path = joinPaths("/", rooted[i].Path)
+	}
+
+	return rooted
+}
+
+// releaseRouterGroups forgets the named groups of an engine once its routes are registered.
+func releaseRouterGroups(engine *gin.Engine) {
+	routerGroupsMu.Lock()
+	defer routerGroupsMu.Unlock()
+
+	delete(routerGroups, engine)
+}
+
+func setupRateLimiting(configs []RateLimitConfig, engine *gin.Engine) {
+	for _, config := range configs {
 		if len(config.Groups) == 0 {
-			engine.RouterGroup.Use(rateLimitHandler(config.Limit, config.Within))
+			useOnDefaultRoute(engine, rateLimitHandler(config.Limit, config.Within))
 		} else {
 			for _, rlGroupLabel := range config.Groups {
 				rlGroup := getRouterGroup(engine, rlGroupLabel)
@@ -171,6 +383,15 @@ func setupRateLimiting(config *RateLimitConfig, engine *gin.Engine) {
 	}
 }
 
+// rateLimits returns RateLimit, if set, followed by RateLimits.
+func (c *Config) rateLimits() []RateLimitConfig {
+	if c.RateLimit == nil {
+		return c.RateLimits
+	}
+
+	return append([]RateLimitConfig{*c.RateLimit}, c.RateLimits...)
+}
+
 func getRateLimitHandler(config *HandlerRateLimitConfig) gin.HandlerFunc {
 	return rateLimitHandler(config.Limit, config.Within)
 }
@@ -178,12 +399,17 @@ func getRateLimitHandler(config *HandlerRateLimitConfig) gin.HandlerFunc {
 func setupMiddleware(mwHandlers []MiddlewareHandler, engine *gin.Engine) {
 	// Add middleware first then the handlers
 	for _, handler := range mwHandlers {
+		middleware := handler.Handler
+		if handler.Middleware != nil {
+			middleware = wrapMiddleware(handler.Middleware)
+		}
+
 		if len(handler.Groups) == 0 {
-			engine.RouterGroup.Use(handler.Handler)
+			useOnDefaultRoute(engine, middleware)
 		} else {
 			for _, handlerGroupLabel := range handler.Groups {
 				handlerGroup := getRouterGroup(engine, handlerGroupLabel)
-				handlerGroup.Use(handler.Handler)
+				handlerGroup.Use(middleware)
 			}
 		}
 	}
@@ -197,7 +423,7 @@ func setupErrorHandler(errorHandler MiddlewareHandler, engine *gin.Engine) {
 	}
 
 	if len(errorHandler.Groups) == 0 {
-		engine.RouterGroup.Use(fn)
+		useOnDefaultRoute(engine, fn)
 	} else {
 		for _, handlerGroupLabel := range errorHandler.Groups {
 			handlerGroup := getRouterGroup(engine, handlerGroupLabel)
@@ -206,13 +432,72 @@ func setupErrorHandler(errorHandler MiddlewareHandler, engine *gin.Engine) {
 	}
 }
 
-func setupEndpoints(handlers []Handler, engine *gin.Engine) (err error) {
+// handlerAccessChain returns the handler's IP filter and CORS middleware, which also run on its preflight route.
+func handlerAccessChain(handler Handler) ([]gin.HandlerFunc, error) {
+	var chain []gin.HandlerFunc
+	if handler.IPFilter != nil {
+		filter, err := ipFilterHandler(handler.IPFilter)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, filter)
+	}
+
+	if handler.Cors != nil {
+		chain = append(chain, cors.New(*handler.Cors))
+	}
+
+	return chain, nil
+}
+
+// handlerChain returns the handler preceded by any handler specific middleware, see Handler for the order.
+func handlerChain(handler Handler, access []gin.HandlerFunc, tracker *streams) ([]gin.HandlerFunc, error) {
+	chain := append([]gin.HandlerFunc{}, access...)
+	for _, middleware := range handler.Middleware {
+		chain = append(chain, middleware)
+	}
+
+	for _, middleware := range handler.HTTPMiddleware {
+		chain = append(chain, wrapMiddleware(middleware))
+	}
+
+	if handler.Compression != nil {
+		chain = append(chain, compressionHandler(handler.Compression))
+	}
+
+	if handler.Cache != nil {
+		chain = append(chain, cacheHandler(handler.Cache))
+	}
+
+	if handler.Idempotency != nil {
+		chain = append(chain, idempotencyHandler(handler.Idempotency))
+	}
+
+	switch {
+	case handler.SSE != nil:
+		return append(chain, handler.SSE.handler(tracker)), nil
+	case handler.WebSocket != nil:
+		webSocket, err := webSocketHandler(handler.WebSocket, tracker)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(chain, webSocket), nil
+	case handler.HTTPHandler != nil:
+		return append(chain, wrapHTTPHandler(handler.HTTPHandler)), nil
+	default:
+		return append(chain, handler.Handler), nil
+	}
+}
+
+func setupEndpoints(handlers []Handler, engine *gin.Engine, tracker *streams) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%w, error caught: %v", errRecoveredFromPanic, r)
 		}
 	}()
 
+	var preflights []preflightRoute
 	for _, handler := range handlers {
 		handlerGroup := getRouterGroup(engine, handler.Group)
 
@@ -226,49 +511,86 @@ func setupEndpoints(handlers []Handler, engine *gin.Engine) (err error) {
 			handlerGroup = newHandlerGroup
 		}
 
-		switch method := handler.Method; method {
-		case http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete, http.MethodOptions:
-			handlerGroup.Handle(method, handler.Path, handler.Handler)
-		case AnyMethod:
-			handlerGroup.Any(handler.Path, handler.Handler)
-		default:
-			logrus.Warnf("HTTP method %s unsupported.", method)
+		access, err := handlerAccessChain(handler)
+		if err != nil {
+			return err
+		}
+
+		chain, err := handlerChain(handler, access, tracker)
+		if err != nil {
+			return err
+		}
+
+		if !supportedMethod(handler.Method) {
+			logrus.Warnf("HTTP method %s unsupported.", handler.Method)
+
+			continue
+		}
+		handleRoute(handlerGroup, handler.Method, handler.Path, chain...)
+
+		if handler.Cors != nil && handler.Method != http.MethodOptions && handler.Method != AnyMethod {
+			preflights = append(preflights, preflightRoute{group: handlerGroup, path: handler.Path, chain: access})
 		}
 	}
 
+	setupPreflightRoutes(preflights, engine)
+
 	return nil
 }
 
-// NewService will setup a new service based on the config and return this service.
-func NewService(cfg *Config) (*Service, error) {
-	// Router map only required in the context of this function
-	if len(cfg.Handlers) == 0 {
-		return nil, errNoHandlersRegisteredForService
+// setupGin registers the config on a gin engine.
+func setupGin(cfg *Config, router *gin.Engine, accessLogger *logrus.Logger, levels *logLevels,
+	tracker *streams, maintenance *maintenanceMode,
+) error {
+	defer releaseRouterGroups(router)
+
+	err := setupTrustedProxies(cfg.TrustedProxies, cfg.RemoteIPHeaders, router)
+	if err != nil {
+		return err
 	}
 
-	if cfg.ListenAddress == "" {
-		return nil, errInvalidListenAddress
+	if accessLogger != nil {
+		router.Use(ginlogrus.Logger(accessLogger, cfg.LogIgnorePaths...))
 	}
 
-	gin.SetMode(gin.ReleaseMode)
-	router := gin.New()
+	if maintenance != nil {
+		router.Use(maintenance.middleware())
+	}
 
-	if !cfg.DisableLog {
-		logger := log.CreateLogger(cfg.LogLevel)
-		router.Use(ginlogrus.Logger(logger, cfg.LogIgnorePaths...))
+	faults, err := newFaultInjector(cfg)
+	if err != nil {
+		return err
+	}
+
+	if faults != nil {
+		router.Use(faults.middleware())
+	}
+
+	err = setupConcurrencyLimits(cfg, router)
+	if err != nil {
+		return err
+	}
+
+	setupCompression(cfg.Compression, router)
+	setupSecurityHeaders(cfg.SecurityHeaders, router)
+
+	err = setupIPFilters(cfg.IPFilters, router)
+	if err != nil {
+		return err
 	}
 
 	// Set CORS to the default if it's enabled and no override passed in.
-	setupCors(router, cfg.Cors)
+	setupCors(router, cfg.Cors, cfg.Handlers)
 
 	if cfg.ReadinessCheck {
 		// The readiness handler shouldn't need any middleware to run on it.
 		routerGroup := router.Group("/")
-		routerGroup.GET(ReadinessEndpoint, readinessHandler())
+		handleRoute(routerGroup, http.MethodGet, ReadinessEndpoint, readinessHandler(maintenance))
 	}
 
 	if cfg.Metrics {
-		router.Handle(http.MethodGet, "metrics", gin.WrapH(promhttp.Handler()))
+		recordBuildInfo()
+		handleRoute(&router.RouterGroup, http.MethodGet, "metrics", gin.WrapH(promhttp.Handler()))
 	}
 
 	if cfg.ErrorHandler != nil {
@@ -279,66 +601,290 @@ func NewService(cfg *Config) (*Service, error) {
 		pprof.Register(router)
 	}
 
-	setupRateLimiting(cfg.RateLimit, router)
+	setupRateLimiting(cfg.rateLimits(), router)
 	setupMiddleware(cfg.MiddlewareHandlers, router)
+	setupIdempotency(cfg.Idempotency, router)
+
+	err = setupEndpoints(cfg.Handlers, router, tracker)
+	if err != nil {
+		return err
+	}
+
+	setupLogLevelControl(cfg.LogLevelControl, levels, router)
+	setupFaultInjection(cfg.FaultInjection, faults, router)
+	setupMaintenance(maintenance, router)
+	setupVersion(cfg.Version, router)
+
+	return setupStaticAssets(cfg.StaticAssets, router)
+}
+
+// NewService will setup a new service based on the config and return this service.
+func NewService(cfg *Config) (*Service, error) {
+	rooted := *cfg
+	rooted.Handlers = rootedHandlers(cfg.Handlers)
+	cfg = &rooted
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+
+	var accessLogger *logrus.Logger
+	if !cfg.DisableLog {
+		accessLogger = log.CreateLogger(cfg.LogLevel)
+	}
+	levels := newLogLevels(accessLogger)
+	tracker := newStreams()
 
-	err := setupEndpoints(cfg.Handlers, router)
+	maintenance, err := newMaintenanceMode(cfg.Maintenance)
 	if err != nil {
 		return nil, err
 	}
 
-	readHeaderTimeoutSeconds := 5
+	// The routes are registered at the paths of their API versions.
+	routed := *cfg
+	routed.Handlers = versionedHandlers(cfg)
+
+	var router http.Handler
+	switch configured := cfg.Router.(type) {
+	case nil:
+		engine := NewGinRouter().Engine()
+		router, err = engine, setupGin(&routed, engine, accessLogger, levels, tracker, maintenance)
+	case *GinRouter:
+		router, err = configured, setupGin(&routed, configured.Engine(), accessLogger, levels, tracker, maintenance)
+	default:
+		router, err = configured, setupRouter(&routed, configured, accessLogger, levels, maintenance)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	router = newVersionRouter(cfg, router)
+
 	server := &http.Server{
 		Addr:              cfg.ListenAddress,
 		Handler:           router,
-		ReadHeaderTimeout: time.Duration(readHeaderTimeoutSeconds) * time.Second,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          serverErrorLog(),
+	}
+
+	if cfg.CertConfig != nil {
+		server.TLSConfig, err = serverTLSConfig(cfg.CertConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	svc := &Service{
+		Server:      server,
+		config:      cfg,
+		streams:     tracker,
+		logLevels:   levels,
+		maintenance: maintenance,
+		lifecycle:   lifecycle{stopping: make(chan struct{})},
+	}
+
+	if cfg.GRPC != nil {
+		svc.grpcServer, svc.grpcHealth, err = newGRPCServer(cfg, accessLogger)
+		if err != nil {
+			return nil, err
+		}
+
+		if !cfg.GRPC.separate() {
+			server.Handler = grpcHandler(svc.grpcServer, router)
+			if cfg.CertConfig == nil {
+				server.Protocols = grpcProtocols()
+			}
+		}
 	}
 
-	return &Service{Server: server, config: cfg}, nil
+	if cfg.PlainHTTP != nil {
+		svc.plainServer = newPlainServer(cfg.PlainHTTP, router, svc.httpsPort)
+		svc.plainServer.ReadTimeout = cfg.ReadTimeout
+		svc.plainServer.WriteTimeout = cfg.WriteTimeout
+		svc.plainServer.IdleTimeout = cfg.IdleTimeout
+	}
+
+	return svc, nil
 }
 
 func (s *Service) waitForShutdown() error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
-	<-quit
+	defer signal.Stop(quit)
+
+	upgradeSignal := s.upgradeSignal()
+	if upgradeSignal != nil {
+		signal.Notify(quit, upgradeSignal)
+	}
+
+	logLevelSignals := s.logLevelSignals()
+	if len(logLevelSignals) > 0 {
+		signal.Notify(quit, logLevelSignals...)
+	}
+
+	for waiting := true; waiting; {
+		select {
+		case <-s.stopping:
+			waiting = false
+		case sig := <-quit:
+			if slices.Contains(logLevelSignals, sig) {
+				s.logLevels.toggle()
+
+				break
+			}
 
-	timeoutSeconds := 5
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds)*time.Second)
+			if upgradeSignal == nil || sig != upgradeSignal {
+				waiting = false
+
+				break
+			}
+
+			logrus.Info("Upgrading service.")
+			if err := s.upgrade(); err != nil {
+				logrus.Errorf("Upgrade failed, continuing to serve: %s", err)
+
+				break
+			}
+
+			logrus.Info("Draining connections before handing over to the upgraded service.")
+			waiting = false
+		}
+	}
+
+	return s.shutdown()
+}
+
+// shutdown drains the server within the ShutdownTimeout and then stops everything else within the StopTimeout, so
+// that a slow drain does not leave the workers and dispatchers without time to stop. All the failures are returned.
+func (s *Service) shutdown() error {
+	timeout := s.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+
+	var errs []error
+	// Streams are closed first as the server would otherwise wait for them until the timeout.
+	if s.streams != nil {
+		if err := s.streams.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Health checks report not serving while the calls drain.
+	if s.grpcHealth != nil {
+		s.grpcHealth.Shutdown()
+	}
+
 	if s.Server != nil {
 		if err := s.Shutdown(ctx); err != nil {
-			return fmt.Errorf("%w", err)
+			errs = append(errs, fmt.Errorf("%w", err))
 		}
 	}
 
-	return nil
+	if s.plainServer != nil {
+		if err := s.plainServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("plain HTTP: %w", err))
+		}
+	}
+
+	if s.grpcServer != nil {
+		if err := s.stopGRPC(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	stopTimeout := s.config.StopTimeout
+	if stopTimeout <= 0 {
+		stopTimeout = defaultStopTimeout
+	}
+
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancelStop()
+
+	errs = append(errs, s.stop(stopCtx)...)
+
+	return errors.Join(errs...)
 }
 
 // Run will run the service in the foreground and exit when the server exits.
 func (s *Service) Run() error {
 	log.SetLogLevel(s.config.LogLevel)
+	if s.logLevels != nil {
+		s.logLevels.capture()
+	}
 
-	go func() {
-		if s.config.CertConfig != nil {
-			var tlsConfig *tls.Config
-			if s.config.CertConfig.Certificate != nil {
-				tlsConfig = &tls.Config{
-					MinVersion:   tls.VersionTLS12,
-					Certificates: []tls.Certificate{*s.config.CertConfig.Certificate},
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	listener, err := s.listen()
+	if err != nil {
+		return errors.Join(fmt.Errorf("unable to listen: %w", err), errors.Join(s.stop(context.Background())...))
+	}
+	s.listener = listener
+
+	var plainListener net.Listener
+	if s.plainServer != nil {
+		plainListener, err = s.listenPlain()
+		if err != nil {
+			if closeErr := listener.Close(); closeErr != nil {
+				logrus.Warnf("Unable to close listener: %s", closeErr)
+			}
+
+			return errors.Join(fmt.Errorf("unable to listen for plain HTTP: %w", err),
+				errors.Join(s.stop(context.Background())...))
+		}
+	}
+
+	var grpcListener net.Listener
+	if s.grpcServer != nil && s.config.GRPC.separate() {
+		grpcListener, err = s.listenGRPC()
+		if err != nil {
+			for _, opened := range []net.Listener{listener, plainListener} {
+				if opened == nil {
+					continue
+				}
+
+				if closeErr := opened.Close(); closeErr != nil {
+					logrus.Warnf("Unable to close listener: %s", closeErr)
 				}
 			}
 
-			s.Server.TLSConfig = tlsConfig
+			return errors.Join(fmt.Errorf("unable to listen for gRPC: %w", err),
+				errors.Join(s.stop(context.Background())...))
+		}
+	}
 
-			err := s.Server.ListenAndServeTLS(s.config.CertConfig.CertificateFile, s.config.CertConfig.KeyFile)
+	go func() {
+		if s.Server.TLSConfig != nil {
+			err := s.Server.ServeTLS(listener, "", "")
 			if !errors.Is(err, http.ErrServerClosed) {
 				logrus.Fatalf("Failed to start query service: %s\n", err)
 			}
-		} else if err := s.Server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		} else if err := s.Server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
 			logrus.Fatalf("Failed to start query service: %s\n", err)
 		}
 	}()
 
+	if plainListener != nil {
+		s.servePlain(plainListener)
+	}
+
+	if grpcListener != nil {
+		s.serveGRPC(grpcListener)
+	}
+
+	// If started by an upgrade, the previous process can now stop serving.
+	notifyUpgradeReady()
+
 	// We want a graceful exit
 	return s.waitForShutdown()
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -424,7 +425,7 @@ func TestErrorHandlerOnGroup(t *testing.T) {
 	}
 }
 
-func TestErrorHandlerOnUnusedGroupNotExercised(t *testing.T) {
+func TestErrorHandlerOnUnusedGroupErrors(t *testing.T) {
 	cfg := Config{
 		ListenAddress: ":8888",
 		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
@@ -434,8 +435,8 @@ func TestErrorHandlerOnUnusedGroupNotExercised(t *testing.T) {
 		},
 	}
 
-	_, err := checkResponseCode(http.MethodGet, testEndpoint, cfg, http.StatusOK)
-	if err != nil {
-		t.Error(err)
+	_, err := NewService(&cfg)
+	if !errors.Is(err, errUnknownGroup) {
+		t.Errorf("An error handler on a group without handlers should cause error but got %v.", err)
 	}
 }
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SSEShutdownEvent is the event sent to SSE clients before their stream is closed because the service is stopping.
+	SSEShutdownEvent = "shutdown"
+
+	headerLastEventID           = "Last-Event-ID"
+	contentTypeEventStream      = "text/event-stream"
+	defaultSSEHistorySize       = 100
+	defaultSSEClientBufferSize  = 16
+	defaultSSEHeartbeatInterval = 15 * time.Second
+)
+
+// SSEConfig specifies the behaviour of an SSEHub.
+type SSEConfig struct {
+	HistorySize       int           // Optional - events kept for Last-Event-ID resume. Default 100, negative for none.
+	ClientBufferSize  int           // Optional - events queued per client before a slow client is dropped. Default 16.
+	HeartbeatInterval time.Duration // Optional - interval of the keep alive comments. Default 15 seconds.
+	Retry             time.Duration // Optional - the reconnection time sent to clients.
+}
+
+// SSEEvent is a single Server-Sent Event.
+type SSEEvent struct {
+	ID    string // Set by the hub when the event is published.
+	Event string // Optional - the event type. Clients receive events without a type as message events.
+	Data  string // The event data. Multi line data is sent as multiple data lines.
+}
+
+// SSEHub broadcasts published events to every client connected to its handler. Events are numbered so that a client
+// reconnecting with a Last-Event-ID header is sent the events it missed, as long as they are still in the history.
+type SSEHub struct {
+	config  SSEConfig
+	mu      sync.Mutex
+	lastID  uint64
+	history []SSEEvent
+	clients map[chan SSEEvent]struct{}
+}
+
+// NewSSEHub creates a hub. Set it as the SSE of a Handler to serve its event stream.
+func NewSSEHub(config SSEConfig) *SSEHub {
+	if config.HistorySize == 0 {
+		config.HistorySize = defaultSSEHistorySize
+	}
+
+	if config.ClientBufferSize <= 0 {
+		config.ClientBufferSize = defaultSSEClientBufferSize
+	}
+
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaultSSEHeartbeatInterval
+	}
+
+	return &SSEHub{config: config, clients: make(map[chan SSEEvent]struct{})}
+}
+
+// Publish sends an event to all connected clients and returns the ID it was given.
+func (h *SSEHub) Publish(event string, data string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	sseEvent := SSEEvent{ID: strconv.FormatUint(h.lastID, 10), Event: event, Data: data}
+
+	if h.config.HistorySize > 0 {
+		if len(h.history) == h.config.HistorySize {
+			h.history = h.history[1:]
+		}
+		h.history = append(h.history, sseEvent)
+	}
+
+	for client := range h.clients {
+		select {
+		case client <- sseEvent:
+		default:
+			// The client is not keeping up. Closing its channel ends the stream and it can resume from its last event.
+			delete(h.clients, client)
+			close(client)
+		}
+	}
+
+	return sseEvent.ID
+}
+
+// Clients returns the number of connected clients.
+func (h *SSEHub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.clients)
+}
+
+// subscribe adds a client, returning its channel and the events it missed since lastEventID.
+func (h *SSEHub) subscribe(lastEventID string) (chan SSEEvent, []SSEEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []SSEEvent
+	if lastID, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		for _, event := range h.history {
+			if id, _ := strconv.ParseUint(event.ID, 10, 64); id > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	client := make(chan SSEEvent, h.config.ClientBufferSize)
+	h.clients[client] = struct{}{}
+
+	return client, missed
+}
+
+func (h *SSEHub) unsubscribe(client chan SSEEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, found := h.clients[client]; found {
+		delete(h.clients, client)
+		close(client)
+	}
+}
+
+// handler returns the handler streaming the events to a client until it disconnects or the service shuts down.
+func (h *SSEHub) handler(tracker *streams) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done, ok := tracker.add(streamTypeSSE)
+		if !ok {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+
+			return
+		}
+		defer done()
+
+		client, missed := h.subscribe(c.GetHeader(headerLastEventID))
+		defer h.unsubscribe(client)
+
+		header := c.Writer.Header()
+		header.Set(headerContentType, contentTypeEventStream)
+		header.Set(headerCacheControl, "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		if h.config.Retry > 0 {
+			fmt.Fprintf(c.Writer, "retry: %d\n\n", h.config.Retry.Milliseconds())
+		}
+
+		for _, event := range missed {
+			writeSSEEvent(c.Writer, event)
+		}
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(h.config.HeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, open := <-client:
+				if !open {
+					return
+				}
+				writeSSEEvent(c.Writer, event)
+			case <-heartbeat.C:
+				if _, err := io.WriteString(c.Writer, ":\n\n"); err != nil {
+					return
+				}
+			case <-tracker.shuttingDown():
+				writeSSEEvent(c.Writer, SSEEvent{Event: SSEShutdownEvent})
+				c.Writer.Flush()
+
+				return
+			case <-c.Request.Context().Done():
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// sseLineBreaks normalises the line breaks in data and sseRemoveNewline stops them splitting a single line field.
+var (
+	sseLineBreaks    = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+	sseRemoveNewline = strings.NewReplacer("\r", "", "\n", "")
+)
+
+// writeSSEEvent writes an event in the text/event-stream format.
+func writeSSEEvent(w io.Writer, event SSEEvent) {
+	var builder strings.Builder
+	if event.ID != "" {
+		builder.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Event != "" {
+		builder.WriteString("event: " + sseRemoveNewline.Replace(event.Event) + "\n")
+	}
+
+	for _, line := range strings.Split(sseLineBreaks.Replace(event.Data), "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	if _, err := io.WriteString(w, builder.String()); err != nil {
+		logrus.Debugf("Unable to write event: %s", err)
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// readSSEEvent reads the fields of the next event, skipping comments.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	t.Helper()
+
+	fields := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(fields) > 0 {
+				return fields
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		name, value, _ := strings.Cut(line, ": ")
+		if existing, found := fields[name]; found {
+			value = existing + "\n" + value
+		}
+		fields[name] = value
+	}
+}
+
+func streamingService(t *testing.T, handler Handler) (*Service, string, <-chan error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Listener: listener, Handlers: []Handler{handler}, DisableLog: true, ShutdownTimeout: 10 * time.Second}
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc, listener.Addr().String(), runService(t, svc)
+}
+
+func TestSSEHubBroadcastResumeAndShutdown(t *testing.T) {
+	hub := NewSSEHub(SSEConfig{Retry: time.Second})
+	svc, addr, result := streamingService(t, Handler{Method: http.MethodGet, Path: "/events", SSE: hub})
+
+	hub.Publish("greeting", "first")
+	hub.Publish("", "second\nline")
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(headerLastEventID, "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get(headerContentType) != "text/event-stream" {
+		t.Errorf("Unexpected content type %s.", resp.Header.Get(headerContentType))
+	}
+
+	if opened := testutil.ToFloat64(openStreams.WithLabelValues(streamTypeSSE)); opened != 1 {
+		t.Errorf("Expected 1 open stream but got %v.", opened)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if retry := readSSEEvent(t, reader); retry["retry"] != "1000" {
+		t.Errorf("Unexpected retry %v.", retry)
+	}
+
+	resumed := readSSEEvent(t, reader)
+	if resumed["id"] != "2" || resumed["data"] != "second\nline" {
+		t.Errorf("Expected to resume after event 1 but got %v.", resumed)
+	}
+
+	id := hub.Publish("update", "third")
+	if live := readSSEEvent(t, reader); live["id"] != id || live["event"] != "update" || live["data"] != "third" {
+		t.Errorf("Unexpected live event %v.", live)
+	}
+
+	start := time.Now()
+	svc.Stop()
+
+	if shutdown := readSSEEvent(t, reader); shutdown["event"] != SSEShutdownEvent {
+		t.Errorf("Expected the shutdown event but got %v.", shutdown)
+	}
+
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Shutdown waited %s for the stream.", elapsed)
+	}
+
+	if opened := testutil.ToFloat64(openStreams.WithLabelValues(streamTypeSSE)); opened != 0 {
+		t.Errorf("Expected no open streams but got %v.", opened)
+	}
+
+	if hub.Clients() != 0 {
+		t.Errorf("Expected no clients but got %d.", hub.Clients())
+	}
+}
+
+func TestSSEHubHistorySize(t *testing.T) {
+	hub := NewSSEHub(SSEConfig{HistorySize: 2})
+	for range 5 {
+		hub.Publish("", "event")
+	}
+
+	client, missed := hub.subscribe("0")
+	defer hub.unsubscribe(client)
+
+	if len(missed) != 2 || missed[0].ID != "4" || missed[1].ID != "5" {
+		t.Errorf("Expected the last two events but got %v.", missed)
+	}
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	indexFile                 = "index.html"
+	defaultIndexCacheControl  = "no-cache"
+	headerCacheControl        = "Cache-Control"
+	headerETag                = "ETag"
+	staticFilePathParam       = "filepath"
+	etagHashLength            = 16
+	defaultStaticCacheControl = "public, max-age=3600"
+)
+
+var (
+	errStaticAssetsNoFS        = errors.New("static assets config has no file system")
+	errStaticAssetsRootClashes = errors.New("only one set of static assets can be mounted at the root")
+)
+
+// precompressedExtensions maps an encoding to the file extension of its precompressed variant.
+var precompressedExtensions = map[string]string{
+	EncodingBrotli: ".br",
+	EncodingZstd:   ".zst",
+	EncodingGzip:   ".gz",
+}
+
+// StaticAssetsConfig specifies a file system which will be served at a path prefix.
+type StaticAssetsConfig struct {
+	Prefix             string              // The path prefix the files are served at e.g. /ui. Empty or / serves the root.
+	FS                 fs.FS               // The file system to serve e.g. an embed.FS. Use fs.Sub to serve a subdirectory.
+	Group              string              // Optional - specify a group. Not applied to assets mounted at the root.
+	CacheControl       string              // Optional - Cache-Control for files. Default is public, max-age=3600.
+	CachePolicies      []StaticCachePolicy // Optional - Cache-Control overrides for files. The first match wins.
+	IndexCacheControl  string              // Optional - Cache-Control for index.html and the SPA fallback. Default no-cache.
+	Precompressed      bool                // If true, .br, .zst and .gz variants of a file are served when accepted.
+	DirectoryListing   bool                // If true, directories without an index.html are listed.
+	SPAFallback        bool                // If true, unknown paths without a file extension are served index.html.
+	SPAExcludePrefixes []string            // Optional - whole segment prefixes e.g. /api never given the SPA fallback.
+	ModTime            time.Time           // Optional - Last-Modified for files without a time e.g. embed.FS. Default start up.
+}
+
+// StaticCachePolicy sets the Cache-Control for files matching a pattern.
+type StaticCachePolicy struct {
+	Pattern      string // A path.Match pattern matched against the file name e.g. *.js or assets/*.
+	CacheControl string // The Cache-Control header value to set.
+}
+
+// staticAssets serves the files for a single StaticAssetsConfig.
+type staticAssets struct {
+	config  *StaticAssetsConfig
+	prefix  string
+	modTime time.Time
+	etags   sync.Map
+}
+
+func newStaticAssets(config *StaticAssetsConfig) *staticAssets {
+	assets := &staticAssets{config: config, prefix: strings.TrimSuffix(config.Prefix, "/"), modTime: config.ModTime}
+	if assets.modTime.IsZero() {
+		assets.modTime = time.Now()
+	}
+
+	return assets
+}
+
+// isRoot reports whether the assets are mounted at the root of the service.
+func (s *staticAssets) isRoot() bool {
+	return s.prefix == ""
+}
+
+// handler returns the handler serving the assets.
+func (s *staticAssets) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			return
+		}
+
+		name := c.Param(staticFilePathParam)
+		if s.isRoot() {
+			name = c.Request.URL.Path
+		}
+
+		s.serve(c, name)
+	}
+}
+
+// serve writes the named file, a directory listing or the SPA fallback, otherwise it sets a not found status.
+func (s *staticAssets) serve(c *gin.Context, requestPath string) {
+	name := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	if !fs.ValidPath(name) {
+		c.Status(http.StatusNotFound)
+
+		return
+	}
+
+	info, err := fs.Stat(s.config.FS, name)
+	if err == nil && info.IsDir() {
+		indexName := path.Join(name, indexFile)
+		if _, indexErr := fs.Stat(s.config.FS, indexName); indexErr == nil {
+			s.serveFile(c, indexName, true)
+
+			return
+		}
+
+		if s.config.DirectoryListing {
+			s.serveListing(c, name)
+
+			return
+		}
+
+		c.Status(http.StatusNotFound)
+
+		return
+	}
+
+	if err == nil {
+		s.serveFile(c, name, name == indexFile || strings.HasSuffix(name, "/"+indexFile))
+
+		return
+	}
+
+	if s.useSPAFallback(c.Request.URL.Path, name) {
+		s.serveFile(c, indexFile, true)
+
+		return
+	}
+
+	c.Status(http.StatusNotFound)
+}
+
+// useSPAFallback reports whether index.html should be returned in place of a file which does not exist.
+func (s *staticAssets) useSPAFallback(requestPath string, name string) bool {
+	if !s.config.SPAFallback || path.Ext(name) != "" {
+		return false
+	}
+
+	for _, excluded := range s.config.SPAExcludePrefixes {
+		if hasPathPrefix(requestPath, excluded) {
+			return false
+		}
+	}
+
+	_, err := fs.Stat(s.config.FS, indexFile)
+
+	return err == nil
+}
+
+// hasPathPrefix reports whether the path starts with the prefix's whole segments e.g. /api matches /api and /api/users
+// but not /apidocs.
+func hasPathPrefix(requestPath string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return prefix == "" || requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+}
+
+// serveFile serves a file, or an accepted precompressed variant of it, with validators and caching headers.
+func (s *staticAssets) serveFile(c *gin.Context, name string, isIndex bool) {
+	servedName := name
+	header := c.Writer.Header()
+
+	if s.config.Precompressed {
+		header.Add(headerVary, headerAcceptEncoding)
+		if encoding := s.negotiatePrecompressed(c.GetHeader(headerAcceptEncoding), name); encoding != "" {
+			servedName = name + precompressedExtensions[encoding]
+			header.Set(headerContentEncoding, encoding)
+		}
+	}
+
+	content, err := fs.ReadFile(s.config.FS, servedName)
+	if err != nil {
+		header.Del(headerContentEncoding)
+		c.Status(http.StatusNotFound)
+
+		return
+	}
+
+	modTime := s.modTime
+	if info, statErr := fs.Stat(s.config.FS, servedName); statErr == nil && !info.ModTime().IsZero() {
+		modTime = info.ModTime()
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set(headerContentType, contentType)
+	}
+	header.Set(headerETag, s.etag(servedName, content))
+	header.Set(headerCacheControl, s.cacheControl(name, isIndex))
+
+	http.ServeContent(c.Writer, c.Request, name, modTime, bytes.NewReader(content))
+}
+
+// negotiatePrecompressed returns the accepted encoding with a precompressed variant of the file available.
+func (s *staticAssets) negotiatePrecompressed(acceptEncoding string, name string) string {
+	available := make([]string, 0, len(precompressedExtensions))
+	for _, encoding := range defaultCompressionEncodings {
+		if _, err := fs.Stat(s.config.FS, name+precompressedExtensions[encoding]); err == nil {
+			available = append(available, encoding)
+		}
+	}
+
+	return negotiateEncoding(acceptEncoding, available)
+}
+
+// etag returns a strong ETag for the file content. ETags are cached as the file systems served are read only.
+func (s *staticAssets) etag(name string, content []byte) string {
+	if etag, ok := s.etags.Load(name); ok {
+		if value, isString := etag.(string); isString {
+			return value
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	etag := fmt.Sprintf("%q", hex.EncodeToString(sum[:])[:etagHashLength])
+	s.etags.Store(name, etag)
+
+	return etag
+}
+
+func (s *staticAssets) cacheControl(name string, isIndex bool) string {
+	if isIndex {
+		if s.config.IndexCacheControl != "" {
+			return s.config.IndexCacheControl
+		}
+
+		return defaultIndexCacheControl
+	}
+
+	for _, policy := range s.config.CachePolicies {
+		if matched, err := path.Match(policy.Pattern, name); err == nil && matched {
+			return policy.CacheControl
+		}
+	}
+
+	if s.config.CacheControl != "" {
+		return s.config.CacheControl
+	}
+
+	return defaultStaticCacheControl
+}
+
+// serveListing writes a simple HTML listing of a directory.
+func (s *staticAssets) serveListing(c *gin.Context, name string) {
+	entries, err := fs.ReadDir(s.config.FS, name)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	var listing strings.Builder
+	listing.WriteString("<!doctype html>\n<pre>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		fmt.Fprintf(&listing, "<a href=\"%s\">%s</a>\n", html.EscapeString(entryName), html.EscapeString(entryName))
+	}
+	listing.WriteString("</pre>\n")
+
+	c.Header(headerCacheControl, defaultIndexCacheControl)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(listing.String()))
+}
+
+func setupStaticAssets(configs []StaticAssetsConfig, engine *gin.Engine) error {
+	var rootHandler gin.HandlerFunc
+	for i := range configs {
+		config := &configs[i]
+		if config.FS == nil {
+			return fmt.Errorf("%w: %s", errStaticAssetsNoFS, config.Prefix)
+		}
+
+		assets := newStaticAssets(config)
+		if assets.isRoot() {
+			if rootHandler != nil {
+				return errStaticAssetsRootClashes
+			}
+			rootHandler = assets.handler()
+
+			continue
+		}
+
+		group := getRouterGroup(engine, config.Group)
+		route := assets.prefix + "/*" + staticFilePathParam
+		if err := registerStaticRoute(group, route, assets.handler()); err != nil {
+			return err
+		}
+	}
+
+	// Assets at the root are only served where no route matched so that they never shadow any registered handlers.
+	if rootHandler != nil {
+		engine.NoRoute(rootHandler)
+	}
+
+	return nil
+}
+
+// registerStaticRoute adds the GET and HEAD routes, converting a clash with another route into an error.
+func registerStaticRoute(group *gin.RouterGroup, route string, handler gin.HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w, error caught: %v", errRecoveredFromPanic, r)
+		}
+	}()
+
+	handleRoute(group, http.MethodGet, route, handler)
+	handleRoute(group, http.MethodHead, route, handler)
+
+	return nil
+}
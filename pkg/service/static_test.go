@@ -0,0 +1,204 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testAssetsFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":        {Data: []byte("<html>index</html>")},
+		"app.js":            {Data: []byte("console.log('app')")},
+		"app.js.gz":         {Data: []byte("gzipped app")},
+		"assets/logo.svg":   {Data: []byte("<svg></svg>")},
+		"docs/readme.txt":   {Data: []byte("readme")},
+		"docs/other/a.json": {Data: []byte("{}")},
+	}
+}
+
+func TestStaticAssetsServesFiles(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		StaticAssets: []StaticAssetsConfig{{
+			Prefix:        "/ui",
+			FS:            testAssetsFS(),
+			CachePolicies: []StaticCachePolicy{{Pattern: "assets/*", CacheControl: "public, max-age=31536000, immutable"}},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, "/ui/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "console.log('app')" {
+		t.Errorf("Unexpected response %d <%s>.", rr.Code, rr.Body.String())
+	}
+
+	if rr.Header().Get(headerETag) == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Error("Expected validators to be set.")
+	}
+
+	if rr.Header().Get(headerCacheControl) != defaultStaticCacheControl {
+		t.Errorf("Unexpected Cache-Control %s.", rr.Header().Get(headerCacheControl))
+	}
+
+	notModified, err := sendRequest(svc, http.MethodGet, "/ui/app.js", headers{Name: "If-None-Match", Value: rr.Header().Get(headerETag)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if notModified.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d but got %d.", http.StatusNotModified, notModified.Code)
+	}
+
+	logo, err := sendRequest(svc, http.MethodGet, "/ui/assets/logo.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logo.Header().Get(headerCacheControl) != "public, max-age=31536000, immutable" {
+		t.Errorf("Unexpected Cache-Control %s.", logo.Header().Get(headerCacheControl))
+	}
+
+	index, err := sendRequest(svc, http.MethodGet, "/ui/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if index.Body.String() != "<html>index</html>" || index.Header().Get(headerCacheControl) != defaultIndexCacheControl {
+		t.Errorf("Unexpected index response <%s> %s.", index.Body.String(), index.Header().Get(headerCacheControl))
+	}
+
+	for _, path := range []string{"/ui/missing.js", "/ui/docs", "/ui/client/route"} {
+		rr, err := sendRequest(svc, http.MethodGet, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for %s but got %d.", http.StatusNotFound, path, rr.Code)
+		}
+	}
+}
+
+func TestStaticAssetsPrecompressed(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		StaticAssets:  []StaticAssetsConfig{{Prefix: "/ui", FS: testAssetsFS(), Precompressed: true}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, "/ui/app.js", headers{Name: headerAcceptEncoding, Value: "br, gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerContentEncoding) != EncodingGzip || rr.Body.String() != "gzipped app" {
+		t.Errorf("Expected the gzip variant but got %s <%s>.", rr.Header().Get(headerContentEncoding), rr.Body.String())
+	}
+
+	if !strings.HasPrefix(rr.Header().Get(headerContentType), "text/javascript") {
+		t.Errorf("Unexpected content type %s.", rr.Header().Get(headerContentType))
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, "/ui/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerContentEncoding) != "" || rr.Body.String() != "console.log('app')" {
+		t.Error("Expected the uncompressed file.")
+	}
+}
+
+func TestStaticAssetsDirectoryListing(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		StaticAssets:  []StaticAssetsConfig{{Prefix: "/files", FS: testAssetsFS(), DirectoryListing: true}},
+	}
+
+	rr, err := checkResponseCode(http.MethodGet, "/files/docs/", cfg, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rr.Body.String(), "readme.txt") || !strings.Contains(rr.Body.String(), "other/") {
+		t.Errorf("Unexpected listing <%s>.", rr.Body.String())
+	}
+}
+
+func TestStaticAssetsSPAFallbackAtRoot(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: "/api/hello"}},
+		StaticAssets: []StaticAssetsConfig{{
+			FS:                 testAssetsFS(),
+			SPAFallback:        true,
+			SPAExcludePrefixes: []string{"/api", "/admin/"},
+		}},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{path: "/api/hello", code: http.StatusOK, body: "Hello World."},
+		{path: "/app.js", code: http.StatusOK, body: "console.log('app')"},
+		{path: "/client/route", code: http.StatusOK, body: "<html>index</html>"},
+		{path: "/api/unknown", code: http.StatusNotFound},
+		{path: "/api", code: http.StatusNotFound},
+		{path: "/admin", code: http.StatusNotFound},
+		{path: "/apidocs", code: http.StatusOK, body: "<html>index</html>"},
+		{path: "/administrators", code: http.StatusOK, body: "<html>index</html>"},
+		{path: "/missing.css", code: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rr, err := sendRequest(svc, http.MethodGet, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != test.code {
+			t.Errorf("Expected status %d for %s but got %d.", test.code, test.path, rr.Code)
+		}
+
+		if test.body != "" && rr.Body.String() != test.body {
+			t.Errorf("Unexpected body for %s: got <%s> want <%s>", test.path, rr.Body.String(), test.body)
+		}
+	}
+}
+
+func TestStaticAssetsWithoutFSErrors(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		StaticAssets:  []StaticAssetsConfig{{Prefix: "/ui"}},
+	}
+
+	_, err := NewService(&cfg)
+	if err == nil {
+		t.Error("Static assets without a file system should cause error.")
+	}
+}
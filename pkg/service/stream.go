@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+const (
+	streamTypeSSE       = "sse"
+	streamTypeWebSocket = "websocket"
+)
+
+var errStreamsOpen = errors.New("timed out waiting for streams to close")
+
+// streams keeps track of the open SSE and WebSocket streams so that they can be told to close when the service shuts
+// down. http.Server.Shutdown does not do this as it waits for requests to finish and ignores hijacked connections.
+type streams struct {
+	closing   chan struct{}
+	closeOnce sync.Once
+	open      sync.WaitGroup
+	mu        sync.Mutex
+	closed    bool
+}
+
+func newStreams() *streams {
+	return &streams{closing: make(chan struct{})}
+}
+
+// add registers a new stream, returning false if the service is already shutting down. The returned function must be
+// called when the stream closes.
+func (s *streams) add(streamType string) (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, false
+	}
+
+	s.open.Add(1)
+	openStreams.WithLabelValues(streamType).Inc()
+
+	var doneOnce sync.Once
+
+	return func() {
+		doneOnce.Do(func() {
+			openStreams.WithLabelValues(streamType).Dec()
+			s.open.Done()
+		})
+	}, true
+}
+
+// shuttingDown returns a channel which is closed when the streams should close.
+func (s *streams) shuttingDown() <-chan struct{} {
+	return s.closing
+}
+
+// close tells the open streams to close and waits for them to do so.
+func (s *streams) close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		close(s.closing)
+	})
+
+	if !waitWithContext(ctx, s.open.Wait) {
+		return errStreamsOpen
+	}
+
+	return nil
+}
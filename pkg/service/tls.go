@@ -0,0 +1,186 @@
+package service
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TLSProfileModern only allows TLS 1.3, for clients which all support it.
+	TLSProfileModern = "modern"
+	// TLSProfileIntermediate allows TLS 1.2 with forward secret AEAD cipher suites as well as TLS 1.3.
+	TLSProfileIntermediate = "intermediate"
+	// TLSProfileFIPS only allows the NIST curves and, with TLS 1.2, the FIPS 140 approved AES-GCM cipher suites. TLS 1.3
+	// is allowed too with Go's own choice of cipher suites, which cannot be configured, unless run with
+	// GODEBUG=fips140=on to restrict them to the approved ones as well.
+	TLSProfileFIPS = "fips"
+
+	tlsHandshakeErrorPrefix = "http: TLS handshake error from "
+	pemBlockCertificate     = "CERTIFICATE"
+)
+
+var (
+	errInvalidTLSProfile = errors.New("invalid TLS profile")
+	errNoChainInFile     = errors.New("no certificates found in TLS chain file")
+)
+
+// tlsALPN are the protocols offered by every profile.
+var tlsALPN = []string{"h2", "http/1.1"}
+
+// tlsProfile is the versions, cipher suites and curves of a profile. Go chooses the TLS 1.3 cipher suites itself.
+type tlsProfile struct {
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+var tlsProfiles = map[string]tlsProfile{
+	TLSProfileModern: {
+		minVersion: tls.VersionTLS13,
+		curves:     []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	TLSProfileIntermediate: {
+		minVersion: tls.VersionTLS12,
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		curves: []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	TLSProfileFIPS: {
+		minVersion: tls.VersionTLS12,
+		maxVersion: tls.VersionTLS13,
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+		curves: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+	},
+}
+
+// SNICertificate is a further certificate, served to the clients asking for a server name it is valid for.
+type SNICertificate struct {
+	CertificateFile string           // The certificate, optionally followed by its intermediates.
+	KeyFile         string           // The private key.
+	ChainFile       string           // Optional - the intermediates if they are not in the certificate file.
+	Certificate     *tls.Certificate // Optional - a certificate to use in place of the files.
+}
+
+// certificates returns the certificates to serve, the default first followed by those chosen by SNI.
+func (c *ServerCertificateConfig) certificates() []SNICertificate {
+	return append([]SNICertificate{{
+		CertificateFile: c.CertificateFile,
+		KeyFile:         c.KeyFile,
+		ChainFile:       c.ChainFile,
+		Certificate:     c.Certificate,
+	}}, c.SNICertificates...)
+}
+
+// load returns the certificate followed by its intermediates.
+func (s SNICertificate) load() (tls.Certificate, error) {
+	var cert tls.Certificate
+	if s.Certificate != nil {
+		cert = *s.Certificate
+		cert.Certificate = slices.Clone(cert.Certificate)
+	} else {
+		var err error
+		cert, err = tls.LoadX509KeyPair(s.CertificateFile, s.KeyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%w", err)
+		}
+	}
+
+	if s.ChainFile == "" {
+		return cert, nil
+	}
+
+	chainPEM, err := os.ReadFile(filepath.Clean(s.ChainFile))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to read TLS chain file: %w", err)
+	}
+
+	chained := len(cert.Certificate)
+	for block, rest := pem.Decode(chainPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == pemBlockCertificate {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+
+	if len(cert.Certificate) == chained {
+		return tls.Certificate{}, fmt.Errorf("%w: %s", errNoChainInFile, s.ChainFile)
+	}
+
+	return cert, nil
+}
+
+// serverTLSConfig returns the TLS config of the profile serving the certificates. A client's SNI chooses the first
+// certificate valid for the name it asks for, the default certificate is served otherwise.
+func serverTLSConfig(config *ServerCertificateConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientCAs:  config.ClientCAs,
+		ClientAuth: config.ClientAuth,
+		NextProtos: slices.Clone(tlsALPN),
+	}
+
+	if config.Profile != "" {
+		profile, found := tlsProfiles[config.Profile]
+		if !found {
+			return nil, fmt.Errorf("%w: %s", errInvalidTLSProfile, config.Profile)
+		}
+
+		tlsConfig.MinVersion = profile.minVersion
+		tlsConfig.MaxVersion = profile.maxVersion
+		tlsConfig.CipherSuites = profile.cipherSuites
+		tlsConfig.CurvePreferences = profile.curves
+	}
+
+	for _, certificate := range config.certificates() {
+		cert, err := certificate.load()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+
+	return tlsConfig, nil
+}
+
+// serverErrorLog returns the log for the errors of the HTTP server. TLS handshake failures are logged as warnings
+// with the client and the reason, anything else as an error.
+func serverErrorLog() *stdlog.Logger {
+	return stdlog.New(serverErrorWriter{}, "", 0)
+}
+
+type serverErrorWriter struct{}
+
+func (serverErrorWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+	if failure, ok := strings.CutPrefix(message, tlsHandshakeErrorPrefix); ok {
+		client, reason, _ := strings.Cut(failure, ": ")
+		logrus.WithFields(logrus.Fields{"client": client, "reason": reason}).
+			Warnf("TLS handshake with %s failed: %s", client, reason)
+
+		return len(p), nil
+	}
+
+	logrus.Error(message)
+
+	return len(p), nil
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/go-libs/pkg/certificate"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// writeCertificate writes a certificate for the host signed by the CA, returning its certificate and key files.
+func writeCertificate(t *testing.T, ca *certificate.KeyPair, host string) (string, string) {
+	t.Helper()
+
+	pair, err := certificate.GenerateSignedCert(ca, certificate.HostNames{host}, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for file, data := range map[string][]byte{certFile: pair.Certificate, keyFile: pair.PrivateKey} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSSNICertificatesAndChain(t *testing.T) {
+	ca, err := certificate.GenerateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chainFile := filepath.Join(t.TempDir(), "chain.pem")
+	if err := os.WriteFile(chainFile, ca.Certificate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultCert, defaultKey := writeCertificate(t, ca, "127.0.0.1")
+	apiCert, apiKey := writeCertificate(t, ca, "api.example.com")
+	listener := testListener(t)
+	cfg := Config{
+		Listener:   listener,
+		DisableLog: true,
+		Handlers:   []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		CertConfig: &ServerCertificateConfig{
+			CertificateFile: defaultCert,
+			KeyFile:         defaultKey,
+			Profile:         TLSProfileIntermediate,
+			SNICertificates: []SNICertificate{{CertificateFile: apiCert, KeyFile: apiKey, ChainFile: chainFile}},
+		},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	for serverName, expected := range map[string]struct {
+		host  string
+		chain int
+	}{
+		"":                {host: "127.0.0.1", chain: 1},
+		"api.example.com": {host: "api.example.com", chain: 2},
+		"unknown.example": {host: "127.0.0.1", chain: 1},
+	} {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         serverName,
+			NextProtos:         []string{"h2"},
+			InsecureSkipVerify: true, //nolint:gosec // the certificate served is what is being tested
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state := conn.ConnectionState()
+		conn.Close()
+
+		if leaf := state.PeerCertificates[0]; leaf.Subject.CommonName != expected.host {
+			t.Errorf("Expected SNI %q to be served the %s certificate but got %s.", serverName, expected.host,
+				leaf.Subject.CommonName)
+		}
+
+		if len(state.PeerCertificates) != expected.chain {
+			t.Errorf("Expected SNI %q to be served %d certificates but got %d.", serverName, expected.chain,
+				len(state.PeerCertificates))
+		}
+
+		if state.NegotiatedProtocol != "h2" {
+			t.Errorf("Expected h2 to be negotiated but got %q.", state.NegotiatedProtocol)
+		}
+	}
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+}
+
+func TestTLSProfileRejectsOldClientsAndLogsFailure(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(hook.Reset)
+
+	cert, _ := testCertificate(t)
+	listener := testListener(t)
+	cfg := Config{
+		Listener:   listener,
+		DisableLog: true,
+		Handlers:   []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		CertConfig: &ServerCertificateConfig{Certificate: cert, Profile: TLSProfileModern},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runService(t, svc)
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		MaxVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, //nolint:gosec // the handshake is expected to fail
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("Expected the modern profile to reject a TLS 1.2 client.")
+	}
+
+	logged := func() bool {
+		return slices.ContainsFunc(hook.AllEntries(), func(entry *logrus.Entry) bool {
+			reason, _ := entry.Data["reason"].(string)
+
+			return entry.Level == logrus.WarnLevel && entry.Data["client"] != nil && strings.Contains(reason, "version")
+		})
+	}
+
+	for deadline := time.Now().Add(time.Second); !logged() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !logged() {
+		t.Errorf("Expected the handshake failure to be logged with its reason but got %v.", hook.AllEntries())
+	}
+
+	svc.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+}
+
+func TestServerTLSConfigProfiles(t *testing.T) {
+	cert, _ := testCertificate(t)
+	for profile, minVersion := range map[string]uint16{
+		"":                     tls.VersionTLS12,
+		TLSProfileModern:       tls.VersionTLS13,
+		TLSProfileIntermediate: tls.VersionTLS12,
+		TLSProfileFIPS:         tls.VersionTLS12,
+	} {
+		config, err := serverTLSConfig(&ServerCertificateConfig{Certificate: cert, Profile: profile})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.MinVersion != minVersion {
+			t.Errorf("Expected profile %q to have minimum version %x but got %x.", profile, minVersion, config.MinVersion)
+		}
+	}
+
+	config, err := serverTLSConfig(&ServerCertificateConfig{Certificate: cert, Profile: TLSProfileFIPS})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(config.CurvePreferences, tls.X25519) ||
+		slices.Contains(config.CipherSuites, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256) {
+		t.Error("Expected the FIPS profile to only allow approved curves and cipher suites.")
+	}
+
+	_, err = serverTLSConfig(&ServerCertificateConfig{Certificate: cert, Profile: "legacy"})
+	if !errors.Is(err, errInvalidTLSProfile) {
+		t.Errorf("Expected %q but got %v.", errInvalidTLSProfile, err)
+	}
+}
+
+func TestValidateTLSConfig(t *testing.T) {
+	cert, _ := testCertificate(t)
+	emptyChain := filepath.Join(t.TempDir(), "chain.pem")
+	if err := os.WriteFile(emptyChain, []byte("no certificates"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		CertConfig: &ServerCertificateConfig{
+			Certificate:     cert,
+			ChainFile:       emptyChain,
+			Profile:         "legacy",
+			SNICertificates: []SNICertificate{{CertificateFile: "missing.pem"}},
+		},
+	}
+
+	err := cfg.Validate()
+	for field, target := range map[string]error{
+		"CertConfig.Profile": errInvalidTLSProfile,
+		"CertConfig":         errNoChainInFile,
+		"CertConfig.SNICertificates[0].CertificateFile": errUnreadableFile,
+		"CertConfig.SNICertificates[0].KeyFile":         errFieldRequired,
+	} {
+		if !errors.Is(err, target) || !strings.Contains(err.Error(), field+": ") {
+			t.Errorf("Expected %s to be %q but got %v.", field, target, err)
+		}
+	}
+}
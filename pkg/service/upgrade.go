@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	upgradeListenFDs           = "SERVICE_UPGRADE_LISTEN_FDS"
+	upgradeListenFDNames       = "SERVICE_UPGRADE_LISTEN_FDNAMES"
+	upgradeReadyFD             = "SERVICE_UPGRADE_READY_FD"
+	defaultUpgradeReadyTimeout = 30 * time.Second
+	httpListenerName           = "http"
+)
+
+var (
+	errUpgradeUnsupported    = errors.New("upgrade is only supported on linux")
+	errUpgradeChildExited    = errors.New("upgraded process exited before it was ready")
+	errUpgradeTimedOut       = errors.New("timed out waiting for the upgraded process to be ready")
+	errListenerNotHandedOver = errors.New("listener does not support being handed over")
+)
+
+// UpgradeConfig enables zero downtime upgrades. On the signal the listeners are handed over to a newly started copy of
+// the executable and once it reports that it is ready this process drains its connections and exits. Linux only.
+type UpgradeConfig struct {
+	Signal       os.Signal     // Optional - the signal which triggers an upgrade. Default is SIGUSR2.
+	ReadyTimeout time.Duration // Optional - how long to wait for the new process to be ready. Default is 30 seconds.
+	Executable   string        // Optional - the executable to start. Default is the path of the running executable.
+	Args         []string      // Optional - the arguments passed to the new process. Default is the current arguments.
+}
+
+// handoverListeners returns the listeners which are handed over to the new process during an upgrade.
+func (s *Service) handoverListeners() []namedListener {
+	var listeners []namedListener
+	if s.listener != nil {
+		listeners = append(listeners, namedListener{Listener: s.listener, name: httpListenerName})
+	}
+
+	if s.plainListener != nil {
+		listeners = append(listeners, namedListener{Listener: s.plainListener, name: plainListenerName})
+	}
+
+	if s.grpcListener != nil {
+		listeners = append(listeners, namedListener{Listener: s.grpcListener, name: grpcListenerName})
+	}
+
+	return listeners
+}
+
+// inheritedListener returns the named listener handed over by the previous process during an upgrade. The boolean is
+// false if the service was not started by an upgrade.
+func (s *Service) inheritedListener(name string) (net.Listener, bool, error) {
+	if s.inherited == nil {
+		inherited, err := upgradeListeners()
+		if err != nil {
+			return nil, false, err
+		}
+
+		if inherited == nil {
+			return nil, false, nil
+		}
+		s.inherited = inherited
+	}
+
+	listener, err := selectListener(s.inherited, name)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return listener, true, nil
+}
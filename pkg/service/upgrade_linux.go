@@ -0,0 +1,236 @@
+//go:build linux
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// upgradeSupported reports whether zero downtime upgrades are available on this platform.
+const upgradeSupported = true
+
+// upgradeSignal returns the signal which triggers an upgrade or nil if upgrades are not enabled.
+func (s *Service) upgradeSignal() os.Signal {
+	if s.config.Upgrade == nil {
+		return nil
+	}
+
+	if s.config.Upgrade.Signal != nil {
+		return s.config.Upgrade.Signal
+	}
+
+	return syscall.SIGUSR2
+}
+
+// upgrade starts a new copy of the executable, handing over the listeners, and waits for it to report that it is
+// ready. On failure the new process is killed and this process carries on serving.
+func (s *Service) upgrade() error {
+	cfg := s.config.Upgrade
+
+	executable := cfg.Executable
+	if executable == "" {
+		var err error
+		if executable, err = os.Executable(); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	args := cfg.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+
+	listeners := s.handoverListeners()
+	files := make([]*os.File, 0, len(listeners)+1)
+	names := make([]string, 0, len(listeners))
+	defer func() {
+		for _, file := range files {
+			if err := file.Close(); err != nil {
+				logrus.Warnf("Unable to close handed over file %s: %s", file.Name(), err)
+			}
+		}
+	}()
+
+	for _, listener := range listeners {
+		file, err := listenerFile(listener.Listener)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		names = append(names, listener.name)
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer readyReader.Close()
+
+	// Extra files start at file descriptor 3 in the new process, the ready pipe comes after the listeners.
+	cmd := exec.Command(executable, args...) //#nosec G204 the executable is this service or explicitly configured.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), readyWriter)
+	cmd.Env = append(upgradeEnviron(),
+		fmt.Sprintf("%s=%d", upgradeListenFDs, len(files)),
+		fmt.Sprintf("%s=%s", upgradeListenFDNames, strings.Join(names, ":")),
+		fmt.Sprintf("%s=%d", upgradeReadyFD, systemdListenFDsStart+len(files)),
+	)
+
+	err = cmd.Start()
+	closeErr := readyWriter.Close()
+	if closeErr != nil {
+		logrus.Warnf("Unable to close upgrade ready pipe: %s", closeErr)
+	}
+
+	if err != nil {
+		setUnlinkOnClose(listeners, true)
+
+		return fmt.Errorf("unable to start upgraded process: %w", err)
+	}
+
+	timeout := cfg.ReadyTimeout
+	if timeout <= 0 {
+		timeout = defaultUpgradeReadyTimeout
+	}
+
+	if err := waitForReady(readyReader, timeout); err != nil {
+		setUnlinkOnClose(listeners, true)
+		if killErr := cmd.Process.Kill(); killErr != nil {
+			logrus.Warnf("Unable to kill upgraded process %d: %s", cmd.Process.Pid, killErr)
+		}
+		_ = cmd.Wait() //nolint:errcheck // the process has been killed so the error is expected.
+
+		return err
+	}
+
+	logrus.Infof("Upgraded process %d is ready.", cmd.Process.Pid)
+	if err := cmd.Process.Release(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// listenerFile returns a duplicate of the listener's file descriptor. Unix socket files are left in place when the
+// listener is closed as they are now in use by the new process.
+func listenerFile(listener net.Listener) (*os.File, error) {
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+
+	filer, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errListenerNotHandedOver, listener.Addr())
+	}
+
+	file, err := filer.File()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return file, nil
+}
+
+// setUnlinkOnClose restores the unix socket clean up after a failed upgrade.
+func setUnlinkOnClose(listeners []namedListener, unlink bool) {
+	for _, listener := range listeners {
+		if unixListener, ok := listener.Listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(unlink)
+		}
+	}
+}
+
+// waitForReady waits for the new process to write to the ready pipe. If the process exits the pipe is closed.
+func waitForReady(readyReader *os.File, timeout time.Duration) error {
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyReader.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			return errUpgradeChildExited
+		}
+
+		return nil
+	case <-time.After(timeout):
+		return errUpgradeTimedOut
+	}
+}
+
+// upgradeEnviron returns the environment without any upgrade variables from a previous upgrade.
+func upgradeEnviron() []string {
+	environ := os.Environ()
+	filtered := make([]string, 0, len(environ))
+	for _, env := range environ {
+		name, _, _ := strings.Cut(env, "=")
+		if name != upgradeListenFDs && name != upgradeListenFDNames && name != upgradeReadyFD {
+			filtered = append(filtered, env)
+		}
+	}
+
+	return filtered
+}
+
+// upgradeListeners returns the listeners handed over by the previous process, or nil if this process was not started
+// by an upgrade.
+func upgradeListeners() ([]namedListener, error) {
+	countEnv, found := os.LookupEnv(upgradeListenFDs)
+	if !found {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(countEnv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", upgradeListenFDs, err)
+	}
+
+	names := strings.Split(os.Getenv(upgradeListenFDNames), ":")
+	for _, env := range []string{upgradeListenFDs, upgradeListenFDNames} {
+		if err := os.Unsetenv(env); err != nil {
+			logrus.Warnf("Unable to unset %s: %s", env, err)
+		}
+	}
+
+	return inheritedListeners(systemdListenFDsStart, count, names)
+}
+
+// notifyUpgradeReady tells the previous process that this process is serving so that it can drain and exit.
+func notifyUpgradeReady() {
+	fdEnv, found := os.LookupEnv(upgradeReadyFD)
+	if !found {
+		return
+	}
+
+	if err := os.Unsetenv(upgradeReadyFD); err != nil {
+		logrus.Warnf("Unable to unset %s: %s", upgradeReadyFD, err)
+	}
+
+	fd, err := strconv.Atoi(fdEnv)
+	if err != nil {
+		logrus.Errorf("Invalid %s: %s", upgradeReadyFD, err)
+
+		return
+	}
+
+	ready := os.NewFile(uintptr(fd), "upgrade-ready")
+	if _, err := ready.Write([]byte{1}); err != nil {
+		logrus.Errorf("Unable to notify previous process of readiness: %s", err)
+	}
+
+	if err := ready.Close(); err != nil {
+		logrus.Warnf("Unable to close upgrade ready pipe: %s", err)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build linux
+
+package service
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const upgradeHelperEnv = "SERVICE_UPGRADE_HELPER_PROCESS"
+
+// TestUpgradeHelperProcess is not a real test. It is run as the upgraded process by TestUpgradeHandsOverListener.
+func TestUpgradeHelperProcess(t *testing.T) {
+	if os.Getenv(upgradeHelperEnv) != "1" {
+		return
+	}
+
+	done := make(chan struct{})
+	cfg := Config{
+		ListenAddress: ":0",
+		Handlers: []Handler{{Method: http.MethodGet, Path: testEndpoint, Handler: func(c *gin.Context) {
+			c.String(http.StatusOK, "upgraded")
+			close(done)
+		}}},
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	listener, err := svc.listen()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	go func() {
+		_ = svc.Serve(listener)
+	}()
+	notifyUpgradeReady()
+
+	select {
+	case <-done:
+		// Give the response time to be written.
+		time.Sleep(100 * time.Millisecond)
+	case <-time.After(10 * time.Second):
+	}
+	os.Exit(0)
+}
+
+func TestUpgradeHandsOverListener(t *testing.T) {
+	t.Setenv(upgradeHelperEnv, "1")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		Listener: listener,
+		Handlers: []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		Upgrade: &UpgradeConfig{
+			Executable:   os.Args[0],
+			Args:         []string{"-test.run=^TestUpgradeHelperProcess$"},
+			ReadyTimeout: 10 * time.Second,
+		},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.listener = listener
+
+	if err := svc.upgrade(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop serving in this process, the upgraded process owns a copy of the listener.
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + listener.Addr().String() + testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "upgraded" {
+		t.Errorf("Expected the upgraded process to respond but got <%s>.", string(body))
+	}
+}
+
+func TestUpgradeChildExits(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cfg := Config{
+		Listener: listener,
+		Handlers: []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		Upgrade:  &UpgradeConfig{Executable: "/bin/true", Args: []string{}, ReadyTimeout: 10 * time.Second},
+	}
+
+	svc, err := setupService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.listener = listener
+
+	if err := svc.upgrade(); !errors.Is(err, errUpgradeChildExited) {
+		t.Errorf("Expected %s but got %v.", errUpgradeChildExited, err)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package service
+
+import "os"
+
+// upgradeSupported reports whether zero downtime upgrades are available on this platform.
+const upgradeSupported = false
+
+// upgradeSignal always returns nil as upgrades are only supported on linux.
+func (s *Service) upgradeSignal() os.Signal {
+	return nil
+}
+
+// upgrade always errors as upgrades are only supported on linux.
+func (s *Service) upgrade() error {
+	return errUpgradeUnsupported
+}
+
+// upgradeListeners always returns nil as upgrades are only supported on linux.
+func upgradeListeners() ([]namedListener, error) {
+	return nil, nil
+}
+
+// notifyUpgradeReady does nothing as upgrades are only supported on linux.
+func notifyUpgradeReady() {}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const maxPort = 65535
+
+var (
+	errFieldRequired      = errors.New("must be set")
+	errNotPositive        = errors.New("must be greater than zero")
+	errUnknownGroup       = errors.New("group is not used by any handler")
+	errInvalidPath        = errors.New("path must begin with /")
+	errNoHandlerFunc      = errors.New("no handler function, HTTP handler, SSE hub or WebSocket config")
+	errMalformedAddress   = errors.New("malformed address")
+	errUnreadableFile     = errors.New("unreadable file")
+	errInvalidCertificate = errors.New("invalid certificate or key")
+)
+
+// ValidationError is a problem with a field of a Config, named by its path e.g. Handlers[1].RateLimitConfig.Within.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validation collects the problems with a config.
+type validation struct {
+	errs []error
+}
+
+func (v *validation) add(field string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, &ValidationError{Field: field, Err: err})
+	}
+}
+
+// Validate checks the config, returning all the problems joined together. Each is a *ValidationError naming the field
+// at fault. NewService calls Validate so it only needs calling directly to check a config without creating a service.
+func (c *Config) Validate() error {
+	v := &validation{}
+
+	c.validateListener(v)
+	c.validateHandlers(v)
+	c.validateGroups(v)
+	c.validateCertConfig(v)
+	c.validateRouter(v)
+	c.validateVersioning(v)
+	c.validateFaultInjection(v)
+	c.validateMaintenance(v)
+	c.validateLogLevelControl(v)
+
+	if c.RateLimit != nil {
+		validateRateLimit(v, "RateLimit", c.RateLimit.Limit, c.RateLimit.Within)
+	}
+
+	for i, limit := range c.RateLimits {
+		validateRateLimit(v, fmt.Sprintf("RateLimits[%d]", i), limit.Limit, limit.Within)
+	}
+
+	if c.Upgrade != nil && !upgradeSupported {
+		v.add("Upgrade", errUpgradeUnsupported)
+	}
+
+	if c.PlainHTTP != nil {
+		v.add("PlainHTTP", validatePlainHTTP(c))
+		if c.PlainHTTP.ListenAddress != "" {
+			v.add("PlainHTTP.ListenAddress", validateAddress(c.PlainHTTP.ListenAddress))
+		}
+	}
+
+	for i := range c.StaticAssets {
+		if c.StaticAssets[i].FS == nil {
+			v.add(fmt.Sprintf("StaticAssets[%d].FS", i), errFieldRequired)
+		}
+	}
+
+	for i := range c.ConcurrencyLimits {
+		_, err := newConcurrencyLimiter(&c.ConcurrencyLimits[i])
+		v.add(fmt.Sprintf("ConcurrencyLimits[%d]", i), err)
+	}
+
+	if c.GRPC != nil {
+		if len(c.GRPC.Register) == 0 {
+			v.add("GRPC.Register", errNoGRPCServices)
+		}
+
+		if c.GRPC.ListenAddress != "" {
+			v.add("GRPC.ListenAddress", validateAddress(c.GRPC.ListenAddress))
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateListener(v *validation) {
+	if c.Listener != nil || c.SocketActivation != nil {
+		return
+	}
+
+	if c.ListenAddress == "" {
+		v.add("ListenAddress", errInvalidListenAddress)
+
+		return
+	}
+
+	if socketPath, ok := strings.CutPrefix(c.ListenAddress, UnixAddressPrefix); ok {
+		if socketPath == "" {
+			v.add("ListenAddress", errInvalidUnixSocketPath)
+		}
+
+		return
+	}
+
+	v.add("ListenAddress", validateAddress(c.ListenAddress))
+}
+
+// validateAddress checks a TCP address is in the format [host]:port.
+func validateAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", errMalformedAddress, address, err)
+	}
+
+	if number, err := strconv.Atoi(port); err == nil {
+		if number < 0 || number > maxPort {
+			return fmt.Errorf("%w %q: port out of range", errMalformedAddress, address)
+		}
+
+		return nil
+	}
+
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("%w %q: %w", errMalformedAddress, address, err)
+	}
+
+	return nil
+}
+
+func (c *Config) validateHandlers(v *validation) {
+	if len(c.Handlers) == 0 {
+		v.add("Handlers", errNoHandlersRegisteredForService)
+
+		return
+	}
+
+	for i, handler := range c.Handlers {
+		field := fmt.Sprintf("Handlers[%d]", i)
+		if handler.Handler == nil && handler.HTTPHandler == nil && handler.SSE == nil && handler.WebSocket == nil {
+			v.add(field+".Handler", errNoHandlerFunc)
+		}
+
+		if limit := handler.RateLimitConfig; limit != nil {
+			validateRateLimit(v, field+".RateLimitConfig", limit.Limit, limit.Within)
+		}
+
+		if handler.IPFilter != nil {
+			_, err := newIPFilter(handler.IPFilter)
+			v.add(field+".IPFilter", err)
+		}
+	}
+}
+
+func validateRateLimit(v *validation, field string, limit uint64, within int) {
+	if limit == 0 {
+		v.add(field+".Limit", errNotPositive)
+	}
+
+	if within <= 0 {
+		v.add(field+".Within", errNotPositive)
+	}
+}
+
+// validateGroups checks every group given to middleware is used by a handler, as otherwise the middleware never runs.
+func (c *Config) validateGroups(v *validation) {
+	used := make(map[string]bool)
+	for _, handler := range c.Handlers {
+		used[handler.Group] = true
+	}
+
+	for _, assets := range c.StaticAssets {
+		used[assets.Group] = true
+	}
+
+	if c.LogLevelControl != nil {
+		used[c.LogLevelControl.Group] = true
+	}
+
+	if c.Version != nil {
+		used[c.Version.Group] = true
+	}
+
+	if c.FaultInjection != nil {
+		used[c.FaultInjection.Group] = true
+	}
+
+	if c.Maintenance != nil {
+		used[c.Maintenance.Group] = true
+	}
+
+	check := func(field string, groups []string) {
+		for i, group := range groups {
+			if group != "" && !used[group] {
+				v.add(fmt.Sprintf("%s.Groups[%d]", field, i), fmt.Errorf("%w: %s", errUnknownGroup, group))
+			}
+		}
+	}
+
+	for i, middleware := range c.MiddlewareHandlers {
+		if middleware.Handler == nil && middleware.Middleware == nil {
+			v.add(fmt.Sprintf("MiddlewareHandlers[%d].Handler", i), errNoHandlerFunc)
+		}
+		check(fmt.Sprintf("MiddlewareHandlers[%d]", i), middleware.Groups)
+	}
+
+	if c.ErrorHandler != nil {
+		check("ErrorHandler", c.ErrorHandler.Groups)
+	}
+
+	if c.Cors != nil {
+		check("Cors", c.Cors.Groups)
+	}
+
+	if c.RateLimit != nil {
+		check("RateLimit", c.RateLimit.Groups)
+	}
+
+	for i, limit := range c.RateLimits {
+		check(fmt.Sprintf("RateLimits[%d]", i), limit.Groups)
+	}
+
+	if c.Idempotency != nil {
+		check("Idempotency", c.Idempotency.Groups)
+	}
+
+	for i, filter := range c.IPFilters {
+		check(fmt.Sprintf("IPFilters[%d]", i), filter.Groups)
+	}
+
+	for i, headers := range c.SecurityHeaders {
+		check(fmt.Sprintf("SecurityHeaders[%d]", i), headers.Groups)
+	}
+
+	for i, limit := range c.ConcurrencyLimits {
+		check(fmt.Sprintf("ConcurrencyLimits[%d]", i), limit.Groups)
+	}
+}
+
+// validateCertConfig checks the profile and that each certificate's files can be read and hold a valid certificate,
+// key and chain.
+func (c *Config) validateCertConfig(v *validation) {
+	if c.CertConfig == nil {
+		return
+	}
+
+	if _, found := tlsProfiles[c.CertConfig.Profile]; c.CertConfig.Profile != "" && !found {
+		v.add("CertConfig.Profile", fmt.Errorf("%w: %s", errInvalidTLSProfile, c.CertConfig.Profile))
+	}
+
+	for i, certificate := range c.CertConfig.certificates() {
+		field := "CertConfig"
+		if i > 0 {
+			field = fmt.Sprintf("CertConfig.SNICertificates[%d]", i-1)
+		}
+
+		validateCertificate(v, field, certificate)
+	}
+}
+
+// validateCertificate checks the files of a certificate can be read and loaded.
+func validateCertificate(v *validation, field string, certificate SNICertificate) {
+	type file struct {
+		field, name string
+		required    bool
+	}
+
+	files := []file{{field: field + ".ChainFile", name: certificate.ChainFile}}
+	if certificate.Certificate == nil {
+		files = append(files, file{field: field + ".CertificateFile", name: certificate.CertificateFile, required: true},
+			file{field: field + ".KeyFile", name: certificate.KeyFile, required: true})
+	}
+
+	readable := true
+	for _, file := range files {
+		if file.name == "" {
+			if file.required {
+				v.add(file.field, errFieldRequired)
+				readable = false
+			}
+
+			continue
+		}
+
+		if _, err := os.ReadFile(file.name); err != nil {
+			v.add(file.field, fmt.Errorf("%w: %w", errUnreadableFile, err))
+			readable = false
+		}
+	}
+
+	if readable {
+		if _, err := certificate.load(); err != nil {
+			v.add(field, fmt.Errorf("%w: %w", errInvalidCertificate, err))
+		}
+	}
+}
+
+// validateRouter reports the features configured which only the gin router supports.
+func (c *Config) validateRouter(v *validation) {
+	switch c.Router.(type) {
+	case nil, *GinRouter:
+		return
+	}
+
+	unsupported := func(field string, configured bool) {
+		if configured {
+			v.add(field, errRouterUnsupported)
+		}
+	}
+
+	unsupported("Compression", c.Compression != nil)
+	unsupported("StaticAssets", len(c.StaticAssets) > 0)
+	unsupported("EnabledProfiler", c.EnabledProfiler)
+	unsupported("SecurityHeaders", len(c.SecurityHeaders) > 0)
+	unsupported("Idempotency", c.Idempotency != nil)
+	unsupported("ConcurrencyLimits", len(c.ConcurrencyLimits) > 0)
+
+	for i, handler := range c.Handlers {
+		field := fmt.Sprintf("Handlers[%d]", i)
+		unsupported(field+".SSE", handler.SSE != nil)
+		unsupported(field+".WebSocket", handler.WebSocket != nil)
+		unsupported(field+".Compression", handler.Compression != nil)
+		unsupported(field+".Cache", handler.Cache != nil)
+		unsupported(field+".Idempotency", handler.Idempotency != nil)
+	}
+}
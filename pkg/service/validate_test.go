@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidateValidConfig(t *testing.T) {
+	handler := Handler{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint, Group: "api"}
+	cfg := Config{
+		ListenAddress: "localhost:http",
+		Handlers:      []Handler{handler},
+		RateLimit:     &RateLimitConfig{Groups: []string{"api"}, Limit: 10, Within: 1},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the config to be valid but got %s.", err)
+	}
+}
+
+func TestValidateCollectsAllErrors(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":99999",
+		Handlers: []Handler{
+			{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint},
+			{Method: http.MethodGet, Path: "nested", RateLimitConfig: &HandlerRateLimitConfig{Limit: 1}},
+		},
+		MiddlewareHandlers: []MiddlewareHandler{{Handler: helloWorldHandler(), Groups: []string{"missing"}}},
+		CertConfig:         &ServerCertificateConfig{CertificateFile: "missing.crt", KeyFile: "missing.key"},
+		RateLimits:         []RateLimitConfig{{Groups: []string{"missing"}, Limit: 1}},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected the config to be invalid.")
+	}
+
+	expected := map[string]error{
+		"ListenAddress":                      errMalformedAddress,
+		"Handlers[1].Handler":                errNoHandlerFunc,
+		"Handlers[1].RateLimitConfig.Within": errNotPositive,
+		"MiddlewareHandlers[0].Groups[0]":    errUnknownGroup,
+		"RateLimits[0].Within":               errNotPositive,
+		"RateLimits[0].Groups[0]":            errUnknownGroup,
+		"CertConfig.CertificateFile":         errUnreadableFile,
+		"CertConfig.KeyFile":                 errUnreadableFile,
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error }) //nolint:errorlint // checking the joined errors
+	if !ok {
+		t.Fatalf("Expected the errors to be joined but got %T.", err)
+	}
+
+	found := make(map[string]error)
+	for _, e := range joined.Unwrap() {
+		var validationErr *ValidationError
+		if !errors.As(e, &validationErr) {
+			t.Fatalf("Expected a *ValidationError but got %T.", e)
+		}
+		found[validationErr.Field] = validationErr.Err
+	}
+
+	if len(found) != len(expected) {
+		t.Errorf("Expected %d errors but got %d: %s", len(expected), len(found), err)
+	}
+
+	for field, target := range expected {
+		if !errors.Is(found[field], target) {
+			t.Errorf("Expected %s to fail with %q but got %v.", field, target, found[field])
+		}
+	}
+}
+
+func TestValidateListenAddress(t *testing.T) {
+	for _, address := range []string{"localhost", ":99999", "localhost:nosuchservice", "[::1"} {
+		err := validateAddress(address)
+		if !errors.Is(err, errMalformedAddress) {
+			t.Errorf("Expected %q to be malformed but got %v.", address, err)
+		}
+	}
+
+	for _, address := range []string{":8080", "127.0.0.1:0", "[::1]:443", "localhost:https"} {
+		if err := validateAddress(address); err != nil {
+			t.Errorf("Expected %q to be valid but got %s.", address, err)
+		}
+	}
+}
+
+func TestNewServiceValidatesConfig(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		RateLimit:     &RateLimitConfig{Limit: 0, Within: 1},
+	}
+
+	_, err := NewService(&cfg)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "RateLimit.Limit" {
+		t.Errorf("Expected the invalid rate limit to be reported but got %v.", err)
+	}
+}
+
+func TestNewServiceServesRelativePathsFromTheRoot(t *testing.T) {
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			handlers := []Handler{{Method: http.MethodGet, HTTPHandler: userHandler(), Path: "test"}}
+			cfg := Config{ListenAddress: ":8888", Router: router, Handlers: handlers}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkBody(t, svc, "/test", http.StatusOK, "")
+
+			if cfg.Handlers[0].Path != "test" {
+				t.Errorf("Expected the config to be left as it was but the path is %q.", cfg.Handlers[0].Path)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/puppetlabs/go-libs/pkg/buildinfo"
+)
+
+// DefaultVersionPath is the default path of the version endpoint.
+const DefaultVersionPath = "/version"
+
+// VersionConfig enables an endpoint returning the build info of the service as JSON.
+type VersionConfig struct {
+	Path                string // Optional - the path of the endpoint. Default is /version.
+	Group               string // Optional - the group of the endpoint e.g. to protect it with auth middleware.
+	IncludeDependencies bool   // If true the versions of the modules the service was built with are included.
+}
+
+// versionHandler returns the build info, without the dependencies unless they are requested.
+func versionHandler(config *VersionConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info := buildinfo.Get()
+		if !config.IncludeDependencies {
+			info.Dependencies = nil
+		}
+
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+func setupVersion(config *VersionConfig, engine *gin.Engine) {
+	if config == nil {
+		return
+	}
+
+	path := config.Path
+	if path == "" {
+		path = DefaultVersionPath
+	}
+
+	handleRoute(getRouterGroup(engine, config.Group), http.MethodGet, path, versionHandler(config))
+}
+
+// recordBuildInfo sets the build info gauge. The labels are those of the running binary so the gauge is always 1.
+func recordBuildInfo() {
+	info := buildinfo.Get()
+	buildInfo.WithLabelValues(info.Version, info.Revision, info.BuildTime, strconv.FormatBool(info.Modified),
+		info.GoVersion).Set(1)
+}
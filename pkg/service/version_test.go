@@ -0,0 +1,58 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/puppetlabs/go-libs/pkg/buildinfo"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	buildinfo.Set("abc123", "2024-01-02T03:04:05Z")
+
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+		Version:       &VersionConfig{},
+		Metrics:       true,
+	}
+
+	rr, err := checkResponseCode(http.MethodGet, DefaultVersionPath, cfg, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info buildinfo.Info
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Revision != "abc123" || info.BuildTime != "2024-01-02T03:04:05Z" || info.GoVersion == "" {
+		t.Errorf("Unexpected build info %+v.", info)
+	}
+
+	if len(info.Dependencies) != 0 {
+		t.Error("Dependencies should only be included when requested.")
+	}
+
+	gauge := buildInfo.WithLabelValues(info.Version, info.Revision, info.BuildTime, strconv.FormatBool(info.Modified),
+		info.GoVersion)
+	if value := testutil.ToFloat64(gauge); value != 1 {
+		t.Errorf("Expected the build info gauge to be 1 but got %f.", value)
+	}
+}
+
+func TestVersionEndpointNotAddedByDefault(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Handler: helloWorldHandler(), Path: testEndpoint}},
+	}
+
+	_, err := checkResponseCode(http.MethodGet, DefaultVersionPath, cfg, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// VersionByPath routes requests by a path prefix e.g. /v2/users.
+	VersionByPath = "path"
+	// VersionByAccept routes requests by the version in the Accept header e.g. application/vnd.example.v2+json or
+	// application/json; version=2.
+	VersionByAccept = "accept"
+	// VersionByHeader routes requests by a header of their own e.g. API-Version: 2.
+	VersionByHeader = "header"
+	// DefaultVersionHeader is the header naming the version with VersionByHeader.
+	DefaultVersionHeader = "API-Version"
+
+	headerAccept      = "Accept"
+	headerDeprecation = "Deprecation"
+	headerSunset      = "Sunset"
+	headerLink        = "Link"
+
+	// versionedPathPrefix is where handlers routed by header are registered, requests for it directly are not found.
+	versionedPathPrefix = "/_version/"
+)
+
+var (
+	errInvalidVersionRouting = errors.New("invalid API version routing")
+	errInvalidAPIVersion     = errors.New("invalid API version")
+	errSunsetRequired        = errors.New("rejecting calls after the sunset needs a sunset date")
+)
+
+// acceptVersion matches the version in a vendor media type e.g. application/vnd.example.v2+json.
+var acceptVersion = regexp.MustCompile(`\.v(\d[^.+]*)(?:\+|$)`)
+
+// APIVersioningConfig specifies how requests are routed to the handlers with an APIVersion. Handlers without one are
+// served at their path whatever version a request names.
+type APIVersioningConfig struct {
+	Routing        string // Optional - VersionByPath, VersionByAccept or VersionByHeader. Default is VersionByPath.
+	Header         string // Optional - the header naming the version with VersionByHeader. Default is API-Version.
+	DefaultVersion string // Optional - the version of requests naming none. Default is the first declared for a path.
+}
+
+// DeprecationConfig marks a handler deprecated. Its responses carry the Deprecation, Sunset and Link headers and its
+// calls are logged and counted by the service_deprecated_calls_total metric.
+type DeprecationConfig struct {
+	Date              time.Time // Optional - when the handler was or will be deprecated. Default is true.
+	Sunset            time.Time // Optional - when the handler will be removed.
+	Link              string    // Optional - the documentation of the deprecation, a Link with rel="deprecation".
+	Successor         string    // Optional - the handler replacing it, a Link with rel="successor-version".
+	RejectAfterSunset bool      // If true, calls after the sunset are rejected with a 410 Gone.
+}
+
+// routing returns how requests are routed to versions.
+func (a *APIVersioningConfig) routing() string {
+	if a == nil || a.Routing == "" {
+		return VersionByPath
+	}
+
+	return a.Routing
+}
+
+// normaliseVersion returns the version without any v prefix e.g. v2 is 2.
+func normaliseVersion(version string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v")
+}
+
+// validateVersioning checks the versioning config and the handlers' versions and deprecations.
+func (c *Config) validateVersioning(v *validation) {
+	switch c.APIVersioning.routing() {
+	case VersionByPath, VersionByAccept, VersionByHeader:
+	default:
+		v.add("APIVersioning.Routing", fmt.Errorf("%w: %s", errInvalidVersionRouting, c.APIVersioning.Routing))
+	}
+
+	for i, handler := range c.Handlers {
+		field := fmt.Sprintf("Handlers[%d]", i)
+		if handler.APIVersion != "" {
+			version := normaliseVersion(handler.APIVersion)
+			if version == "" || strings.ContainsAny(version, "/ ") {
+				v.add(field+".APIVersion", fmt.Errorf("%w: %q", errInvalidAPIVersion, handler.APIVersion))
+			}
+		}
+
+		if handler.Deprecation != nil && handler.Deprecation.RejectAfterSunset && handler.Deprecation.Sunset.IsZero() {
+			v.add(field+".Deprecation.Sunset", errSunsetRequired)
+		}
+	}
+}
+
+// versionedHandlers returns the handlers with the paths they are registered on for their version and the middleware
+// restoring the requested path and signalling a deprecation.
+func versionedHandlers(cfg *Config) []Handler {
+	routing := cfg.APIVersioning.routing()
+	handlers := make([]Handler, 0, len(cfg.Handlers))
+	for _, handler := range cfg.Handlers {
+		var middleware []func(c *gin.Context)
+		if handler.Deprecation != nil {
+			middleware = append(middleware, deprecationHandler(handler))
+		}
+
+		if version := normaliseVersion(handler.APIVersion); version != "" {
+			if routing == VersionByPath {
+				handler.Path = joinPaths("/v"+version, handler.Path)
+			} else {
+				handler.Path = joinPaths(versionedPathPrefix+version, handler.Path)
+				middleware = append([]func(c *gin.Context){restoreVersionedPath}, middleware...)
+			}
+		}
+
+		if len(middleware) > 0 {
+			handler.Middleware = append(middleware, handler.Middleware...)
+		}
+		handlers = append(handlers, handler)
+	}
+
+	return handlers
+}
+
+// deprecationHandler returns the middleware signalling the handler is deprecated and rejecting calls after its sunset.
+func deprecationHandler(handler Handler) gin.HandlerFunc {
+	config := handler.Deprecation
+	deprecation := "true"
+	if !config.Date.IsZero() {
+		deprecation = "@" + strconv.FormatInt(config.Date.Unix(), 10)
+	}
+
+	var links []string
+	if config.Link != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, config.Link))
+	}
+
+	if config.Successor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="successor-version"`, config.Successor))
+	}
+
+	version := normaliseVersion(handler.APIVersion)
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set(headerDeprecation, deprecation)
+		if !config.Sunset.IsZero() {
+			header.Set(headerSunset, config.Sunset.UTC().Format(http.TimeFormat))
+		}
+
+		for _, link := range links {
+			header.Add(headerLink, link)
+		}
+
+		rejected := config.RejectAfterSunset && !time.Now().Before(config.Sunset)
+		deprecatedCalls.WithLabelValues(handler.Method, handler.Path, version, strconv.FormatBool(rejected)).Inc()
+
+		entry := logrus.WithFields(logrus.Fields{
+			"method":   c.Request.Method,
+			"path":     handler.Path,
+			"version":  version,
+			"clientIP": c.ClientIP(),
+		})
+		if rejected {
+			entry.Warnf("Rejecting call to %s %s as it is past its sunset.", c.Request.Method, handler.Path)
+			c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "this API has been removed"})
+
+			return
+		}
+
+		entry.Warnf("Deprecated %s %s called.", c.Request.Method, handler.Path)
+	}
+}
+
+type versionedURLKey struct{}
+
+// restoreVersionedPath puts back the URL the client requested once a request has been routed to a version.
+func restoreVersionedPath(c *gin.Context) {
+	if requested, ok := c.Request.Context().Value(versionedURLKey{}).(*neturl.URL); ok {
+		c.Request.URL = requested
+	}
+}
+
+// versionedRoute is a method and path served by several versions of handlers.
+type versionedRoute struct {
+	method         string
+	segments       []string
+	defaultVersion string
+}
+
+// versionRouter routes requests to the version of a handler named by their Accept or version header, sending them to
+// the path the version's handler is registered on.
+type versionRouter struct {
+	next    http.Handler
+	routing string
+	header  string
+	routes  []*versionedRoute
+}
+
+// newVersionRouter returns the router for versions routed by header, or the handler if they are routed by path.
+func newVersionRouter(cfg *Config, next http.Handler) http.Handler {
+	routing := cfg.APIVersioning.routing()
+	if routing == VersionByPath {
+		return next
+	}
+
+	router := &versionRouter{next: next, routing: routing, header: headerAccept}
+	if routing == VersionByHeader {
+		router.header = DefaultVersionHeader
+		if cfg.APIVersioning.Header != "" {
+			router.header = cfg.APIVersioning.Header
+		}
+	}
+
+	routes := make(map[string]bool)
+	for _, handler := range cfg.Handlers {
+		key := routeKey(handler.Method, handler.Path)
+		if handler.APIVersion == "" || routes[key] {
+			continue
+		}
+		routes[key] = true
+
+		defaultVersion := normaliseVersion(cfg.APIVersioning.DefaultVersion)
+		if defaultVersion == "" {
+			defaultVersion = normaliseVersion(handler.APIVersion)
+		}
+		router.routes = append(router.routes, &versionedRoute{method: handler.Method,
+			segments: pathSegments(handler.Path), defaultVersion: defaultVersion})
+	}
+
+	if len(router.routes) == 0 {
+		return next
+	}
+
+	return router
+}
+
+func (v *versionRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, versionedPathPrefix) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	route := v.match(r.Method, r.URL.Path)
+	if route == nil {
+		v.next.ServeHTTP(w, r)
+
+		return
+	}
+
+	version := v.requestedVersion(r)
+	if version == "" {
+		version = route.defaultVersion
+	}
+
+	w.Header().Add(headerVary, v.header)
+
+	routed := r.WithContext(context.WithValue(r.Context(), versionedURLKey{}, r.URL))
+	routed.URL = &neturl.URL{}
+	*routed.URL = *r.URL
+	routed.URL.Path = joinPaths(versionedPathPrefix+version, r.URL.Path)
+	routed.URL.RawPath = ""
+
+	v.next.ServeHTTP(w, routed)
+}
+
+// match returns the first versioned route matching the method and path. Requests for which no version of a handler
+// is registered for their method are served unversioned.
+func (v *versionRouter) match(method string, path string) *versionedRoute {
+	segments := pathSegments(path)
+	for _, route := range v.routes {
+		if (route.method == method || route.method == AnyMethod) && matchSegments(route.segments, segments) {
+			return route
+		}
+	}
+
+	return nil
+}
+
+// requestedVersion returns the version named by the request or an empty string if it names none.
+func (v *versionRouter) requestedVersion(r *http.Request) string {
+	if v.routing == VersionByHeader {
+		return normaliseVersion(r.Header.Get(v.header))
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get(headerAccept), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+
+		if version, found := params["version"]; found {
+			return normaliseVersion(version)
+		}
+
+		if match := acceptVersion.FindStringSubmatch(mediaType); match != nil {
+			return normaliseVersion(match[1])
+		}
+	}
+
+	return ""
+}
+
+// pathSegments splits a path into its segments, ignoring leading and trailing slashes.
+func pathSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// matchSegments reports whether the segments of a path match those of a gin route e.g. /users/:id or /files/*path.
+func matchSegments(route []string, path []string) bool {
+	for i, segment := range route {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+
+		if i >= len(path) {
+			return false
+		}
+
+		if strings.HasPrefix(segment, ":") {
+			if path[i] == "" {
+				return false
+			}
+
+			continue
+		}
+
+		if segment != path[i] {
+			return false
+		}
+	}
+
+	return len(route) == len(path)
+}
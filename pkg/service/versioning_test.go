@@ -0,0 +1,262 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// apiVersionHandler responds with the version, the id parameter and the path requested.
+func apiVersionHandler(version string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.String(http.StatusOK, "v%s %s %s", version, c.Param("id"), c.Request.URL.Path)
+	}
+}
+
+func versionedConfig(versioning *APIVersioningConfig) Config {
+	return Config{
+		ListenAddress: ":8888",
+		APIVersioning: versioning,
+		Handlers: []Handler{
+			{Method: http.MethodGet, Path: "/users/:id", APIVersion: "1", Handler: apiVersionHandler("1")},
+			{Method: http.MethodGet, Path: "/users/:id", APIVersion: "v2", Handler: apiVersionHandler("2")},
+			{Method: http.MethodGet, Path: testEndpoint, Handler: helloWorldHandler()},
+		},
+	}
+}
+
+func checkBody(t *testing.T, svc *Service, url string, code int, body string, reqHeaders ...headers) {
+	t.Helper()
+
+	rr, err := sendRequest(svc, http.MethodGet, url, reqHeaders...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != code || (body != "" && rr.Body.String() != body) {
+		t.Errorf("Expected %s to respond %d %q but got %d %q.", url, code, body, rr.Code, rr.Body.String())
+	}
+}
+
+func TestVersionByPath(t *testing.T) {
+	cfg := versionedConfig(nil)
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBody(t, svc, "/v1/users/7", http.StatusOK, "v1 7 /v1/users/7")
+	checkBody(t, svc, "/v2/users/7", http.StatusOK, "v2 7 /v2/users/7")
+	checkBody(t, svc, "/users/7", http.StatusNotFound, "")
+	checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.")
+}
+
+func TestVersionByHeader(t *testing.T) {
+	cfg := versionedConfig(&APIVersioningConfig{Routing: VersionByHeader})
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBody(t, svc, "/users/7", http.StatusOK, "v2 7 /users/7", headers{Name: DefaultVersionHeader, Value: "2"})
+	checkBody(t, svc, "/users/7", http.StatusOK, "v1 7 /users/7")
+	checkBody(t, svc, "/users/7", http.StatusNotFound, "", headers{Name: DefaultVersionHeader, Value: "3"})
+	checkBody(t, svc, "/_version/2/users/7", http.StatusNotFound, "")
+	checkBody(t, svc, "/v2/users/7", http.StatusNotFound, "")
+	checkBody(t, svc, testEndpoint, http.StatusOK, "Hello World.", headers{Name: DefaultVersionHeader, Value: "2"})
+
+	rr, err := sendRequest(svc, http.MethodGet, "/users/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vary := rr.Header().Get(headerVary); vary != DefaultVersionHeader {
+		t.Errorf("Expected the response to vary by the version header but got %q.", vary)
+	}
+}
+
+func TestVersionByHeaderDefaultVersion(t *testing.T) {
+	cfg := versionedConfig(&APIVersioningConfig{Routing: VersionByHeader, Header: "X-Version", DefaultVersion: "v2"})
+	cfg.Router = NewServeMuxRouter()
+	for i := range cfg.Handlers {
+		version := cfg.Handlers[i].APIVersion
+		cfg.Handlers[i].Handler = nil
+		cfg.Handlers[i].HTTPHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(version + " " + r.PathValue("id") + " " + r.URL.Path))
+		})
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBody(t, svc, "/users/7", http.StatusOK, "v2 7 /users/7")
+	checkBody(t, svc, "/users/7", http.StatusOK, "1 7 /users/7", headers{Name: "X-Version", Value: "1"})
+}
+
+func TestVersionByHeaderUnversionedMethodOnVersionedPath(t *testing.T) {
+	for name, router := range map[string]Router{"gin": nil, "mux": NewServeMuxRouter()} {
+		t.Run(name, func(t *testing.T) {
+			respond := func(body string) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(body + " " + r.URL.Path))
+				})
+			}
+
+			cfg := Config{
+				ListenAddress: ":8888",
+				Router:        router,
+				APIVersioning: &APIVersioningConfig{Routing: VersionByHeader},
+				Handlers: []Handler{
+					{Method: http.MethodGet, Path: "/users", APIVersion: "1", HTTPHandler: respond("list v1")},
+					{Method: http.MethodPost, Path: "/users", HTTPHandler: respond("create")},
+				},
+			}
+
+			svc, err := NewService(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, test := range []struct {
+				method  string
+				version string
+				body    string
+			}{
+				{method: http.MethodGet, version: "1", body: "list v1 /users"},
+				{method: http.MethodGet, body: "list v1 /users"},
+				{method: http.MethodPost, version: "1", body: "create /users"},
+				{method: http.MethodPost, body: "create /users"},
+			} {
+				version := headers{Name: DefaultVersionHeader, Value: test.version}
+				rr, err := sendRequest(svc, test.method, "/users", version)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if rr.Code != http.StatusOK || rr.Body.String() != test.body {
+					t.Errorf("Expected %s /users with version %q to respond %q but got %d %q.", test.method,
+						test.version, test.body, rr.Code, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestVersionByAccept(t *testing.T) {
+	cfg := versionedConfig(&APIVersioningConfig{Routing: VersionByAccept})
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for accept, version := range map[string]string{
+		"application/vnd.example.v2+json":                  "2",
+		"application/json; version=2":                      "2",
+		"application/vnd.example.v1+json, application/xml": "1",
+		"application/vnd.example+json":                     "1",
+		"":                                                 "1",
+	} {
+		body := "v" + version + " 7 /users/7"
+		checkBody(t, svc, "/users/7", http.StatusOK, body, headers{Name: headerAccept, Value: accept})
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	deprecated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Now().Add(time.Hour)
+	cfg := versionedConfig(nil)
+	cfg.Handlers[0].Deprecation = &DeprecationConfig{
+		Date:      deprecated,
+		Sunset:    sunset,
+		Link:      "https://example.com/deprecation",
+		Successor: "/v2/users",
+	}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := deprecatedCalls.WithLabelValues(http.MethodGet, "/users/:id", "1", "false")
+	before := testutil.ToFloat64(counter)
+
+	rr, err := sendRequest(svc, http.MethodGet, "/v1/users/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("A deprecated handler should be served before its sunset but got %d.", rr.Code)
+	}
+
+	if value := rr.Header().Get(headerDeprecation); value != "@"+strconv.FormatInt(deprecated.Unix(), 10) {
+		t.Errorf("Unexpected Deprecation header %q.", value)
+	}
+
+	if value := rr.Header().Get(headerSunset); value != sunset.UTC().Format(http.TimeFormat) {
+		t.Errorf("Unexpected Sunset header %q.", value)
+	}
+
+	if links := rr.Header().Values(headerLink); len(links) != 2 ||
+		links[0] != `<https://example.com/deprecation>; rel="deprecation"; type="text/html"` ||
+		links[1] != `</v2/users>; rel="successor-version"` {
+		t.Errorf("Unexpected Link headers %v.", links)
+	}
+
+	if value := testutil.ToFloat64(counter); value != before+1 {
+		t.Errorf("Expected the deprecated call to be counted but the count went from %f to %f.", before, value)
+	}
+
+	rr, err = sendRequest(svc, http.MethodGet, "/v2/users/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get(headerDeprecation) != "" {
+		t.Error("Only the deprecated version should be marked deprecated.")
+	}
+}
+
+func TestDeprecationRejectAfterSunset(t *testing.T) {
+	cfg := versionedConfig(nil)
+	cfg.Handlers[0].Deprecation = &DeprecationConfig{Sunset: time.Now().Add(-time.Hour), RejectAfterSunset: true}
+
+	svc, err := NewService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := sendRequest(svc, http.MethodGet, "/v1/users/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusGone || rr.Header().Get(headerDeprecation) != "true" {
+		t.Errorf("Expected a 410 after the sunset but got %d %v.", rr.Code, rr.Header())
+	}
+
+	counter := deprecatedCalls.WithLabelValues(http.MethodGet, "/users/:id", "1", "true")
+	if value := testutil.ToFloat64(counter); value < 1 {
+		t.Error("Expected the rejected call to be counted.")
+	}
+}
+
+func TestValidateVersioning(t *testing.T) {
+	cfg := versionedConfig(&APIVersioningConfig{Routing: "query"})
+	cfg.Handlers[1].APIVersion = "v"
+	cfg.Handlers[0].Deprecation = &DeprecationConfig{RejectAfterSunset: true}
+
+	err := cfg.Validate()
+	for _, target := range []error{errInvalidVersionRouting, errInvalidAPIVersion, errSunsetRequired} {
+		if !errors.Is(err, target) {
+			t.Errorf("Expected %q but got %v.", target, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebSocketReadLimit    = 64 * 1024
+	defaultWebSocketPingInterval = 30 * time.Second
+	webSocketWriteWait           = 10 * time.Second
+	webSocketCloseGracePeriod    = time.Second
+)
+
+var errWebSocketNoHandler = errors.New("websocket config has no handler")
+
+// WebSocketConfig specifies how connections to a WebSocket handler are upgraded and kept alive.
+type WebSocketConfig struct {
+	// Handler runs the connection, which is closed when it returns. The request context of c is cancelled when the
+	// service shuts down. The connection must be read from for pongs and close messages to be processed.
+	Handler           func(c *gin.Context, conn *websocket.Conn)
+	ReadLimit         int64                      // Optional - the maximum message size in bytes. Default 64KiB.
+	PingInterval      time.Duration              // Optional - how often pings are sent. Default 30 seconds.
+	PongTimeout       time.Duration              // Optional - how long to wait for a pong. Default twice the interval.
+	CheckOrigin       func(r *http.Request) bool // Optional - default only allows requests from the same origin.
+	Subprotocols      []string                   // Optional - the supported subprotocols in order of preference.
+	EnableCompression bool                       // If true, per message compression is negotiated.
+}
+
+// webSocketHandler returns the handler upgrading the request and running the connection with pings and the read limit
+// applied. On shutdown the client is sent a going away close message.
+func webSocketHandler(config *WebSocketConfig, tracker *streams) (gin.HandlerFunc, error) {
+	if config.Handler == nil {
+		return nil, errWebSocketNoHandler
+	}
+
+	readLimit := config.ReadLimit
+	if readLimit <= 0 {
+		readLimit = defaultWebSocketReadLimit
+	}
+
+	pingInterval := config.PingInterval
+	if pingInterval <= 0 {
+		pingInterval = defaultWebSocketPingInterval
+	}
+
+	pongTimeout := config.PongTimeout
+	if pongTimeout <= 0 {
+		pongTimeout = 2 * pingInterval
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin:       config.CheckOrigin,
+		Subprotocols:      config.Subprotocols,
+		EnableCompression: config.EnableCompression,
+	}
+
+	return func(c *gin.Context) {
+		done, ok := tracker.add(streamTypeWebSocket)
+		if !ok {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+
+			return
+		}
+		defer done()
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already written the error response.
+			logrus.Debugf("WebSocket upgrade failed: %s", err)
+
+			return
+		}
+		defer conn.Close()
+
+		conn.SetReadLimit(readLimit)
+		extendReadDeadline(conn, pongTimeout)
+		conn.SetPongHandler(func(string) error {
+			select {
+			case <-tracker.shuttingDown():
+				// Leave the short deadline set on shutdown in place.
+			default:
+				extendReadDeadline(conn, pongTimeout)
+			}
+
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		go keepWebSocketAlive(ctx, cancel, conn, pingInterval, tracker.shuttingDown())
+
+		config.Handler(c, conn)
+	}, nil
+}
+
+// keepWebSocketAlive pings the client until the context is cancelled. When the service shuts down the client is sent
+// a close message and reads are given a short time to receive the reply before they fail.
+func keepWebSocketAlive(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, interval time.Duration,
+	shuttingDown <-chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteWait)); err != nil {
+				logrus.Debugf("Unable to ping WebSocket client: %s", err)
+			}
+		case <-shuttingDown:
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(webSocketWriteWait)); err != nil {
+				logrus.Debugf("Unable to send close to WebSocket client: %s", err)
+			}
+			extendReadDeadline(conn, webSocketCloseGracePeriod)
+			cancel()
+
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func extendReadDeadline(conn *websocket.Conn, timeout time.Duration) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		logrus.Debugf("Unable to set WebSocket read deadline: %s", err)
+	}
+}
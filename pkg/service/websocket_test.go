@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// echoWebSocketHandler echoes messages until the connection fails.
+func echoWebSocketHandler(c *gin.Context, conn *websocket.Conn) {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
+
+func TestWebSocketEchoAndReadLimit(t *testing.T) {
+	svc, addr, result := streamingService(t, Handler{Method: http.MethodGet, Path: "/ws", WebSocket: &WebSocketConfig{
+		Handler:   echoWebSocketHandler,
+		ReadLimit: 16,
+	}})
+	defer func() {
+		svc.Stop()
+		<-result
+	}()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, message, err := conn.ReadMessage()
+	if err != nil || string(message) != "hello" {
+		t.Fatalf("Unexpected echo <%s>: %v", message, err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 32))); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected the connection to be closed as the message is too big but got %v.", err)
+	}
+}
+
+func TestWebSocketPingAndShutdown(t *testing.T) {
+	svc, addr, result := streamingService(t, Handler{Method: http.MethodGet, Path: "/ws", WebSocket: &WebSocketConfig{
+		Handler:      echoWebSocketHandler,
+		PingInterval: 20 * time.Millisecond,
+	}})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var pings int32
+	pinged := make(chan struct{})
+	conn.SetPingHandler(func(data string) error {
+		if atomic.AddInt32(&pings, 1) == 1 {
+			close(pinged)
+		}
+
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				readErr <- err
+
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-pinged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a ping.")
+	}
+
+	if opened := testutil.ToFloat64(openStreams.WithLabelValues(streamTypeWebSocket)); opened != 1 {
+		t.Errorf("Expected 1 open stream but got %v.", opened)
+	}
+
+	svc.Stop()
+
+	var closeErr *websocket.CloseError
+	if err := <-readErr; !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("Expected a going away close but got %v.", err)
+	}
+
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s.", err)
+	}
+
+	if opened := testutil.ToFloat64(openStreams.WithLabelValues(streamTypeWebSocket)); opened != 0 {
+		t.Errorf("Expected no open streams but got %v.", opened)
+	}
+}
+
+func TestWebSocketWithoutHandlerErrors(t *testing.T) {
+	cfg := Config{
+		ListenAddress: ":8888",
+		Handlers:      []Handler{{Method: http.MethodGet, Path: "/ws", WebSocket: &WebSocketConfig{}}},
+	}
+
+	if _, err := NewService(&cfg); err == nil {
+		t.Error("A WebSocket config without a handler should cause an error.")
+	}
+}
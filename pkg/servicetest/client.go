@@ -0,0 +1,202 @@
+package servicetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request is a request being built to send to the service under test.
+type Request struct {
+	server *Server
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// Response is the response from the service under test. Assertion failures are reported with Errorf so that every
+// failing assertion in a chain is reported.
+type Response struct {
+	*http.Response
+	Body []byte // The whole response body.
+
+	server *Server
+}
+
+// Request starts building a request with the given method and path.
+func (s *Server) Request(method string, path string) *Request {
+	return &Request{server: s, method: method, path: path, query: make(url.Values), header: make(http.Header)}
+}
+
+// Get starts building a GET request.
+func (s *Server) Get(path string) *Request {
+	return s.Request(http.MethodGet, path)
+}
+
+// Post starts building a POST request.
+func (s *Server) Post(path string) *Request {
+	return s.Request(http.MethodPost, path)
+}
+
+// Put starts building a PUT request.
+func (s *Server) Put(path string) *Request {
+	return s.Request(http.MethodPut, path)
+}
+
+// Patch starts building a PATCH request.
+func (s *Server) Patch(path string) *Request {
+	return s.Request(http.MethodPatch, path)
+}
+
+// Delete starts building a DELETE request.
+func (s *Server) Delete(path string) *Request {
+	return s.Request(http.MethodDelete, path)
+}
+
+// Header sets a request header.
+func (r *Request) Header(name string, value string) *Request {
+	r.header.Set(name, value)
+
+	return r
+}
+
+// Query adds a query parameter.
+func (r *Request) Query(name string, value string) *Request {
+	r.query.Add(name, value)
+
+	return r
+}
+
+// Body sets the request body.
+func (r *Request) Body(body string) *Request {
+	r.body = []byte(body)
+
+	return r
+}
+
+// JSON sets the request body to the value encoded as JSON along with the content type.
+func (r *Request) JSON(value any) *Request {
+	r.server.t.Helper()
+
+	body, err := json.Marshal(value)
+	if err != nil {
+		r.server.t.Fatalf("Unable to encode the request body: %s", err)
+	}
+	r.body = body
+
+	return r.Header("Content-Type", "application/json")
+}
+
+// Do sends the request and reads the whole response. Failing to send the request fails the test.
+func (r *Request) Do() *Response {
+	t := r.server.t
+	t.Helper()
+
+	target := r.server.URL + r.path
+	if len(r.query) > 0 {
+		separator := "?"
+		if strings.Contains(r.path, "?") {
+			separator = "&"
+		}
+		target += separator + r.query.Encode()
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequest(r.method, target, body) //nolint:noctx // the client timeout applies
+	if err != nil {
+		t.Fatalf("Unable to create the request: %s", err)
+	}
+	req.Header = r.header
+
+	resp, err := r.server.Client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %s", r.method, r.path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Unable to read the response to %s %s: %s", r.method, r.path, err)
+	}
+
+	return &Response{Response: resp, Body: respBody, server: r.server}
+}
+
+// Status checks the response status code.
+func (r *Response) Status(code int) *Response {
+	r.server.t.Helper()
+
+	if r.StatusCode != code {
+		r.server.t.Errorf("%s %s: expected status %d but got %d <%s>.", r.Request.Method, r.Request.URL.Path, code,
+			r.StatusCode, r.Body)
+	}
+
+	return r
+}
+
+// Header checks a response header has the value.
+func (r *Response) Header(name string, value string) *Response {
+	r.server.t.Helper()
+
+	if actual := r.Response.Header.Get(name); actual != value {
+		r.server.t.Errorf("%s %s: expected header %s to be <%s> but got <%s>.", r.Request.Method, r.Request.URL.Path,
+			name, value, actual)
+	}
+
+	return r
+}
+
+// HeaderPresent checks a response header is set.
+func (r *Response) HeaderPresent(name string) *Response {
+	r.server.t.Helper()
+
+	if _, found := r.Response.Header[http.CanonicalHeaderKey(name)]; !found {
+		r.server.t.Errorf("%s %s: expected header %s to be set.", r.Request.Method, r.Request.URL.Path, name)
+	}
+
+	return r
+}
+
+// BodyEquals checks the response body.
+func (r *Response) BodyEquals(body string) *Response {
+	r.server.t.Helper()
+
+	if string(r.Body) != body {
+		r.server.t.Errorf("%s %s: expected body <%s> but got <%s>.", r.Request.Method, r.Request.URL.Path, body, r.Body)
+	}
+
+	return r
+}
+
+// BodyContains checks the response body contains the text.
+func (r *Response) BodyContains(text string) *Response {
+	r.server.t.Helper()
+
+	if !bytes.Contains(r.Body, []byte(text)) {
+		r.server.t.Errorf("%s %s: expected body to contain <%s> but got <%s>.", r.Request.Method, r.Request.URL.Path,
+			text, r.Body)
+	}
+
+	return r
+}
+
+// JSON decodes the response body into the value. Failing to decode it fails the test.
+func (r *Response) JSON(value any) *Response {
+	r.server.t.Helper()
+
+	if err := json.Unmarshal(r.Body, value); err != nil {
+		r.server.t.Fatalf("%s %s: unable to decode the response body <%s>: %s", r.Request.Method, r.Request.URL.Path,
+			r.Body, err)
+	}
+
+	return r
+}
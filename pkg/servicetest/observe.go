@@ -0,0 +1,134 @@
+package servicetest
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// LogEntries returns the log entries captured since the server was created.
+func (s *Server) LogEntries() []logrus.Entry {
+	entries := s.logs.AllEntries()
+	result := make([]logrus.Entry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+
+	return result
+}
+
+// Logged reports whether a log entry at the level or more severe contains the text in its message or fields.
+func (s *Server) Logged(level logrus.Level, text string) bool {
+	for _, entry := range s.logs.AllEntries() {
+		if entry.Level > level {
+			continue
+		}
+
+		if strings.Contains(entry.Message, text) {
+			return true
+		}
+
+		for _, value := range entry.Data {
+			if value, ok := value.(string); ok && strings.Contains(value, text) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ExpectLogged checks that a log entry at the level or more severe contains the text.
+func (s *Server) ExpectLogged(level logrus.Level, text string) {
+	s.t.Helper()
+
+	if !s.Logged(level, text) {
+		s.t.Errorf("Expected a %s log entry containing <%s>.", level, text)
+	}
+}
+
+// Metric returns the value of the metric with the labels in the default registry. Counters, gauges and untyped
+// metrics return their value and summaries and histograms their sample count. A metric which has not been recorded is 0.
+func (s *Server) Metric(name string, labels prometheus.Labels) float64 {
+	s.t.Helper()
+
+	return gatherMetrics(s.t)[metricKey(name, labels)]
+}
+
+// MetricDelta returns how much the metric has changed since the server was created. Metrics are registered globally so
+// this isolates a test from the values recorded by other tests.
+func (s *Server) MetricDelta(name string, labels prometheus.Labels) float64 {
+	s.t.Helper()
+
+	return s.Metric(name, labels) - s.metrics[metricKey(name, labels)]
+}
+
+// ExpectMetricDelta checks how much the metric has changed since the server was created.
+func (s *Server) ExpectMetricDelta(name string, labels prometheus.Labels, delta float64) {
+	s.t.Helper()
+
+	if actual := s.MetricDelta(name, labels); actual != delta {
+		s.t.Errorf("Expected metric %s%v to change by %v but it changed by %v.", name, labels, delta, actual)
+	}
+}
+
+// gatherMetrics returns the value of every metric in the default registry keyed by name and labels.
+func gatherMetrics(t testing.TB) map[string]float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Unable to gather metrics: %s", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(prometheus.Labels)
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			values[metricKey(family.GetName(), labels)] = metricValue(metric)
+		}
+	}
+
+	return values
+}
+
+func metricValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.GetCounter() != nil:
+		return metric.GetCounter().GetValue()
+	case metric.GetGauge() != nil:
+		return metric.GetGauge().GetValue()
+	case metric.GetUntyped() != nil:
+		return metric.GetUntyped().GetValue()
+	case metric.GetHistogram() != nil:
+		return float64(metric.GetHistogram().GetSampleCount())
+	case metric.GetSummary() != nil:
+		return float64(metric.GetSummary().GetSampleCount())
+	default:
+		return 0
+	}
+}
+
+// metricKey identifies a metric by its name and sorted labels.
+func metricKey(name string, labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	key.WriteString(name)
+	for _, label := range names {
+		key.WriteString("|" + label + "=" + labels[label])
+	}
+
+	return key.String()
+}
@@ -0,0 +1,242 @@
+// Package servicetest provides facilities for testing a service built from a service.Config.
+package servicetest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/go-libs/pkg/certificate"
+	"github.com/puppetlabs/go-libs/pkg/service"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+const (
+	inMemoryURL          = "http://service.test"
+	inMemoryRemoteAddr   = "127.0.0.1:1234"
+	defaultClientTimeout = 10 * time.Second
+	clientCommonName     = "servicetest client"
+)
+
+var (
+	errNoCACertificate = errors.New("unable to add the CA certificate to the pool")
+	errNotListening    = errors.New("the service is not listening on a port")
+)
+
+// Options specifies how the service under test is run.
+type Options struct {
+	TLS       bool          // If true, the service is served with a certificate signed by a throwaway CA.
+	MutualTLS bool          // If true, TLS is used and the service requires a client certificate signed by the CA.
+	Timeout   time.Duration // Optional - the client timeout. Default is 10 seconds.
+}
+
+// Server is a service under test along with a client for it. Log entries and metric values are captured from the
+// moment it is created. Logs are captured from the standard logrus logger so tests using a Server must not run in
+// parallel.
+type Server struct {
+	Service    *service.Service     // The service under test.
+	URL        string               // The base URL requests are sent to.
+	Client     *http.Client         // A client configured to trust the CA and present the client certificate if any.
+	CA         *certificate.KeyPair // The throwaway CA if TLS is used.
+	ClientCert *certificate.KeyPair // The client certificate if mutual TLS is used.
+
+	t       testing.TB
+	addr    string
+	logs    *test.Hook
+	metrics map[string]float64
+}
+
+// New builds the service and serves requests by calling its handler directly, without binding a port. Streaming
+// responses are only returned once the handler has finished so SSE and WebSocket handlers need Start.
+func New(t testing.TB, cfg *service.Config) *Server {
+	t.Helper()
+
+	if cfg.ListenAddress == "" && cfg.Listener == nil {
+		cfg.ListenAddress = "127.0.0.1:0"
+	}
+
+	server := newServer(t)
+
+	svc, err := service.NewService(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create the service: %s", err)
+	}
+
+	server.Service = svc
+	server.URL = inMemoryURL
+	server.Client = &http.Client{Transport: handlerTransport{handler: svc.Handler}}
+
+	return server
+}
+
+// Start builds the service and runs it on a random loopback port. The service is stopped when the test finishes. The
+// config is updated with the listener and, if TLS is used, with the certificate.
+func Start(t testing.TB, cfg *service.Config, opts Options) *Server {
+	t.Helper()
+
+	server := newServer(t)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // always a *http.Transport
+	scheme := "http"
+	if opts.TLS || opts.MutualTLS {
+		scheme = "https"
+		tlsConfig, err := server.setupTLS(cfg, opts.MutualTLS)
+		if err != nil {
+			t.Fatalf("Unable to set up TLS: %s", err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	cfg.Listener = listener
+
+	svc, err := service.NewService(cfg)
+	if err != nil {
+		_ = listener.Close()
+		t.Fatalf("Unable to create the service: %s", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- svc.Run()
+	}()
+
+	t.Cleanup(func() {
+		svc.Stop()
+		if err := <-result; err != nil {
+			t.Errorf("The service did not stop cleanly: %s", err)
+		}
+		transport.CloseIdleConnections()
+	})
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultClientTimeout
+	}
+
+	server.Service = svc
+	server.addr = listener.Addr().String()
+	server.URL = fmt.Sprintf("%s://%s", scheme, server.addr)
+	server.Client = &http.Client{Transport: transport, Timeout: timeout}
+
+	return server
+}
+
+func newServer(t testing.TB) *Server {
+	t.Helper()
+
+	server := &Server{t: t, logs: new(test.Hook), metrics: gatherMetrics(t)}
+
+	std := logrus.StandardLogger()
+	previous := make(logrus.LevelHooks)
+	for level, hooks := range std.Hooks {
+		previous[level] = append([]logrus.Hook(nil), hooks...)
+	}
+	std.AddHook(server.logs)
+	t.Cleanup(func() {
+		std.ReplaceHooks(previous)
+	})
+
+	return server
+}
+
+// setupTLS generates the CA and the certificates, sets the server certificate on the config and returns the client
+// TLS config.
+func (s *Server) setupTLS(cfg *service.Config, mutual bool) (*tls.Config, error) {
+	ca, err := certificate.GenerateCA()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	s.CA = ca
+
+	serverCert, err := keyPair(ca, certificate.HostNames{"127.0.0.1", "localhost"}, "localhost")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca.Certificate) {
+		return nil, errNoCACertificate
+	}
+
+	cfg.CertConfig = &service.ServerCertificateConfig{Certificate: serverCert}
+	clientConfig := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+
+	if mutual {
+		clientKeyPair, err := certificate.GenerateSignedCert(ca, nil, clientCommonName)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		s.ClientCert = clientKeyPair
+
+		clientCert, err := tls.X509KeyPair(clientKeyPair.Certificate, clientKeyPair.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		cfg.CertConfig.ClientCAs = pool
+		cfg.CertConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		clientConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return clientConfig, nil
+}
+
+// keyPair generates a certificate signed by the CA and parses it.
+func keyPair(ca *certificate.KeyPair, hostnames certificate.HostNames, commonName string) (*tls.Certificate, error) {
+	pair, err := certificate.GenerateSignedCert(ca, hostnames, commonName)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	cert, err := tls.X509KeyPair(pair.Certificate, pair.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return &cert, nil
+}
+
+// Addr returns the address the service is listening on. It fails the test for a service built with New.
+func (s *Server) Addr() string {
+	s.t.Helper()
+
+	if s.addr == "" {
+		s.t.Fatal(errNotListening)
+	}
+
+	return s.addr
+}
+
+// handlerTransport is a http.RoundTripper which calls the handler directly.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serverReq := req.Clone(req.Context())
+	serverReq.RemoteAddr = inMemoryRemoteAddr
+	serverReq.RequestURI = req.URL.RequestURI()
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, serverReq)
+
+	resp := recorder.Result()
+	resp.Request = req
+
+	return resp, nil
+}
@@ -0,0 +1,108 @@
+package servicetest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/puppetlabs/go-libs/pkg/service"
+	"github.com/sirupsen/logrus"
+)
+
+const testEndpoint = "/hello"
+
+func testConfig() *service.Config {
+	return &service.Config{
+		LogLevel: "info",
+		Handlers: []service.Handler{
+			{Method: http.MethodGet, Path: testEndpoint, Handler: func(c *gin.Context) {
+				logrus.Infof("Saying hello to %s.", c.Query("name"))
+				c.Header("X-Greeting", "hello")
+				c.String(http.StatusOK, "Hello %s.", c.Query("name"))
+			}},
+			{Method: http.MethodPost, Path: "/echo", Handler: func(c *gin.Context) {
+				var body map[string]string
+				if err := c.BindJSON(&body); err != nil {
+					return
+				}
+				c.JSON(http.StatusCreated, body)
+			}},
+		},
+	}
+}
+
+func TestNewServesInMemory(t *testing.T) {
+	server := New(t, testConfig())
+
+	server.Get(testEndpoint).Query("name", "World").Do().
+		Status(http.StatusOK).
+		Header("X-Greeting", "hello").
+		BodyEquals("Hello World.")
+
+	var echoed map[string]string
+	server.Post("/echo").JSON(map[string]string{"key": "value"}).Do().
+		Status(http.StatusCreated).
+		JSON(&echoed)
+
+	if echoed["key"] != "value" {
+		t.Errorf("Unexpected response %v.", echoed)
+	}
+
+	server.Get("/missing").Do().Status(http.StatusNotFound)
+
+	server.ExpectLogged(logrus.InfoLevel, "Saying hello to World.")
+	server.ExpectLogged(logrus.InfoLevel, "/missing")
+}
+
+func TestStartServesOnRandomPort(t *testing.T) {
+	server := Start(t, testConfig(), Options{})
+
+	server.Get(testEndpoint).Query("name", "port").Do().Status(http.StatusOK).BodyContains("port")
+
+	if server.Addr() == "" {
+		t.Error("Expected the address to be set.")
+	}
+}
+
+func TestStartWithTLSAndMutualTLS(t *testing.T) {
+	server := Start(t, testConfig(), Options{TLS: true})
+	resp := server.Get(testEndpoint).Do().Status(http.StatusOK)
+
+	if resp.TLS == nil {
+		t.Error("Expected the response to be served over TLS.")
+	}
+
+	mutual := Start(t, testConfig(), Options{MutualTLS: true})
+	resp = mutual.Get(testEndpoint).Do().Status(http.StatusOK)
+
+	if mutual.ClientCert == nil || resp.TLS == nil {
+		t.Error("Expected a client certificate to be used.")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(mutual.CA.Certificate)
+	withoutCert := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool},
+	}}
+	if resp, err := withoutCert.Get(mutual.URL + testEndpoint); err == nil {
+		resp.Body.Close()
+		t.Error("Expected a request without a client certificate to fail.")
+	}
+}
+
+func TestMetricDelta(t *testing.T) {
+	cfg := testConfig()
+	cfg.Handlers[0].Cache = &service.CacheConfig{Store: service.NewResponseCache("servicetest", 10)}
+	server := New(t, cfg)
+
+	server.Get(testEndpoint).Do().Status(http.StatusOK)
+	server.Get(testEndpoint).Do().Status(http.StatusOK)
+
+	server.ExpectMetricDelta("service_response_cache_requests_total",
+		prometheus.Labels{"cache": "servicetest", "result": "miss"}, 1)
+	server.ExpectMetricDelta("service_response_cache_requests_total",
+		prometheus.Labels{"cache": "servicetest", "result": "hit"}, 1)
+}